@@ -1,8 +1,9 @@
-// TODO: Add a capability for deleting single messages
-// and deleting all the messages in a channel and in a general chat.
 package backend
 
 import (
+	"bytes"
+	"time"
+
 	"github.com/isnastish/chat/pkg/types"
 )
 
@@ -26,9 +27,95 @@ type Backend interface {
 	HasParticipant(username string) bool
 	RegisterParticipant(participant *types.Participant)
 	AuthParticipant(participant *types.Participant) bool
+	// Returns nil if the participant doesn't exist, the password is hashed.
+	GetParticipant(username string) *types.Participant
+	// The password is hashed the same way as when registering. Returns false if the participant doesn't exist.
+	UpdatePassword(username string, password string) bool
+	// Removes the participant together with the data kept for them: channel memberships, read markers,
+	// queued messages, the second factor and API tokens. Authored messages are kept.
+	// Returns false if the participant doesn't exist.
+	DeleteParticipant(username string) bool
+	// Clears participant's Unverified flag. Returns false if the participant doesn't exist.
+	MarkEmailVerified(username string) bool
+	// Replaces all the profile fields at once. Returns false if the participant doesn't exist.
+	UpdateProfile(username string, displayName string, bio string, timezone string) bool
+	// Returns false if the participant doesn't exist.
+	SetLastSeen(username string, lastSeen time.Time) bool
+	// One-time codes, for example password reset tokens, map a code to the participant it was issued to.
+	// Codes should be hashed by the caller, they expire after ttl and can only be redeemed once.
+	StoreOneTimeCode(code string, username string, ttl time.Duration)
+	RedeemOneTimeCode(code string) (string, bool)
+	// The last code sent to a participant for the purpose, used for limiting how often codes are sent.
+	// Returns nil if none was sent, or it expired after ttl.
+	GetSentCode(username string, purpose string) *types.SentCode
+	SetSentCode(username string, purpose string, sent *types.SentCode, ttl time.Duration)
+	// Returns nil if the participant hasn't enrolled in two-factor authentication.
+	GetTwoFactor(username string) *types.TwoFactor
+	SetTwoFactor(username string, twoFactor *types.TwoFactor)
+	// Removes the second factor together with the recovery codes.
+	DeleteTwoFactor(username string)
+	// Replaces all the recovery codes of a participant. Codes should be hashed by the caller,
+	// every code can only be redeemed once.
+	SetRecoveryCodes(username string, codes []string)
+	RedeemRecoveryCode(username string, code string) bool
+	// API tokens are looked up by their hashes. Tokens of a participant are returned in the order they were created.
+	StoreAPIToken(token *types.APIToken)
+	GetAPIToken(hash string) *types.APIToken
+	GetAPITokens(username string) []*types.APIToken
+	// Returns the removed token, nil if the participant doesn't have a token with that id.
+	DeleteAPIToken(username string, id string) *types.APIToken
 	StoreMessage(message *types.ChatMessage)
+	GetMessage(id string) *types.ChatMessage
+	GetReplies(id string) []*types.ChatMessage
+	EditMessage(id string, contents *bytes.Buffer, editTime string) bool
+	// Used for anonymizing the messages of deleted participants.
+	SetMessageSender(id string, sender string) bool
+	// Leaves a tombstone in the history, so the message's position is preserved.
+	DeleteMessage(id string) bool
+	// Removes all the messages in the channels (or in a general chat if none specified) for good.
+	DeleteMessages(channelname ...string)
+	// Remove the messages in a channel (or in a general chat if the name is empty) for good,
+	// and return the number of removed messages. Used for enforcing retention policies.
+	DeleteMessagesBefore(channelname string, before time.Time) int
+	// Keeps only the most recent maxCount messages.
+	TrimMessages(channelname string, maxCount int) int
+	// Metadata of the shared files, the contents are kept in a blob store.
+	StoreAttachment(attachment *types.Attachment)
+	GetAttachment(id string) *types.Attachment
+	DeleteAttachment(id string)
+	// Failed login attempts are tracked per key, for example a username or an ip address,
+	// and expire after ttl unless updated.
+	GetLoginAttempts(key string) *types.LoginAttempts
+	SetLoginAttempts(key string, attempts *types.LoginAttempts, ttl time.Duration)
+	DeleteLoginAttempts(key string)
+	// Every participant can react with the same emoji to a message only once.
+	AddReaction(id string, username string, emoji string) bool
+	RemoveReaction(id string, username string, emoji string) bool
+	// Messages queued for an offline participant, delivered at their next login.
+	// The oldest messages are dropped once the queue holds more than capacity messages.
+	// Enqueueing a message again moves it to the end of the queue, nothing is queued for nonexistent participants.
+	EnqueueMessage(username string, id string, capacity int)
+	// Returns the queued messages in the order they were enqueued,
+	// skipping the ones which were enqueued more than maxAge ago (if maxAge is not zero).
+	GetQueuedMessages(username string, maxAge time.Duration) []*types.ChatMessage
+	DeleteQueuedMessages(username string)
+	// Sequence number of the last message the participant has read in a channel,
+	// the general chat is stored under an empty name. Markers never move backwards.
+	SetReadMarker(username string, channelname string, seq uint64)
+	GetReadMarkers(username string) map[string]uint64
+	// Returns the requested page of the messages matching the query, ranked by relevance,
+	// together with the total number of matches.
+	SearchMessages(query *types.SearchQuery) ([]*types.ChatMessage, int)
 	HasChannel(channelname string) bool
 	RegisterChannel(channel *types.Channel)
+	// Updates channel's name and description, the other fields are ignored.
+	// Renaming a channel keeps its history, members and pins. Returns false if the new name is taken.
+	UpdateChannel(channelname string, channel *types.Channel) bool
+	// Members are listed in channel's Members field.
+	AddChannelMember(channelname string, username string)
+	// Pinned messages are listed in channel's Pins field.
+	PinMessage(channelname string, id string) bool
+	UnpinMessage(channelname string, id string) bool
 	DeleteChannel(channelname string) bool
 	GetChatHistory(channelname ...string) []*types.ChatMessage
 	GetChannels() []*types.Channel
@@ -36,9 +123,9 @@ type Backend interface {
 }
 
 type RedisConfig struct {
-	Password string
-	Username string
-	Endpoint string
+	Password string `yaml:"password"`
+	Username string `yaml:"username"`
+	Endpoint string `yaml:"endpoint"`
 }
 
 type DynamodbConfig struct {
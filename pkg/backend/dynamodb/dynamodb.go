@@ -1,7 +1,9 @@
 package dynamodb
 
 import (
+	"bytes"
 	"sync"
+	"time"
 
 	_ "github.com/aws/aws-sdk-go-v2/aws"
 	_ "github.com/aws/aws-sdk-go-v2/service/dynamodb"
@@ -30,10 +32,175 @@ func (d *dynamodbBackend) AuthParticipant(participant *types.Participant) bool {
 	return false
 }
 
+func (d *dynamodbBackend) GetParticipant(username string) *types.Participant {
+	return nil
+}
+
+func (d *dynamodbBackend) UpdatePassword(username string, password string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) DeleteParticipant(username string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) MarkEmailVerified(username string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) UpdateProfile(username string, displayName string, bio string, timezone string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) SetLastSeen(username string, lastSeen time.Time) bool {
+	return false
+}
+
+func (d *dynamodbBackend) GetTwoFactor(username string) *types.TwoFactor {
+	return nil
+}
+
+func (d *dynamodbBackend) SetTwoFactor(username string, twoFactor *types.TwoFactor) {
+}
+
+func (d *dynamodbBackend) DeleteTwoFactor(username string) {
+}
+
+func (d *dynamodbBackend) SetRecoveryCodes(username string, codes []string) {
+}
+
+func (d *dynamodbBackend) RedeemRecoveryCode(username string, code string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) StoreAPIToken(token *types.APIToken) {
+}
+
+func (d *dynamodbBackend) GetAPIToken(hash string) *types.APIToken {
+	return nil
+}
+
+func (d *dynamodbBackend) GetAPITokens(username string) []*types.APIToken {
+	return nil
+}
+
+func (d *dynamodbBackend) DeleteAPIToken(username string, id string) *types.APIToken {
+	return nil
+}
+
+func (d *dynamodbBackend) StoreOneTimeCode(code string, username string, ttl time.Duration) {
+}
+
+func (d *dynamodbBackend) RedeemOneTimeCode(code string) (string, bool) {
+	return "", false
+}
+
+func (d *dynamodbBackend) GetSentCode(username string, purpose string) *types.SentCode {
+	return nil
+}
+
+func (d *dynamodbBackend) SetSentCode(username string, purpose string, sent *types.SentCode, ttl time.Duration) {
+}
+
 func (d *dynamodbBackend) StoreMessage(message *types.ChatMessage) {
 
 }
 
+func (d *dynamodbBackend) GetMessage(id string) *types.ChatMessage {
+	return nil
+}
+
+func (d *dynamodbBackend) GetReplies(id string) []*types.ChatMessage {
+	return nil
+}
+
+func (d *dynamodbBackend) SetMessageSender(id string, sender string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) EditMessage(id string, contents *bytes.Buffer, editTime string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) DeleteMessage(id string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) DeleteMessages(channelname ...string) {
+}
+
+func (d *dynamodbBackend) DeleteMessagesBefore(channelname string, before time.Time) int {
+	return 0
+}
+
+func (d *dynamodbBackend) TrimMessages(channelname string, maxCount int) int {
+	return 0
+}
+
+func (d *dynamodbBackend) StoreAttachment(attachment *types.Attachment) {
+}
+
+func (d *dynamodbBackend) GetAttachment(id string) *types.Attachment {
+	return nil
+}
+
+func (d *dynamodbBackend) DeleteAttachment(id string) {
+}
+
+func (d *dynamodbBackend) AddReaction(id string, username string, emoji string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) RemoveReaction(id string, username string, emoji string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) EnqueueMessage(username string, id string, capacity int) {
+}
+
+func (d *dynamodbBackend) GetQueuedMessages(username string, maxAge time.Duration) []*types.ChatMessage {
+	return nil
+}
+
+func (d *dynamodbBackend) DeleteQueuedMessages(username string) {
+}
+
+func (d *dynamodbBackend) UpdateChannel(channelname string, channel *types.Channel) bool {
+	return false
+}
+
+func (d *dynamodbBackend) AddChannelMember(channelname string, username string) {
+}
+
+func (d *dynamodbBackend) PinMessage(channelname string, id string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) UnpinMessage(channelname string, id string) bool {
+	return false
+}
+
+func (d *dynamodbBackend) GetLoginAttempts(key string) *types.LoginAttempts {
+	return nil
+}
+
+func (d *dynamodbBackend) SetLoginAttempts(key string, attempts *types.LoginAttempts, ttl time.Duration) {
+}
+
+func (d *dynamodbBackend) DeleteLoginAttempts(key string) {
+}
+
+func (d *dynamodbBackend) SetReadMarker(username string, channelname string, seq uint64) {
+}
+
+func (d *dynamodbBackend) GetReadMarkers(username string) map[string]uint64 {
+	return nil
+}
+
+func (d *dynamodbBackend) SearchMessages(query *types.SearchQuery) ([]*types.ChatMessage, int) {
+	return nil, 0
+}
+
 func (d *dynamodbBackend) HasChannel(channelname string) bool {
 	return false
 }
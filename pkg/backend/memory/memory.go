@@ -2,37 +2,108 @@ package memory
 
 import (
 	"bytes"
+	"sort"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/isnastish/chat/pkg/backend"
+	"github.com/isnastish/chat/pkg/canonical"
 	"github.com/isnastish/chat/pkg/logger"
 	"github.com/isnastish/chat/pkg/types"
 	"github.com/isnastish/chat/pkg/utilities"
 	"github.com/isnastish/chat/pkg/validation"
 )
 
+type queuedMessage struct {
+	id          string
+	enqueueTime time.Time
+}
+
+type loginAttempts struct {
+	attempts types.LoginAttempts
+	expires  time.Time
+}
+
+type oneTimeCode struct {
+	username string
+	expires  time.Time
+}
+
+type sentCode struct {
+	sent    types.SentCode
+	expires time.Time
+}
+
 type memoryBackend struct {
 	participants map[string]*types.Participant
 	chatHistory  []*types.ChatMessage
 	channels     map[string]*types.Channel
+	// Last sequence number assigned in each channel, the general chat is stored under an empty name.
+	sequences map[string]uint64
+	// All the messages (general chat's and channels') indexed by their ids.
+	messages map[string]*types.ChatMessage
+	// Replies to each message which started a thread, in the order they were stored.
+	replies map[string][]*types.ChatMessage
+	// Participants who reacted to a message, keyed by message's id and then by emoji.
+	reactions map[string]map[string]map[string]bool
+	// Messages queued for offline participants, in the order they were enqueued.
+	// The data of participants is keyed by the canonical keys of their names, the same way as participants themselves.
+	queues map[string][]queuedMessage
+	// Inverted index used for searching, maps a term to the number of its occurrences in each message.
+	index map[string]map[string]int
+	// Read markers of each participant, keyed by username and then by channel's name.
+	markers map[string]map[string]uint64
+	// Metadata of the shared files indexed by their ids.
+	attachments map[string]*types.Attachment
+	// Failed login attempts keyed by username or ip address.
+	loginAttempts map[string]loginAttempts
+	// One-time codes mapped to the participants they were issued to.
+	codes map[string]oneTimeCode
+	// The last code sent to each participant, keyed by username and then by purpose.
+	sentCodes map[string]map[string]sentCode
+	// Second factors and unused recovery codes keyed by username.
+	twoFactors    map[string]types.TwoFactor
+	recoveryCodes map[string]map[string]bool
+	// API tokens keyed by their hashes.
+	apiTokens map[string]*types.APIToken
 	sync.RWMutex
 }
 
 func NewMemoryBackend() *memoryBackend {
 	return &memoryBackend{
-		participants: make(map[string]*types.Participant),
-		chatHistory:  make([]*types.ChatMessage, 0, 1024),
-		channels:     make(map[string]*types.Channel),
+		participants:  make(map[string]*types.Participant),
+		chatHistory:   make([]*types.ChatMessage, 0, 1024),
+		channels:      make(map[string]*types.Channel),
+		sequences:     make(map[string]uint64),
+		messages:      make(map[string]*types.ChatMessage),
+		replies:       make(map[string][]*types.ChatMessage),
+		reactions:     make(map[string]map[string]map[string]bool),
+		queues:        make(map[string][]queuedMessage),
+		index:         make(map[string]map[string]int),
+		markers:       make(map[string]map[string]uint64),
+		attachments:   make(map[string]*types.Attachment),
+		loginAttempts: make(map[string]loginAttempts),
+		codes:         make(map[string]oneTimeCode),
+		sentCodes:     make(map[string]map[string]sentCode),
+		twoFactors:    make(map[string]types.TwoFactor),
+		recoveryCodes: make(map[string]map[string]bool),
+		apiTokens:     make(map[string]*types.APIToken),
 	}
 }
 
 func (m *memoryBackend) doesParticipantExist(username string) bool {
-	_, exists := m.participants[username]
+	_, exists := m.participants[canonical.Key(username)]
+	return exists
+}
+
+func (m *memoryBackend) doesMessageExist(id string) bool {
+	_, exists := m.messages[id]
 	return exists
 }
 
 func (m *memoryBackend) doesChannelExist(channelName string) bool {
-	_, exists := m.channels[channelName]
+	_, exists := m.channels[canonical.Key(channelName)]
 	return exists
 }
 
@@ -55,11 +126,17 @@ func (m *memoryBackend) RegisterParticipant(participant *types.Participant) {
 		log.Logger.Panic("Password hash validation failed")
 	}
 
-	m.participants[participant.Username] = &types.Participant{
-		Username: participant.Username,
-		Password: passwordHash,
-		Email:    participant.Email,
-		JoinTime: participant.JoinTime,
+	m.participants[canonical.Key(participant.Username)] = &types.Participant{
+		Username:    participant.Username,
+		Password:    passwordHash,
+		Email:       participant.Email,
+		JoinTime:    participant.JoinTime,
+		Unverified:  participant.Unverified,
+		Registered:  participant.Registered,
+		LastSeen:    participant.LastSeen,
+		DisplayName: participant.DisplayName,
+		Bio:         participant.Bio,
+		Timezone:    participant.Timezone,
 	}
 
 	log.Logger.Info("Registered %s participant", participant.Username)
@@ -69,32 +146,330 @@ func (m *memoryBackend) AuthParticipant(participant *types.Participant) bool {
 	m.RLock()
 	defer m.RUnlock()
 
-	participant, exists := m.participants[participant.Username]
+	registered, exists := m.participants[canonical.Key(participant.Username)]
 	if exists {
 		passwordHash := util.Sha256Checksum([]byte(participant.Password))
-		return strings.EqualFold(participant.Password, passwordHash)
+		return strings.EqualFold(registered.Password, passwordHash)
 	}
 
 	return false
 }
 
-func (m *memoryBackend) StoreMessage(message *types.ChatMessage) {
+func (m *memoryBackend) GetParticipant(username string) *types.Participant {
+	m.RLock()
+	defer m.RUnlock()
+
+	participant, exists := m.participants[canonical.Key(username)]
+	if !exists {
+		return nil
+	}
+	result := *participant
+	return &result
+}
+
+func (m *memoryBackend) UpdatePassword(username string, password string) bool {
 	m.Lock()
 	defer m.Unlock()
 
-	msg := &types.ChatMessage{
-		Contents: bytes.NewBuffer(bytes.Clone(message.Contents.Bytes())),
-		Sender:   message.Sender,
-		Channel:  message.Channel,
-		SentTime: message.SentTime,
+	participant, exists := m.participants[canonical.Key(username)]
+	if !exists {
+		return false
 	}
 
+	passwordHash := util.Sha256Checksum([]byte(password))
+	if !validation.ValidatePasswordSha256(passwordHash) {
+		log.Logger.Panic("Password hash validation failed")
+	}
+
+	// Participants returned by GetParticipants are shared, so the participant is replaced rather than modified.
+	updated := *participant
+	updated.Password = passwordHash
+	m.participants[canonical.Key(username)] = &updated
+	return true
+}
+
+func (m *memoryBackend) DeleteParticipant(username string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	participant, exists := m.participants[canonical.Key(username)]
+	if !exists {
+		return false
+	}
+	username = participant.Username
+	key := canonical.Key(username)
+	delete(m.participants, key)
+	delete(m.markers, key)
+	delete(m.queues, key)
+	delete(m.twoFactors, key)
+	delete(m.recoveryCodes, key)
+	delete(m.sentCodes, key)
+	for hash, token := range m.apiTokens {
+		if canonical.Equal(token.Username, username) {
+			delete(m.apiTokens, hash)
+		}
+	}
+
+	for channelKey, channel := range m.channels {
+		updated := *channel
+		updated.Members = make([]string, 0, len(channel.Members))
+		for _, member := range channel.Members {
+			if !canonical.Equal(member, username) {
+				updated.Members = append(updated.Members, member)
+			}
+		}
+		m.channels[channelKey] = &updated
+	}
+
+	log.Logger.Info("Participant %s was deleted", username)
+	return true
+}
+
+func (m *memoryBackend) MarkEmailVerified(username string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	participant, exists := m.participants[canonical.Key(username)]
+	if !exists {
+		return false
+	}
+
+	updated := *participant
+	updated.Unverified = false
+	m.participants[canonical.Key(username)] = &updated
+	return true
+}
+
+func (m *memoryBackend) UpdateProfile(username string, displayName string, bio string, timezone string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	participant, exists := m.participants[canonical.Key(username)]
+	if !exists {
+		return false
+	}
+
+	updated := *participant
+	updated.DisplayName = displayName
+	updated.Bio = bio
+	updated.Timezone = timezone
+	m.participants[canonical.Key(username)] = &updated
+	return true
+}
+
+func (m *memoryBackend) SetLastSeen(username string, lastSeen time.Time) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	participant, exists := m.participants[canonical.Key(username)]
+	if !exists {
+		return false
+	}
+
+	updated := *participant
+	updated.LastSeen = lastSeen
+	m.participants[canonical.Key(username)] = &updated
+	return true
+}
+
+func (m *memoryBackend) GetTwoFactor(username string) *types.TwoFactor {
+	m.RLock()
+	defer m.RUnlock()
+
+	twoFactor, exists := m.twoFactors[canonical.Key(username)]
+	if !exists {
+		return nil
+	}
+	return &twoFactor
+}
+
+func (m *memoryBackend) SetTwoFactor(username string, twoFactor *types.TwoFactor) {
+	m.Lock()
+	defer m.Unlock()
+	m.twoFactors[canonical.Key(username)] = *twoFactor
+}
+
+func (m *memoryBackend) DeleteTwoFactor(username string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.twoFactors, canonical.Key(username))
+	delete(m.recoveryCodes, canonical.Key(username))
+}
+
+func (m *memoryBackend) SetRecoveryCodes(username string, codes []string) {
+	m.Lock()
+	defer m.Unlock()
+
+	set := make(map[string]bool, len(codes))
+	for _, code := range codes {
+		set[code] = true
+	}
+	m.recoveryCodes[canonical.Key(username)] = set
+}
+
+func (m *memoryBackend) RedeemRecoveryCode(username string, code string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	key := canonical.Key(username)
+	if !m.recoveryCodes[key][code] {
+		return false
+	}
+	delete(m.recoveryCodes[key], code)
+	return true
+}
+
+func (m *memoryBackend) StoreAPIToken(token *types.APIToken) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, exists := m.apiTokens[token.Hash]; exists {
+		log.Logger.Panic("API token %s already exists", token.Id)
+	}
+	stored := *token
+	m.apiTokens[token.Hash] = &stored
+}
+
+func (m *memoryBackend) GetAPIToken(hash string) *types.APIToken {
+	m.RLock()
+	defer m.RUnlock()
+
+	token, exists := m.apiTokens[hash]
+	if !exists {
+		return nil
+	}
+	result := *token
+	return &result
+}
+
+func (m *memoryBackend) GetAPITokens(username string) []*types.APIToken {
+	m.RLock()
+	defer m.RUnlock()
+
+	var tokens []*types.APIToken
+	for _, token := range m.apiTokens {
+		if canonical.Equal(token.Username, username) {
+			result := *token
+			tokens = append(tokens, &result)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+	return tokens
+}
+
+func (m *memoryBackend) DeleteAPIToken(username string, id string) *types.APIToken {
+	m.Lock()
+	defer m.Unlock()
+
+	for hash, token := range m.apiTokens {
+		if canonical.Equal(token.Username, username) && token.Id == id {
+			delete(m.apiTokens, hash)
+			return token
+		}
+	}
+	return nil
+}
+
+func (m *memoryBackend) StoreOneTimeCode(code string, username string, ttl time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	now := time.Now()
+	for c, entry := range m.codes {
+		if !now.Before(entry.expires) {
+			delete(m.codes, c)
+		}
+	}
+	m.codes[code] = oneTimeCode{username: username, expires: now.Add(ttl)}
+}
+
+func (m *memoryBackend) RedeemOneTimeCode(code string) (string, bool) {
+	m.Lock()
+	defer m.Unlock()
+
+	entry, exists := m.codes[code]
+	if !exists {
+		return "", false
+	}
+	delete(m.codes, code)
+	if !time.Now().Before(entry.expires) {
+		return "", false
+	}
+	return entry.username, true
+}
+
+func (m *memoryBackend) GetSentCode(username string, purpose string) *types.SentCode {
+	m.RLock()
+	defer m.RUnlock()
+
+	entry, exists := m.sentCodes[canonical.Key(username)][purpose]
+	if !exists || !time.Now().Before(entry.expires) {
+		return nil
+	}
+	sent := entry.sent
+	return &sent
+}
+
+func (m *memoryBackend) SetSentCode(username string, purpose string, sent *types.SentCode, ttl time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	key := canonical.Key(username)
+	if m.sentCodes[key] == nil {
+		m.sentCodes[key] = make(map[string]sentCode)
+	}
+	m.sentCodes[key][purpose] = sentCode{sent: *sent, expires: time.Now().Add(ttl)}
+}
+
+func (m *memoryBackend) StoreMessage(message *types.ChatMessage) {
+	m.Lock()
+	defer m.Unlock()
+
 	if message.Channel != "" {
-		channel, exists := m.channels[message.Channel]
+		channel, exists := m.channels[canonical.Key(message.Channel)]
 		if !exists {
 			log.Logger.Panic("Failed to store a message, channel %s doesn't exist", message.Channel)
 		}
+		message.Channel = channel.Name
+	}
+
+	if message.ParentId != "" && !m.doesMessageExist(message.ParentId) {
+		log.Logger.Panic("Failed to store a reply, message %s doesn't exist", message.ParentId)
+	}
+
+	m.sequences[message.Channel]++
+	message.Seq = m.sequences[message.Channel]
+
+	if message.Id == "" {
+		message.Id = util.RandomHex(types.MessageIdLength)
+		for m.doesMessageExist(message.Id) {
+			message.Id = util.RandomHex(types.MessageIdLength)
+		}
+	}
+
+	msg := &types.ChatMessage{
+		Contents:     bytes.NewBuffer(bytes.Clone(message.Contents.Bytes())),
+		Sender:       message.Sender,
+		Channel:      message.Channel,
+		SentTime:     message.SentTime,
+		Timestamp:    message.Timestamp,
+		Seq:          message.Seq,
+		Id:           message.Id,
+		EditTime:     message.EditTime,
+		Deleted:      message.Deleted,
+		ParentId:     message.ParentId,
+		AttachmentId: message.AttachmentId,
+	}
+	m.messages[msg.Id] = msg
+	m.indexMessage(msg)
+
+	if msg.ParentId != "" {
+		m.messages[msg.ParentId].ReplyCount++
+		m.replies[msg.ParentId] = append(m.replies[msg.ParentId], msg)
+	}
 
+	if message.Channel != "" {
+		channel := m.channels[canonical.Key(message.Channel)]
 		channel.ChatHistory = append(channel.ChatHistory, msg)
 		log.Logger.Info("Added messages to %s channel", channel.Name)
 	} else {
@@ -103,6 +478,436 @@ func (m *memoryBackend) StoreMessage(message *types.ChatMessage) {
 	}
 }
 
+func (m *memoryBackend) GetMessage(id string) *types.ChatMessage {
+	m.RLock()
+	defer m.RUnlock()
+
+	msg, exists := m.messages[id]
+	if !exists {
+		return nil
+	}
+	return copyMessage(msg)
+}
+
+func (m *memoryBackend) GetReplies(id string) []*types.ChatMessage {
+	m.RLock()
+	defer m.RUnlock()
+	return copyMessages(m.replies[id])
+}
+
+// Stored messages are modified in place under the lock,
+// so the callers are given copies which are never modified after they're returned.
+func copyMessage(msg *types.ChatMessage) *types.ChatMessage {
+	result := *msg
+	return &result
+}
+
+func copyMessages(messages []*types.ChatMessage) []*types.ChatMessage {
+	if messages == nil {
+		return nil
+	}
+	result := make([]*types.ChatMessage, 0, len(messages))
+	for _, msg := range messages {
+		result = append(result, copyMessage(msg))
+	}
+	return result
+}
+
+func (m *memoryBackend) SetMessageSender(id string, sender string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	msg, exists := m.messages[id]
+	if !exists {
+		return false
+	}
+	msg.Sender = sender
+	return true
+}
+
+func (m *memoryBackend) EditMessage(id string, contents *bytes.Buffer, editTime string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	msg, exists := m.messages[id]
+	if !exists || msg.Deleted {
+		return false
+	}
+
+	m.unindexMessage(msg)
+	msg.Contents = bytes.NewBuffer(bytes.Clone(contents.Bytes()))
+	msg.EditTime = editTime
+	m.indexMessage(msg)
+
+	log.Logger.Info("Edited message %s", id)
+	return true
+}
+
+func (m *memoryBackend) DeleteMessage(id string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	msg, exists := m.messages[id]
+	if !exists || msg.Deleted {
+		return false
+	}
+
+	m.unindexMessage(msg)
+	msg.Contents = bytes.NewBuffer(nil)
+	msg.Deleted = true
+
+	log.Logger.Info("Deleted message %s", id)
+	return true
+}
+
+func (m *memoryBackend) DeleteMessages(channelname ...string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(channelname) != 0 {
+		for _, name := range channelname {
+			channel, exists := m.channels[canonical.Key(name)]
+			if !exists {
+				log.Logger.Panic("Cannot delete messages, channel %s doesn't exist", name)
+			}
+			for _, msg := range channel.ChatHistory {
+				delete(m.messages, msg.Id)
+				delete(m.replies, msg.Id)
+				delete(m.reactions, msg.Id)
+				m.unindexMessage(msg)
+			}
+			channel.ChatHistory = make([]*types.ChatMessage, 0, 1024)
+			log.Logger.Info("All messages were deleted in %s channel", name)
+		}
+	} else {
+		for _, msg := range m.chatHistory {
+			delete(m.messages, msg.Id)
+			delete(m.replies, msg.Id)
+			delete(m.reactions, msg.Id)
+			m.unindexMessage(msg)
+		}
+		m.chatHistory = make([]*types.ChatMessage, 0, 1024)
+		log.Logger.Info("All messages were deleted in a general chat")
+	}
+}
+
+// Messages without a timestamp are never considered expired.
+func (m *memoryBackend) DeleteMessagesBefore(channelname string, before time.Time) int {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.purgeMessages(channelname, func(index int, count int, msg *types.ChatMessage) bool {
+		return !msg.Timestamp.IsZero() && msg.Timestamp.Before(before)
+	})
+}
+
+func (m *memoryBackend) TrimMessages(channelname string, maxCount int) int {
+	m.Lock()
+	defer m.Unlock()
+
+	return m.purgeMessages(channelname, func(index int, count int, msg *types.ChatMessage) bool {
+		return index < count-maxCount
+	})
+}
+
+// Removes the messages for which purge returns true, the caller has to hold the lock.
+// Nothing is removed from a channel which doesn't exist, since it might have been renamed or deleted meanwhile.
+func (m *memoryBackend) purgeMessages(channelname string, purge func(index int, count int, msg *types.ChatMessage) bool) int {
+	history := m.chatHistory
+	if channelname != "" {
+		channel, exists := m.channels[canonical.Key(channelname)]
+		if !exists {
+			return 0
+		}
+		history = channel.ChatHistory
+	}
+
+	purged := make(map[string]bool)
+	for index, msg := range history {
+		if purge(index, len(history), msg) {
+			purged[msg.Id] = true
+		}
+	}
+	// Replies are removed together with the message which started the thread, so no thread is left without it.
+	for _, msg := range history {
+		if purged[msg.ParentId] {
+			purged[msg.Id] = true
+		}
+	}
+
+	kept := make([]*types.ChatMessage, 0, len(history))
+	for _, msg := range history {
+		if purged[msg.Id] {
+			m.removeMessage(msg)
+			continue
+		}
+		kept = append(kept, msg)
+	}
+
+	if channelname != "" {
+		m.channels[canonical.Key(channelname)].ChatHistory = kept
+	} else {
+		m.chatHistory = kept
+	}
+	return len(history) - len(kept)
+}
+
+// Removes the message from all the indexes, the caller has to hold the lock.
+func (m *memoryBackend) removeMessage(msg *types.ChatMessage) {
+	delete(m.messages, msg.Id)
+	delete(m.replies, msg.Id)
+	delete(m.reactions, msg.Id)
+	m.unindexMessage(msg)
+
+	if parent, exists := m.messages[msg.ParentId]; exists {
+		parent.ReplyCount--
+		replies := make([]*types.ChatMessage, 0, len(m.replies[parent.Id]))
+		for _, reply := range m.replies[parent.Id] {
+			if reply.Id != msg.Id {
+				replies = append(replies, reply)
+			}
+		}
+		m.replies[parent.Id] = replies
+	}
+}
+
+func (m *memoryBackend) StoreAttachment(attachment *types.Attachment) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, exists := m.attachments[attachment.Id]; exists {
+		log.Logger.Panic("Attachment %s already exists", attachment.Id)
+	}
+	stored := *attachment
+	m.attachments[attachment.Id] = &stored
+}
+
+func (m *memoryBackend) GetAttachment(id string) *types.Attachment {
+	m.RLock()
+	defer m.RUnlock()
+
+	attachment, exists := m.attachments[id]
+	if !exists {
+		return nil
+	}
+	result := *attachment
+	return &result
+}
+
+func (m *memoryBackend) DeleteAttachment(id string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.attachments, id)
+}
+
+func (m *memoryBackend) GetLoginAttempts(key string) *types.LoginAttempts {
+	m.RLock()
+	defer m.RUnlock()
+
+	entry, exists := m.loginAttempts[key]
+	if !exists || !time.Now().Before(entry.expires) {
+		return nil
+	}
+	attempts := entry.attempts
+	return &attempts
+}
+
+func (m *memoryBackend) SetLoginAttempts(key string, attempts *types.LoginAttempts, ttl time.Duration) {
+	m.Lock()
+	defer m.Unlock()
+
+	// Expired entries are dropped here, so the map doesn't grow with every address ever seen.
+	now := time.Now()
+	for k, entry := range m.loginAttempts {
+		if !now.Before(entry.expires) {
+			delete(m.loginAttempts, k)
+		}
+	}
+	m.loginAttempts[key] = loginAttempts{attempts: *attempts, expires: now.Add(ttl)}
+}
+
+func (m *memoryBackend) DeleteLoginAttempts(key string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.loginAttempts, key)
+}
+
+func (m *memoryBackend) AddReaction(id string, username string, emoji string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	msg, exists := m.messages[id]
+	if !exists || msg.Deleted {
+		return false
+	}
+
+	if m.reactions[id] == nil {
+		m.reactions[id] = make(map[string]map[string]bool)
+	}
+	if m.reactions[id][emoji] == nil {
+		m.reactions[id][emoji] = make(map[string]bool)
+	}
+	if m.reactions[id][emoji][username] {
+		return false
+	}
+	m.reactions[id][emoji][username] = true
+
+	m.updateReactionCounts(msg)
+	return true
+}
+
+func (m *memoryBackend) RemoveReaction(id string, username string, emoji string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	msg, exists := m.messages[id]
+	if !exists || !m.reactions[id][emoji][username] {
+		return false
+	}
+
+	delete(m.reactions[id][emoji], username)
+	if len(m.reactions[id][emoji]) == 0 {
+		delete(m.reactions[id], emoji)
+	}
+
+	m.updateReactionCounts(msg)
+	return true
+}
+
+func (m *memoryBackend) updateReactionCounts(msg *types.ChatMessage) {
+	counts := make(map[string]uint64, len(m.reactions[msg.Id]))
+	for emoji, participants := range m.reactions[msg.Id] {
+		counts[emoji] = uint64(len(participants))
+	}
+	msg.Reactions = counts
+}
+
+func (m *memoryBackend) EnqueueMessage(username string, id string, capacity int) {
+	m.Lock()
+	defer m.Unlock()
+
+	// The participant might have deleted their account since the message was sent.
+	key := canonical.Key(username)
+	if _, exists := m.participants[key]; !exists {
+		return
+	}
+
+	// A message which is already queued is moved to the end of the queue, the same way ZADD updates the score.
+	queue := make([]queuedMessage, 0, len(m.queues[key])+1)
+	for _, queued := range m.queues[key] {
+		if queued.id != id {
+			queue = append(queue, queued)
+		}
+	}
+	queue = append(queue, queuedMessage{id: id, enqueueTime: time.Now()})
+	if capacity > 0 && len(queue) > capacity {
+		queue = queue[len(queue)-capacity:]
+	}
+	m.queues[key] = queue
+}
+
+// Messages which were removed from the history since they were enqueued are skipped.
+func (m *memoryBackend) GetQueuedMessages(username string, maxAge time.Duration) []*types.ChatMessage {
+	m.RLock()
+	defer m.RUnlock()
+
+	var messages []*types.ChatMessage
+	for _, queued := range m.queues[canonical.Key(username)] {
+		if maxAge != 0 && time.Since(queued.enqueueTime) > maxAge {
+			continue
+		}
+		if msg, exists := m.messages[queued.id]; exists && !msg.Deleted {
+			messages = append(messages, copyMessage(msg))
+		}
+	}
+	return messages
+}
+
+func (m *memoryBackend) DeleteQueuedMessages(username string) {
+	m.Lock()
+	defer m.Unlock()
+	delete(m.queues, canonical.Key(username))
+}
+
+func (m *memoryBackend) SetReadMarker(username string, channelname string, seq uint64) {
+	m.Lock()
+	defer m.Unlock()
+
+	key := canonical.Key(username)
+	if m.markers[key] == nil {
+		m.markers[key] = make(map[string]uint64)
+	}
+	if seq > m.markers[key][channelname] {
+		m.markers[key][channelname] = seq
+	}
+}
+
+func (m *memoryBackend) GetReadMarkers(username string) map[string]uint64 {
+	m.RLock()
+	defer m.RUnlock()
+
+	key := canonical.Key(username)
+	markers := make(map[string]uint64, len(m.markers[key]))
+	for channelname, seq := range m.markers[key] {
+		markers[channelname] = seq
+	}
+	return markers
+}
+
+func (m *memoryBackend) SearchMessages(query *types.SearchQuery) ([]*types.ChatMessage, int) {
+	m.RLock()
+	defer m.RUnlock()
+
+	if len(query.Terms) == 0 {
+		return nil, 0
+	}
+
+	// Start with the messages containing the first term and drop the ones missing any of the others.
+	scores := make(map[string]int)
+	for id, count := range m.index[query.Terms[0]] {
+		scores[id] = count
+	}
+	for _, term := range query.Terms[1:] {
+		for id := range scores {
+			count, exists := m.index[term][id]
+			if !exists {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += count
+		}
+	}
+
+	hits := make([]backend.SearchHit, 0, len(scores))
+	for id, score := range scores {
+		hits = append(hits, backend.SearchHit{Message: copyMessage(m.messages[id]), Score: score})
+	}
+	return backend.RankSearchHits(hits, query)
+}
+
+// Adds message's terms to the search index, the caller has to hold the lock.
+func (m *memoryBackend) indexMessage(msg *types.ChatMessage) {
+	if msg.Deleted {
+		return
+	}
+	for term, count := range backend.TermCounts(msg.Contents.String()) {
+		if m.index[term] == nil {
+			m.index[term] = make(map[string]int)
+		}
+		m.index[term][msg.Id] = count
+	}
+}
+
+// Removes message's terms from the search index, the caller has to hold the lock.
+func (m *memoryBackend) unindexMessage(msg *types.ChatMessage) {
+	for term := range backend.TermCounts(msg.Contents.String()) {
+		delete(m.index[term], msg.Id)
+		if len(m.index[term]) == 0 {
+			delete(m.index, term)
+		}
+	}
+}
+
 func (m *memoryBackend) HasChannel(channelname string) bool {
 	m.RLock()
 	defer m.RUnlock()
@@ -117,7 +922,7 @@ func (m *memoryBackend) RegisterChannel(channel *types.Channel) {
 		log.Logger.Panic("Channel %s already exists", channel.Name)
 	}
 
-	m.channels[channel.Name] = &types.Channel{
+	m.channels[canonical.Key(channel.Name)] = &types.Channel{
 		Name:         channel.Name,
 		Desc:         channel.Desc,
 		Creator:      channel.Creator,
@@ -129,13 +934,123 @@ func (m *memoryBackend) RegisterChannel(channel *types.Channel) {
 	log.Logger.Info("Registered %s channel", channel.Name)
 }
 
+func (m *memoryBackend) UpdateChannel(channelname string, channel *types.Channel) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	current, exists := m.channels[canonical.Key(channelname)]
+	if !exists {
+		log.Logger.Panic("Failed to update channel, channel %s doesn't exist", channelname)
+	}
+	channelname = current.Name
+
+	updated := *current
+	updated.Name = channel.Name
+	updated.Desc = channel.Desc
+
+	if updated.Name != channelname {
+		// Changing only the case of the name doesn't clash with the channel itself.
+		if !canonical.Equal(updated.Name, channelname) && m.doesChannelExist(updated.Name) {
+			return false
+		}
+
+		for _, msg := range updated.ChatHistory {
+			msg.Channel = updated.Name
+		}
+		m.sequences[updated.Name] = m.sequences[channelname]
+		delete(m.sequences, channelname)
+		for _, markers := range m.markers {
+			if seq, exists := markers[channelname]; exists {
+				markers[updated.Name] = seq
+				delete(markers, channelname)
+			}
+		}
+		delete(m.channels, canonical.Key(channelname))
+		log.Logger.Info("Renamed channel %s to %s", channelname, updated.Name)
+	}
+
+	m.channels[canonical.Key(updated.Name)] = &updated
+	return true
+}
+
+func (m *memoryBackend) AddChannelMember(channelname string, username string) {
+	m.Lock()
+	defer m.Unlock()
+
+	channel, exists := m.channels[canonical.Key(channelname)]
+	if !exists {
+		log.Logger.Panic("Failed to add a member, channel %s doesn't exist", channelname)
+	}
+
+	for _, member := range channel.Members {
+		if canonical.Equal(member, username) {
+			return
+		}
+	}
+	members := make([]string, 0, len(channel.Members)+1)
+	channel.Members = append(append(members, channel.Members...), username)
+}
+
+// Only messages which belong to the channel can be pinned, and every message only once.
+func (m *memoryBackend) PinMessage(channelname string, id string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	channel, exists := m.channels[canonical.Key(channelname)]
+	if !exists {
+		log.Logger.Panic("Failed to pin a message, channel %s doesn't exist", channelname)
+	}
+
+	msg, exists := m.messages[id]
+	if !exists || msg.Deleted || msg.Channel != channel.Name {
+		return false
+	}
+	for _, pinned := range channel.Pins {
+		if pinned == id {
+			return false
+		}
+	}
+
+	pins := make([]string, 0, len(channel.Pins)+1)
+	channel.Pins = append(append(pins, channel.Pins...), id)
+	return true
+}
+
+func (m *memoryBackend) UnpinMessage(channelname string, id string) bool {
+	m.Lock()
+	defer m.Unlock()
+
+	channel, exists := m.channels[canonical.Key(channelname)]
+	if !exists {
+		log.Logger.Panic("Failed to unpin a message, channel %s doesn't exist", channelname)
+	}
+
+	pins := make([]string, 0, len(channel.Pins))
+	for _, pinned := range channel.Pins {
+		if pinned != id {
+			pins = append(pins, pinned)
+		}
+	}
+	if len(pins) == len(channel.Pins) {
+		return false
+	}
+	channel.Pins = pins
+	return true
+}
+
 func (m *memoryBackend) DeleteChannel(channelname string) bool {
 	m.Lock()
 	defer m.Unlock()
 	if !m.doesChannelExist(channelname) {
 		log.Logger.Panic("Deletion failed, channel %s doesn't exist", channelname)
 	}
-	delete(m.channels, channelname)
+	for _, msg := range m.channels[canonical.Key(channelname)].ChatHistory {
+		delete(m.messages, msg.Id)
+		delete(m.replies, msg.Id)
+		delete(m.reactions, msg.Id)
+		m.unindexMessage(msg)
+	}
+	delete(m.channels, canonical.Key(channelname))
 
 	log.Logger.Info("Deleted %s channel", channelname)
 
@@ -152,10 +1067,10 @@ func (m *memoryBackend) GetChatHistory(channelname ...string) []*types.ChatMessa
 		if !m.doesChannelExist(channelname[0]) {
 			log.Logger.Panic("Failed to list chat history, channel %s doesn't exist", channelname)
 		}
-		channel := m.channels[channelname[0]]
-		return channel.ChatHistory
+		channel := m.channels[canonical.Key(channelname[0])]
+		return copyMessages(channel.ChatHistory)
 	}
-	return m.chatHistory
+	return copyMessages(m.chatHistory)
 }
 
 func (m *memoryBackend) GetChannels() []*types.Channel {
@@ -167,9 +1082,15 @@ func (m *memoryBackend) GetChannels() []*types.Channel {
 
 	if chanCount != 0 {
 		channels = make([]*types.Channel, 0, chanCount)
+		// Stored channels are modified in place under the lock, so the callers are given copies.
+		// The messages aren't included, the same as in redis, they're read with GetChatHistory.
 		for _, ch := range m.channels {
-			channels = append(channels, ch)
+			channel := *ch
+			channel.ChatHistory = nil
+			channels = append(channels, &channel)
 		}
+		// Channels are selected by their position in the list, so the order has to be stable.
+		sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
 	}
 	return channels
 }
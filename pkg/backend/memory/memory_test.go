@@ -1,11 +1,15 @@
 package memory
 
 import (
+	"bytes"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/isnastish/chat/pkg/testsetup"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
 )
 
 func TestRegisterParticipant(t *testing.T) {
@@ -80,4 +84,541 @@ func TestGetChannelHistory(t *testing.T) {
 
 	programmingChanHistory := storage.GetChatHistory(testsetup.Channels[1].Name)
 	assert.True(t, testsetup.Match(programmingChanHistory, testsetup.ProgrammingChannelMessages, testsetup.ContainsMessage))
+
+	// The history is only read with GetChatHistory
+	for _, channel := range storage.GetChannels() {
+		assert.True(t, channel.ChatHistory == nil)
+	}
+}
+
+func TestSequenceNumbers(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.RegisterChannel(&testsetup.Channels[0])
+
+	for index, msg := range testsetup.BooksChannelMessages {
+		storage.StoreMessage(&msg)
+		assert.Equal(t, uint64(index+1), msg.Seq)
+	}
+	// Sequence numbers are independent in every channel
+	for index, msg := range testsetup.GeneralMessages {
+		storage.StoreMessage(&msg)
+		assert.Equal(t, uint64(index+1), msg.Seq)
+	}
+
+	history := storage.GetChatHistory(testsetup.Channels[0].Name)
+	for index, msg := range history {
+		assert.Equal(t, uint64(index+1), msg.Seq)
+	}
+}
+
+func TestEditDeleteMessage(t *testing.T) {
+	storage := NewMemoryBackend()
+	for _, msg := range testsetup.GeneralMessages {
+		storage.StoreMessage(&msg)
+	}
+
+	history := storage.GetChatHistory()
+	id := history[0].Id
+	assert.NotEqual(t, "", id)
+	assert.Equal(t, history[0], storage.GetMessage(id))
+	assert.Nil(t, storage.GetMessage("nonexistent"))
+
+	assert.True(t, storage.EditMessage(id, bytes.NewBufferString("Edited message"), "12:00:00"))
+	msg := storage.GetMessage(id)
+	assert.Equal(t, "Edited message", msg.Contents.String())
+	assert.Equal(t, "12:00:00", msg.EditTime)
+	// Messages returned before the edit are left untouched
+	assert.Equal(t, "", history[0].EditTime)
+
+	// Deleted messages are kept as tombstones and cannot be edited
+	assert.True(t, storage.DeleteMessage(id))
+	assert.False(t, storage.DeleteMessage(id))
+	assert.False(t, storage.EditMessage(id, bytes.NewBufferString("Edited again"), "12:00:01"))
+	history = storage.GetChatHistory()
+	assert.Equal(t, len(testsetup.GeneralMessages), len(history))
+	assert.True(t, history[0].Deleted)
+	assert.Equal(t, 0, history[0].Contents.Len())
+
+	storage.DeleteMessages()
+	assert.Equal(t, 0, len(storage.GetChatHistory()))
+	assert.Nil(t, storage.GetMessage(id))
+}
+
+func TestReplies(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.RegisterChannel(&testsetup.Channels[1])
+
+	parent := testsetup.ProgrammingChannelMessages[0]
+	storage.StoreMessage(&parent)
+
+	for _, msg := range testsetup.ProgrammingChannelMessages[1:] {
+		msg.ParentId = parent.Id
+		storage.StoreMessage(&msg)
+	}
+
+	replies := storage.GetReplies(parent.Id)
+	assert.Equal(t, len(testsetup.ProgrammingChannelMessages)-1, len(replies))
+	for _, reply := range replies {
+		assert.Equal(t, parent.Id, reply.ParentId)
+	}
+	assert.Equal(t, uint64(len(replies)), storage.GetMessage(parent.Id).ReplyCount)
+
+	// Replying to a message which doesn't exist
+	assert.Panics(t, func() {
+		storage.StoreMessage(&types.ChatMessage{Contents: bytes.NewBufferString("Reply"), ParentId: "nonexistent"})
+	})
+
+	storage.DeleteMessages(testsetup.Channels[1].Name)
+	assert.Equal(t, 0, len(storage.GetReplies(parent.Id)))
+}
+
+func TestReactions(t *testing.T) {
+	storage := NewMemoryBackend()
+
+	msg := testsetup.GeneralMessages[0]
+	storage.StoreMessage(&msg)
+
+	assert.True(t, storage.AddReaction(msg.Id, "alice", "👍"))
+	assert.True(t, storage.AddReaction(msg.Id, "bob", "👍"))
+	assert.True(t, storage.AddReaction(msg.Id, "bob", "🎉"))
+	// The same participant cannot react with the same emoji twice
+	assert.False(t, storage.AddReaction(msg.Id, "alice", "👍"))
+	assert.False(t, storage.AddReaction("nonexistent", "alice", "👍"))
+	assert.Equal(t, map[string]uint64{"👍": 2, "🎉": 1}, storage.GetMessage(msg.Id).Reactions)
+
+	assert.True(t, storage.RemoveReaction(msg.Id, "bob", "🎉"))
+	assert.False(t, storage.RemoveReaction(msg.Id, "bob", "🎉"))
+	assert.Equal(t, map[string]uint64{"👍": 2}, storage.GetMessage(msg.Id).Reactions)
+
+	// Deleted messages cannot be reacted to
+	storage.DeleteMessage(msg.Id)
+	assert.False(t, storage.AddReaction(msg.Id, "bob", "🎉"))
+}
+
+func TestOfflineQueue(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.RegisterParticipant(&testsetup.Participants[0])
+	username := testsetup.Participants[0].Username
+
+	var ids []string
+	for _, contents := range []string{"first", "second", "third"} {
+		msg := types.BuildChatMsg([]byte(contents), "alice")
+		storage.StoreMessage(msg)
+		storage.EnqueueMessage(username, msg.Id, 2)
+		ids = append(ids, msg.Id)
+	}
+
+	// The oldest message is dropped once the capacity is exceeded
+	queued := storage.GetQueuedMessages(username, 0)
+	assert.Equal(t, 2, len(queued))
+	assert.Equal(t, ids[1], queued[0].Id)
+	assert.Equal(t, ids[2], queued[1].Id)
+
+	// Messages are queued only once
+	storage.EnqueueMessage(username, ids[1], 2)
+	queued = storage.GetQueuedMessages(username, 0)
+	assert.Equal(t, 2, len(queued))
+	assert.Equal(t, ids[2], queued[0].Id)
+	assert.Equal(t, ids[1], queued[1].Id)
+
+	// Deleted and expired messages are skipped
+	storage.DeleteMessage(ids[1])
+	assert.Equal(t, 1, len(storage.GetQueuedMessages(username, 0)))
+	assert.Equal(t, 0, len(storage.GetQueuedMessages(username, time.Nanosecond)))
+
+	storage.DeleteQueuedMessages(username)
+	assert.Equal(t, 0, len(storage.GetQueuedMessages(username, 0)))
+
+	storage.EnqueueMessage("nonexistent", ids[0], 2)
+	assert.Equal(t, 0, len(storage.GetQueuedMessages("nonexistent", 0)))
+}
+
+func TestChannelMembers(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.RegisterChannel(&testsetup.Channels[0])
+
+	storage.AddChannelMember(testsetup.Channels[0].Name, "alice")
+	storage.AddChannelMember(testsetup.Channels[0].Name, "bob")
+	storage.AddChannelMember(testsetup.Channels[0].Name, "alice")
+	assert.Equal(t, []string{"alice", "bob"}, storage.GetChannels()[0].Members)
+
+	assert.Panics(t, func() { storage.AddChannelMember("nonexistent", "alice") })
+}
+
+func TestSearchMessages(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.RegisterChannel(&testsetup.Channels[1])
+
+	first := types.BuildChatMsg([]byte("Go channels are great, channels everywhere"), "alice", testsetup.Channels[1].Name)
+	second := types.BuildChatMsg([]byte("Channels in Go are typed"), "bob")
+	third := types.BuildChatMsg([]byte("Nothing to see here"), "bob")
+	storage.StoreMessage(first)
+	storage.StoreMessage(second)
+	storage.StoreMessage(third)
+
+	// The message with more occurrences of the terms is ranked higher
+	messages, total := storage.SearchMessages(&types.SearchQuery{Terms: []string{"go", "channels"}})
+	assert.Equal(t, 2, total)
+	assert.Equal(t, first.Id, messages[0].Id)
+	assert.Equal(t, second.Id, messages[1].Id)
+
+	messages, total = storage.SearchMessages(&types.SearchQuery{Terms: []string{"channels"}, Sender: "bob"})
+	assert.Equal(t, 1, total)
+	assert.Equal(t, second.Id, messages[0].Id)
+
+	messages, total = storage.SearchMessages(&types.SearchQuery{Terms: []string{"channels"}, Offset: 1, Limit: 1})
+	assert.Equal(t, 2, total)
+	assert.Equal(t, second.Id, messages[0].Id)
+
+	// Negative offsets start from the first result, offsets past the last one give an empty page
+	messages, _ = storage.SearchMessages(&types.SearchQuery{Terms: []string{"channels"}, Offset: -10, Limit: 1})
+	assert.Equal(t, first.Id, messages[0].Id)
+	messages, _ = storage.SearchMessages(&types.SearchQuery{Terms: []string{"channels"}, Offset: 10, Limit: 1})
+	assert.Equal(t, 0, len(messages))
+
+	// Edited and deleted messages are reindexed
+	storage.EditMessage(third.Id, bytes.NewBufferString("Buffered channels"), "")
+	storage.DeleteMessage(first.Id)
+	messages, total = storage.SearchMessages(&types.SearchQuery{Terms: []string{"channels"}})
+	assert.Equal(t, 2, total)
+	assert.Equal(t, third.Id, messages[0].Id)
+
+	_, total = storage.SearchMessages(&types.SearchQuery{Terms: []string{"nothing"}})
+	assert.Equal(t, 0, total)
+}
+
+func TestReadMarkers(t *testing.T) {
+	storage := NewMemoryBackend()
+	username := testsetup.Participants[0].Username
+
+	storage.SetReadMarker(username, "", 3)
+	storage.SetReadMarker(username, testsetup.Channels[0].Name, 5)
+	// Markers never move backwards
+	storage.SetReadMarker(username, "", 2)
+	assert.Equal(t, map[string]uint64{"": 3, testsetup.Channels[0].Name: 5}, storage.GetReadMarkers(username))
+	assert.Equal(t, 0, len(storage.GetReadMarkers("nonexistent")))
+}
+
+func TestPurgeMessages(t *testing.T) {
+	storage := NewMemoryBackend()
+
+	var ids []string
+	for _, contents := range []string{"first", "second", "third", "fourth"} {
+		msg := types.BuildChatMsg([]byte(contents), "alice")
+		storage.StoreMessage(msg)
+		ids = append(ids, msg.Id)
+	}
+	reply := types.BuildChatMsg([]byte("reply"), "bob")
+	reply.ParentId = ids[3]
+	storage.StoreMessage(reply)
+
+	// The oldest messages are removed first
+	assert.Equal(t, 2, storage.TrimMessages("", 3))
+	history := storage.GetChatHistory()
+	assert.Equal(t, 3, len(history))
+	assert.Equal(t, ids[2], history[0].Id)
+	assert.True(t, storage.GetMessage(ids[0]) == nil)
+	assert.Equal(t, 0, storage.TrimMessages("", 3))
+
+	// Only the messages sent before the reply expire, but the reply is removed together with its thread
+	assert.Equal(t, 3, storage.DeleteMessagesBefore("", reply.Timestamp))
+	assert.Equal(t, 0, len(storage.GetChatHistory()))
+	assert.Nil(t, storage.GetMessage(reply.Id))
+	assert.Nil(t, storage.GetReplies(ids[3]))
+	_, total := storage.SearchMessages(&types.SearchQuery{Terms: []string{"reply"}})
+	assert.Equal(t, 0, total)
+
+	assert.Equal(t, 0, storage.TrimMessages("nonexistent", 1))
+	assert.Equal(t, 0, storage.DeleteMessagesBefore("nonexistent", time.Now()))
+}
+
+func TestPins(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.RegisterChannel(&testsetup.Channels[0])
+	channelname := testsetup.Channels[0].Name
+
+	first := types.BuildChatMsg([]byte("first"), "alice", channelname)
+	second := types.BuildChatMsg([]byte("second"), "alice", channelname)
+	general := types.BuildChatMsg([]byte("general"), "alice")
+	storage.StoreMessage(first)
+	storage.StoreMessage(second)
+	storage.StoreMessage(general)
+
+	assert.True(t, storage.PinMessage(channelname, second.Id))
+	assert.True(t, storage.PinMessage(channelname, first.Id))
+	assert.False(t, storage.PinMessage(channelname, first.Id))
+	// Messages from other channels cannot be pinned
+	assert.False(t, storage.PinMessage(channelname, general.Id))
+	assert.Equal(t, []string{second.Id, first.Id}, storage.GetChannels()[0].Pins)
+
+	assert.True(t, storage.UnpinMessage(channelname, second.Id))
+	assert.False(t, storage.UnpinMessage(channelname, second.Id))
+	assert.Equal(t, []string{first.Id}, storage.GetChannels()[0].Pins)
+
+	assert.Panics(t, func() { storage.PinMessage("nonexistent", first.Id) })
+}
+
+func TestUpdateChannel(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.RegisterChannel(&testsetup.Channels[0])
+	storage.RegisterChannel(&testsetup.Channels[1])
+	oldName := testsetup.Channels[0].Name
+
+	msg := types.BuildChatMsg([]byte("first"), "alice", oldName)
+	storage.StoreMessage(msg)
+	storage.AddChannelMember(oldName, "alice")
+	storage.PinMessage(oldName, msg.Id)
+	storage.SetReadMarker("alice", oldName, msg.Seq)
+
+	// The name is taken
+	assert.False(t, storage.UpdateChannel(oldName, &types.Channel{Name: testsetup.Channels[1].Name}))
+
+	assert.True(t, storage.UpdateChannel(oldName, &types.Channel{Name: "RenamedChannel", Desc: "New topic"}))
+	assert.False(t, storage.HasChannel(oldName))
+	assert.True(t, storage.HasChannel("RenamedChannel"))
+
+	history := storage.GetChatHistory("RenamedChannel")
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, "RenamedChannel", history[0].Channel)
+	assert.Equal(t, map[string]uint64{"RenamedChannel": msg.Seq}, storage.GetReadMarkers("alice"))
+
+	for _, channel := range storage.GetChannels() {
+		if channel.Name == "RenamedChannel" {
+			assert.Equal(t, "New topic", channel.Desc)
+			assert.Equal(t, []string{"alice"}, channel.Members)
+			assert.Equal(t, []string{msg.Id}, channel.Pins)
+		}
+	}
+
+	// Sequence numbers continue after the rename
+	next := types.BuildChatMsg([]byte("second"), "alice", "RenamedChannel")
+	storage.StoreMessage(next)
+	assert.Equal(t, msg.Seq+1, next.Seq)
+}
+
+func TestAttachments(t *testing.T) {
+	storage := NewMemoryBackend()
+	attachment := &types.Attachment{Id: "a1b2c3d4", Name: "notes.txt", Size: 5, Checksum: util.Sha256Checksum([]byte("notes")), Uploader: "alice"}
+
+	assert.True(t, storage.GetAttachment(attachment.Id) == nil)
+	storage.StoreAttachment(attachment)
+	assert.Equal(t, attachment, storage.GetAttachment(attachment.Id))
+	// Callers are given copies
+	storage.GetAttachment(attachment.Id).Name = "renamed.txt"
+	assert.Equal(t, "notes.txt", storage.GetAttachment(attachment.Id).Name)
+
+	msg := types.BuildChatMsg([]byte(attachment.Name), "alice", "")
+	msg.AttachmentId = attachment.Id
+	storage.StoreMessage(msg)
+	assert.Equal(t, attachment.Id, storage.GetMessage(msg.Id).AttachmentId)
+
+	storage.DeleteAttachment(attachment.Id)
+	assert.True(t, storage.GetAttachment(attachment.Id) == nil)
+}
+
+func TestLoginAttempts(t *testing.T) {
+	storage := NewMemoryBackend()
+	assert.True(t, storage.GetLoginAttempts("username:alice") == nil)
+
+	attempts := &types.LoginAttempts{Failures: 2, LastFailure: time.Now()}
+	storage.SetLoginAttempts("username:alice", attempts, time.Hour)
+	assert.Equal(t, attempts, storage.GetLoginAttempts("username:alice"))
+
+	// Expired attempts are forgotten
+	storage.SetLoginAttempts("address:127.0.0.1", attempts, 0)
+	assert.True(t, storage.GetLoginAttempts("address:127.0.0.1") == nil)
+
+	storage.DeleteLoginAttempts("username:alice")
+	assert.True(t, storage.GetLoginAttempts("username:alice") == nil)
+}
+
+func TestUpdatePassword(t *testing.T) {
+	storage := NewMemoryBackend()
+	participant := testsetup.Participants[0]
+	storage.RegisterParticipant(&participant)
+
+	assert.True(t, storage.UpdatePassword(participant.Username, "NewPassword#1234"))
+	assert.False(t, storage.AuthParticipant(&participant))
+	assert.True(t, storage.AuthParticipant(&types.Participant{Username: participant.Username, Password: "NewPassword#1234"}))
+	assert.Equal(t, participant.Email, storage.GetParticipant(participant.Username).Email)
+
+	assert.False(t, storage.UpdatePassword("nonexistent", "NewPassword#1234"))
+	assert.True(t, storage.GetParticipant("nonexistent") == nil)
+}
+
+func TestMarkEmailVerified(t *testing.T) {
+	storage := NewMemoryBackend()
+	participant := testsetup.Participants[0]
+	participant.Unverified = true
+	storage.RegisterParticipant(&participant)
+	assert.True(t, storage.GetParticipant(participant.Username).Unverified)
+
+	assert.True(t, storage.MarkEmailVerified(participant.Username))
+	assert.False(t, storage.GetParticipant(participant.Username).Unverified)
+	assert.False(t, storage.MarkEmailVerified("nonexistent"))
+}
+
+func TestDeleteParticipant(t *testing.T) {
+	storage := NewMemoryBackend()
+	participant := testsetup.Participants[0]
+	storage.RegisterParticipant(&participant)
+	storage.RegisterChannel(&testsetup.Channels[0])
+	storage.AddChannelMember(testsetup.Channels[0].Name, participant.Username)
+	storage.SetReadMarker(participant.Username, testsetup.Channels[0].Name, 1)
+	storage.SetTwoFactor(participant.Username, &types.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true})
+	storage.StoreAPIToken(&types.APIToken{Id: "a", Hash: "hash-a", Username: participant.Username, Created: time.Now()})
+
+	assert.True(t, storage.DeleteParticipant(participant.Username))
+	assert.False(t, storage.HasParticipant(participant.Username))
+	assert.Equal(t, 0, len(storage.GetChannels()[0].Members))
+	assert.Equal(t, 0, len(storage.GetReadMarkers(participant.Username)))
+	assert.True(t, storage.GetTwoFactor(participant.Username) == nil)
+	assert.True(t, storage.GetAPIToken("hash-a") == nil)
+	assert.False(t, storage.DeleteParticipant(participant.Username))
+}
+
+func TestSetMessageSender(t *testing.T) {
+	storage := NewMemoryBackend()
+	msg := testsetup.GeneralMessages[0]
+	storage.StoreMessage(&msg)
+
+	assert.True(t, storage.SetMessageSender(msg.Id, "[deleted]"))
+	assert.Equal(t, "[deleted]", storage.GetMessage(msg.Id).Sender)
+	assert.False(t, storage.SetMessageSender("nonexistent", "[deleted]"))
+}
+
+func TestUpdateProfile(t *testing.T) {
+	storage := NewMemoryBackend()
+	participant := testsetup.Participants[0]
+	participant.Registered = time.Now().Add(-time.Hour)
+	storage.RegisterParticipant(&participant)
+
+	assert.True(t, storage.UpdateProfile(participant.Username, "Nick", "Sci-fi reader", "Europe/Berlin"))
+	lastSeen := time.Now()
+	assert.True(t, storage.SetLastSeen(participant.Username, lastSeen))
+
+	registered := storage.GetParticipant(participant.Username)
+	assert.Equal(t, "Nick", registered.DisplayName)
+	assert.Equal(t, "Sci-fi reader", registered.Bio)
+	assert.Equal(t, "Europe/Berlin", registered.Timezone)
+	assert.True(t, registered.Registered.Equal(participant.Registered))
+	assert.True(t, registered.LastSeen.Equal(lastSeen))
+
+	assert.False(t, storage.UpdateProfile("nonexistent", "", "", ""))
+	assert.False(t, storage.SetLastSeen("nonexistent", lastSeen))
+}
+
+func TestCanonicalNames(t *testing.T) {
+	storage := NewMemoryBackend()
+	participant := types.Participant{Username: "MarkLutz", Password: "Secret#12345", Email: "mark@gmail.com"}
+	storage.RegisterParticipant(&participant)
+	assert.True(t, storage.HasParticipant("marklutz"))
+	assert.True(t, storage.HasParticipant("Mark1utz"))
+	assert.Equal(t, "MarkLutz", storage.GetParticipant("MARKLUTZ").Username)
+	assert.True(t, storage.AuthParticipant(&types.Participant{Username: "marklutz", Password: "Secret#12345"}))
+	assert.Panics(t, func() {
+		storage.RegisterParticipant(&types.Participant{Username: "marklutz", Password: "Secret#12345"})
+	})
+
+	storage.RegisterChannel(&types.Channel{Name: "BooksChannel"})
+	assert.True(t, storage.HasChannel("bookschannel"))
+	msg := types.ChatMessage{Contents: bytes.NewBufferString("hello"), Sender: "MarkLutz", Channel: "BOOKSCHANNEL"}
+	storage.StoreMessage(&msg)
+	assert.Equal(t, "BooksChannel", storage.GetChatHistory("bookschannel")[0].Channel)
+
+	// Only the case of the name changes, the channel doesn't clash with itself
+	assert.True(t, storage.UpdateChannel("bookschannel", &types.Channel{Name: "Bookschannel"}))
+	assert.True(t, storage.HasChannel("BooksChannel"))
+	assert.Equal(t, "Bookschannel", storage.GetChannels()[0].Name)
+
+	// Participant's data is found however the name is typed
+	storage.EnqueueMessage("marklutz", msg.Id, 0)
+	assert.Equal(t, 1, len(storage.GetQueuedMessages("MARKLUTZ", 0)))
+	storage.SetReadMarker("marklutz", "Bookschannel", msg.Seq)
+	assert.Equal(t, msg.Seq, storage.GetReadMarkers("MarkLutz")["Bookschannel"])
+	storage.SetTwoFactor("marklutz", &types.TwoFactor{Enabled: true})
+	assert.True(t, storage.GetTwoFactor("MarkLutz").Enabled)
+	storage.StoreAPIToken(&types.APIToken{Id: "a", Hash: "hash-a", Username: "MarkLutz"})
+	assert.Equal(t, 1, len(storage.GetAPITokens("marklutz")))
+	storage.AddChannelMember("Bookschannel", "MarkLutz")
+	storage.AddChannelMember("Bookschannel", "marklutz")
+	assert.Equal(t, []string{"MarkLutz"}, storage.GetChannels()[0].Members)
+
+	assert.True(t, storage.DeleteParticipant("MARKLUTZ"))
+	assert.False(t, storage.HasParticipant("MarkLutz"))
+	assert.Equal(t, 0, len(storage.GetChannels()[0].Members))
+	assert.True(t, storage.GetTwoFactor("marklutz") == nil)
+	assert.Equal(t, 0, len(storage.GetAPITokens("marklutz")))
+}
+
+func TestAPITokens(t *testing.T) {
+	storage := NewMemoryBackend()
+	now := time.Now()
+	storage.StoreAPIToken(&types.APIToken{Id: "b", Hash: "hash-b", Username: "alice", Scopes: "read", Created: now.Add(time.Second)})
+	storage.StoreAPIToken(&types.APIToken{Id: "a", Hash: "hash-a", Username: "alice", Scopes: "post", Created: now})
+	storage.StoreAPIToken(&types.APIToken{Id: "c", Hash: "hash-c", Username: "bob", Created: now})
+
+	assert.Equal(t, "alice", storage.GetAPIToken("hash-a").Username)
+	assert.True(t, storage.GetAPIToken("nonexistent") == nil)
+
+	tokens := storage.GetAPITokens("alice")
+	assert.Equal(t, 2, len(tokens))
+	assert.Equal(t, "a", tokens[0].Id)
+	assert.Equal(t, "b", tokens[1].Id)
+
+	// Tokens of other participants can't be revoked
+	assert.True(t, storage.DeleteAPIToken("alice", "c") == nil)
+	assert.Equal(t, "hash-a", storage.DeleteAPIToken("alice", "a").Hash)
+	assert.True(t, storage.GetAPIToken("hash-a") == nil)
+	assert.Equal(t, 1, len(storage.GetAPITokens("alice")))
+}
+
+func TestTwoFactor(t *testing.T) {
+	storage := NewMemoryBackend()
+	assert.True(t, storage.GetTwoFactor("alice") == nil)
+
+	storage.SetTwoFactor("alice", &types.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true, LastStep: 42})
+	assert.Equal(t, types.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true, LastStep: 42}, *storage.GetTwoFactor("alice"))
+
+	storage.SetRecoveryCodes("alice", []string{"first", "second"})
+	assert.True(t, storage.RedeemRecoveryCode("alice", "first"))
+	assert.False(t, storage.RedeemRecoveryCode("alice", "first"))
+	assert.False(t, storage.RedeemRecoveryCode("bob", "second"))
+
+	// New codes replace the old ones
+	storage.SetRecoveryCodes("alice", []string{"third"})
+	assert.False(t, storage.RedeemRecoveryCode("alice", "second"))
+
+	storage.DeleteTwoFactor("alice")
+	assert.True(t, storage.GetTwoFactor("alice") == nil)
+	assert.False(t, storage.RedeemRecoveryCode("alice", "third"))
+}
+
+func TestOneTimeCodes(t *testing.T) {
+	storage := NewMemoryBackend()
+	storage.StoreOneTimeCode("reset:code", "alice", time.Hour)
+
+	username, ok := storage.RedeemOneTimeCode("reset:code")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", username)
+
+	// Codes can only be redeemed once
+	_, ok = storage.RedeemOneTimeCode("reset:code")
+	assert.False(t, ok)
+
+	storage.StoreOneTimeCode("reset:expired", "alice", 0)
+	_, ok = storage.RedeemOneTimeCode("reset:expired")
+	assert.False(t, ok)
+}
+
+func TestSentCodes(t *testing.T) {
+	storage := NewMemoryBackend()
+	now := time.Now()
+	storage.SetSentCode("alice", "reset", &types.SentCode{Code: "reset:code", SentTime: now}, time.Hour)
+
+	assert.Equal(t, "reset:code", storage.GetSentCode("ALICE", "reset").Code)
+	assert.True(t, storage.GetSentCode("alice", "verify") == nil)
+
+	storage.SetSentCode("alice", "reset", &types.SentCode{Code: "reset:expired", SentTime: now}, 0)
+	assert.True(t, storage.GetSentCode("alice", "reset") == nil)
 }
@@ -11,6 +11,8 @@ import (
 	"bytes"
 	"context"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
 	"time"
@@ -18,6 +20,7 @@ import (
 	"github.com/redis/go-redis/v9"
 
 	"github.com/isnastish/chat/pkg/backend"
+	"github.com/isnastish/chat/pkg/canonical"
 	"github.com/isnastish/chat/pkg/logger"
 	"github.com/isnastish/chat/pkg/types"
 	"github.com/isnastish/chat/pkg/utilities"
@@ -29,19 +32,29 @@ type Config struct {
 	Port int
 }
 
+// Hashes which map the canonical keys of the names to the names participants and channels were registered with.
+const (
+	participantNamesKey = "names/participants:"
+	channelNamesKey     = "names/channels:"
+)
+
 type redisBackend struct {
 	client *redis.Client
 	ctx    context.Context
 	sync.RWMutex
 }
 
-func NewRedisBackend(config *backend.RedisConfig) (*redisBackend, error) {
-	options := &redis.Options{
+// Options of the client connecting to the server the config points to.
+func clientOptions(config *backend.RedisConfig) *redis.Options {
+	return &redis.Options{
 		Addr:     config.Endpoint,
-		Password: "",
+		Username: config.Username,
+		Password: config.Password,
 	}
+}
 
-	client := redis.NewClient(options)
+func NewRedisBackend(config *backend.RedisConfig) (*redisBackend, error) {
+	client := redis.NewClient(clientOptions(config))
 
 	rb := &redisBackend{
 		client: client,
@@ -49,18 +62,43 @@ func NewRedisBackend(config *backend.RedisConfig) (*redisBackend, error) {
 	}
 
 	statusCode := client.Ping(rb.ctx)
+	if statusCode.Err() == nil {
+		rb.indexNames()
+	}
 
 	return rb, statusCode.Err()
 }
 
+// Adds the participants and channels registered before the names were indexed by their canonical keys.
+// If several of them share a key, only the first one can be found.
+func (r *redisBackend) indexNames() {
+	for _, index := range []struct{ setKey, namesKey string }{{"participants:", participantNamesKey}, {"channels:", channelNamesKey}} {
+		for _, name := range r.client.SMembers(r.ctx, index.setKey).Val() {
+			if !r.client.HSetNX(r.ctx, index.namesKey, canonical.Key(name), name).Val() {
+				if indexed := r.client.HGet(r.ctx, index.namesKey, canonical.Key(name)).Val(); indexed != name {
+					log.Logger.Warn("%s clashes with %s, it can't be found anymore", name, indexed)
+				}
+			}
+		}
+	}
+}
+
 func (r *redisBackend) doesParticipantExist(participantUsername string) bool {
-	isMember := r.client.SIsMember(r.ctx, "participants:", participantUsername)
-	return isMember.Val()
+	return r.client.HExists(r.ctx, participantNamesKey, canonical.Key(participantUsername)).Val()
+}
+
+// Returns the name the participant was registered with, empty if they don't exist.
+func (r *redisBackend) participantName(username string) string {
+	return r.client.HGet(r.ctx, participantNamesKey, canonical.Key(username)).Val()
 }
 
 func (r *redisBackend) doesChannelExist(channelname string) bool {
-	isMember := r.client.SIsMember(r.ctx, "channels:", channelname)
-	return isMember.Val()
+	return r.client.HExists(r.ctx, channelNamesKey, canonical.Key(channelname)).Val()
+}
+
+// Returns the name the channel was registered with, empty if it doesn't exist.
+func (r *redisBackend) channelName(channelname string) string {
+	return r.client.HGet(r.ctx, channelNamesKey, canonical.Key(channelname)).Val()
 }
 
 func (r *redisBackend) HasParticipant(username string) bool {
@@ -108,32 +146,51 @@ func (r *redisBackend) RegisterParticipant(participant *types.Participant) {
 
 	if len(r.client.HGetAll(r.ctx, participantHash).Val()) != 0 {
 		r.client.SAdd(r.ctx, "participants:", participant.Username)
+		r.client.HSet(r.ctx, participantNamesKey, canonical.Key(participant.Username), participant.Username)
 		log.Logger.Info("Registered %s participant", participant.Username)
 	} else {
 		log.Logger.Info("Failed to register participant %s", participant.Username)
 	}
 }
 
-// NOTE: Not a part of a public API yet.
-func (r *redisBackend) deleteParticipant(username string) {
+func (r *redisBackend) DeleteParticipant(username string) bool {
 	r.Lock()
 	defer r.Unlock()
 
-	if r.doesParticipantExist(username) {
-		r.client.SRem(r.ctx, "participants:", username)
-		participantHash := util.Sha256Checksum([]byte(username))
-		r.client.Del(r.ctx, participantHash)
+	// The data kept for the participant is keyed by the name they registered with.
+	username = r.participantName(username)
+	if username == "" {
+		return false
+	}
+
+	r.client.SRem(r.ctx, "participants:", username)
+	r.client.HDel(r.ctx, participantNamesKey, canonical.Key(username))
+	participantHash := util.Sha256Checksum([]byte(username))
+	r.client.Del(r.ctx, participantHash, markersKey(username), queueKey(username), twoFactorKey(username), recoveryCodesKey(username))
+	// SCAN doesn't block the server the way KEYS does.
+	for iter := r.client.Scan(r.ctx, 0, sentCodeKey(username, "*"), 0).Iterator(); iter.Next(r.ctx); {
+		r.client.Del(r.ctx, iter.Val())
+	}
+
+	for _, hash := range r.client.SMembers(r.ctx, apiTokensKey(username)).Val() {
+		r.client.Del(r.ctx, apiTokenKey(hash))
+	}
+	r.client.Del(r.ctx, apiTokensKey(username))
 
-		log.Logger.Info("Participant %s was deleted", username)
+	for _, channelname := range r.client.SMembers(r.ctx, "channels:").Val() {
+		r.client.SRem(r.ctx, membersKey(channelname), username)
 	}
+
+	log.Logger.Info("Participant %s was deleted", username)
+	return true
 }
 
 func (r *redisBackend) AuthParticipant(participant *types.Participant) bool {
 	r.RLock()
 	defer r.RUnlock()
 
-	if r.doesParticipantExist(participant.Username) {
-		participantHash := util.Sha256Checksum([]byte(participant.Username))
+	if username := r.participantName(participant.Username); username != "" {
+		participantHash := util.Sha256Checksum([]byte(username))
 
 		// NOTE: This has to be in sync with types.Participant struct because it relies on the order of fields.
 		// Field(1) is expected to correspond to the Password field inside that struct.
@@ -147,36 +204,311 @@ func (r *redisBackend) AuthParticipant(participant *types.Participant) bool {
 	return false
 }
 
+func (r *redisBackend) GetParticipant(username string) *types.Participant {
+	r.RLock()
+	defer r.RUnlock()
+
+	username = r.participantName(username)
+	if username == "" {
+		return nil
+	}
+	data := r.client.HGetAll(r.ctx, util.Sha256Checksum([]byte(username))).Val()
+	if len(data) == 0 {
+		return nil
+	}
+
+	participant := &types.Participant{}
+	value := reflect.ValueOf(participant).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		setFieldValue(value.Field(i), data[value.Type().Field(i).Name])
+	}
+	return participant
+}
+
+func (r *redisBackend) UpdatePassword(username string, password string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	username = r.participantName(username)
+	if username == "" {
+		return false
+	}
+
+	passwordHash := util.Sha256Checksum([]byte(password))
+	if !validation.ValidatePasswordSha256(passwordHash) {
+		log.Logger.Panic("Failed to update password of participant %s. Password validation failed", username)
+	}
+
+	// NOTE: This has to be in sync with types.Participant struct because it relies on the order of fields.
+	passwordFieldName := reflect.TypeOf(types.Participant{}).Field(1).Name
+	r.client.HSet(r.ctx, util.Sha256Checksum([]byte(username)), passwordFieldName, passwordHash)
+	return true
+}
+
+func (r *redisBackend) MarkEmailVerified(username string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	username = r.participantName(username)
+	if username == "" {
+		return false
+	}
+	r.client.HSet(r.ctx, util.Sha256Checksum([]byte(username)), "Unverified", false)
+	return true
+}
+
+func (r *redisBackend) UpdateProfile(username string, displayName string, bio string, timezone string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	username = r.participantName(username)
+	if username == "" {
+		return false
+	}
+	r.client.HSet(r.ctx, util.Sha256Checksum([]byte(username)), "DisplayName", displayName, "Bio", bio, "Timezone", timezone)
+	return true
+}
+
+func (r *redisBackend) SetLastSeen(username string, lastSeen time.Time) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	username = r.participantName(username)
+	if username == "" {
+		return false
+	}
+	r.client.HSet(r.ctx, util.Sha256Checksum([]byte(username)), "LastSeen", lastSeen)
+	return true
+}
+
+func (r *redisBackend) GetTwoFactor(username string) *types.TwoFactor {
+	r.RLock()
+	defer r.RUnlock()
+
+	data := r.client.HGetAll(r.ctx, twoFactorKey(username)).Val()
+	if len(data) == 0 {
+		return nil
+	}
+
+	twoFactor := &types.TwoFactor{}
+	value := reflect.ValueOf(twoFactor).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		setFieldValue(value.Field(i), data[value.Type().Field(i).Name])
+	}
+	return twoFactor
+}
+
+func (r *redisBackend) SetTwoFactor(username string, twoFactor *types.TwoFactor) {
+	r.Lock()
+	defer r.Unlock()
+
+	value := reflect.ValueOf(twoFactor).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		r.client.HSet(r.ctx, twoFactorKey(username), value.Type().Field(i).Name, value.Field(i).Interface())
+	}
+}
+
+func (r *redisBackend) DeleteTwoFactor(username string) {
+	r.Lock()
+	defer r.Unlock()
+	r.client.Del(r.ctx, twoFactorKey(username), recoveryCodesKey(username))
+}
+
+func (r *redisBackend) SetRecoveryCodes(username string, codes []string) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := recoveryCodesKey(username)
+	r.client.Del(r.ctx, key)
+	if len(codes) > 0 {
+		members := make([]interface{}, 0, len(codes))
+		for _, code := range codes {
+			members = append(members, code)
+		}
+		r.client.SAdd(r.ctx, key, members...)
+	}
+}
+
+func (r *redisBackend) RedeemRecoveryCode(username string, code string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	// SREM returns the number of removed members, so a code can't be redeemed twice, even by concurrent sessions.
+	return r.client.SRem(r.ctx, recoveryCodesKey(username), code).Val() == 1
+}
+
+func (r *redisBackend) StoreAPIToken(token *types.APIToken) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := apiTokenKey(token.Hash)
+	if r.client.Exists(r.ctx, key).Val() == 1 {
+		log.Logger.Panic("API token %s already exists", token.Id)
+	}
+
+	value := reflect.ValueOf(token).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		r.client.HSet(r.ctx, key, value.Type().Field(i).Name, value.Field(i).Interface())
+	}
+	r.client.SAdd(r.ctx, apiTokensKey(token.Username), token.Hash)
+}
+
+func (r *redisBackend) GetAPIToken(hash string) *types.APIToken {
+	r.RLock()
+	defer r.RUnlock()
+	return r.getAPIToken(hash)
+}
+
+func (r *redisBackend) GetAPITokens(username string) []*types.APIToken {
+	r.RLock()
+	defer r.RUnlock()
+
+	var tokens []*types.APIToken
+	for _, hash := range r.client.SMembers(r.ctx, apiTokensKey(username)).Val() {
+		if token := r.getAPIToken(hash); token != nil {
+			tokens = append(tokens, token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool { return tokens[i].Created.Before(tokens[j].Created) })
+	return tokens
+}
+
+func (r *redisBackend) DeleteAPIToken(username string, id string) *types.APIToken {
+	r.Lock()
+	defer r.Unlock()
+
+	for _, hash := range r.client.SMembers(r.ctx, apiTokensKey(username)).Val() {
+		if token := r.getAPIToken(hash); token != nil && token.Id == id {
+			r.client.Del(r.ctx, apiTokenKey(hash))
+			r.client.SRem(r.ctx, apiTokensKey(username), hash)
+			return token
+		}
+	}
+	return nil
+}
+
+// The caller has to hold the lock.
+func (r *redisBackend) getAPIToken(hash string) *types.APIToken {
+	data := r.client.HGetAll(r.ctx, apiTokenKey(hash)).Val()
+	if len(data) == 0 {
+		return nil
+	}
+
+	token := &types.APIToken{}
+	value := reflect.ValueOf(token).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		setFieldValue(value.Field(i), data[value.Type().Field(i).Name])
+	}
+	return token
+}
+
+func (r *redisBackend) StoreOneTimeCode(code string, username string, ttl time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+	r.client.Set(r.ctx, codeKey(code), username, ttl)
+}
+
+func (r *redisBackend) RedeemOneTimeCode(code string) (string, bool) {
+	r.Lock()
+	defer r.Unlock()
+
+	// GETDEL makes sure the code can't be redeemed twice, even by concurrent sessions.
+	username, err := r.client.GetDel(r.ctx, codeKey(code)).Result()
+	if err != nil {
+		return "", false
+	}
+	return username, true
+}
+
+func (r *redisBackend) GetSentCode(username string, purpose string) *types.SentCode {
+	r.RLock()
+	defer r.RUnlock()
+
+	data := r.client.HGetAll(r.ctx, sentCodeKey(username, purpose)).Val()
+	if len(data) == 0 {
+		return nil
+	}
+
+	sent := &types.SentCode{}
+	value := reflect.ValueOf(sent).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		setFieldValue(value.Field(i), data[value.Type().Field(i).Name])
+	}
+	return sent
+}
+
+func (r *redisBackend) SetSentCode(username string, purpose string, sent *types.SentCode, ttl time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := sentCodeKey(username, purpose)
+	value := reflect.ValueOf(sent).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		r.client.HSet(r.ctx, key, value.Type().Field(i).Name, value.Field(i).Interface())
+	}
+	r.client.Expire(r.ctx, key, ttl)
+}
+
 func (r *redisBackend) StoreMessage(message *types.ChatMessage) {
 	r.Lock()
 	defer r.Unlock()
 
 	var messagesKey string
+	var sequenceKey string
 	var messageId string
 
 	if message.Channel != "" {
 		if !r.doesChannelExist(message.Channel) {
 			log.Logger.Panic("Failed to store a message, channel %s doesn't exist", message.Channel)
 		}
+		message.Channel = r.channelName(message.Channel)
 		messagesKey = "messages/" + message.Channel + ":"
-		messageId = message.Sender + ":" + time.Now().Format(time.DateTime)
-		r.client.SAdd(r.ctx, messagesKey, messageId)
+		sequenceKey = "sequence/" + message.Channel + ":"
 	} else {
 		messagesKey = "messages/general:"
-		messageId = message.Sender + ":" + time.Now().Format(time.DateTime)
-		r.client.SAdd(r.ctx, messagesKey, messageId)
+		sequenceKey = "sequence/general:"
 	}
 
+	if message.ParentId != "" && !r.doesMessageExist(message.ParentId) {
+		log.Logger.Panic("Failed to store a reply, message %s doesn't exist", message.ParentId)
+	}
+
+	// INCR is atomic, so sequence numbers are unique even if multiple sessions share the same redis instance.
+	message.Seq = uint64(r.client.Incr(r.ctx, sequenceKey).Val())
+
+	if message.Id == "" {
+		message.Id = util.RandomHex(types.MessageIdLength)
+		for r.doesMessageExist(message.Id) {
+			message.Id = util.RandomHex(types.MessageIdLength)
+		}
+	}
+
+	messageId = messageKey(message.Id)
+	r.client.SAdd(r.ctx, messagesKey, messageId)
+
 	value := reflect.ValueOf(message).Elem()
 	for i := 0; i < value.NumField(); i++ {
 		fieldname := value.Type().Field(i).Name
 		fieldvalue := value.Field(i).Interface()
+		// Reactions are stored as separate Reaction:<emoji> fields, see AddReaction.
+		if value.Field(i).Kind() == reflect.Map {
+			continue
+		}
 		if value.Field(i).Type() == reflect.TypeOf(message.Contents) {
 			fieldvalue = message.Contents.String()
 		}
 		r.client.HSet(r.ctx, messageId, fieldname, fieldvalue)
 	}
 
+	if !message.Deleted {
+		r.indexMessage(message.Id, message.Contents.String())
+	}
+
+	if message.ParentId != "" {
+		r.client.SAdd(r.ctx, repliesKey(message.ParentId), messageId)
+		r.client.HIncrBy(r.ctx, messageKey(message.ParentId), "ReplyCount", 1)
+	}
+
 	if len(r.client.HGetAll(r.ctx, messageId).Val()) != 0 {
 		log.Logger.Info("Message was stored")
 	} else {
@@ -184,8 +516,356 @@ func (r *redisBackend) StoreMessage(message *types.ChatMessage) {
 	}
 }
 
-// NOTE: Not a part of a public API yet.
-func (r *redisBackend) deleteMessages(channels ...string) {
+func (r *redisBackend) GetMessage(id string) *types.ChatMessage {
+	r.RLock()
+	defer r.RUnlock()
+
+	data := r.client.HGetAll(r.ctx, messageKey(id)).Val()
+	if len(data) == 0 {
+		return nil
+	}
+	return readMessage(data)
+}
+
+func (r *redisBackend) GetReplies(id string) []*types.ChatMessage {
+	r.RLock()
+	defer r.RUnlock()
+
+	members := r.client.SMembers(r.ctx, repliesKey(id)).Val()
+	if len(members) == 0 {
+		return nil
+	}
+
+	replies := make([]*types.ChatMessage, 0, len(members))
+	for _, messageId := range members {
+		if data := r.client.HGetAll(r.ctx, messageId).Val(); len(data) != 0 {
+			replies = append(replies, readMessage(data))
+		}
+	}
+	sort.Slice(replies, func(i, j int) bool { return replies[i].Seq < replies[j].Seq })
+	return replies
+}
+
+func (r *redisBackend) SetMessageSender(id string, sender string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.doesMessageExist(id) {
+		return false
+	}
+	r.client.HSet(r.ctx, messageKey(id), "Sender", sender)
+	return true
+}
+
+func (r *redisBackend) EditMessage(id string, contents *bytes.Buffer, editTime string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	key := messageKey(id)
+	if !r.doesMessageExist(id) || r.client.HGet(r.ctx, key, "Deleted").Val() == "1" {
+		return false
+	}
+
+	r.unindexMessage(id, r.client.HGet(r.ctx, key, "Contents").Val())
+	r.client.HSet(r.ctx, key, "Contents", contents.String(), "EditTime", editTime)
+	r.indexMessage(id, contents.String())
+	log.Logger.Info("Edited message %s", id)
+	return true
+}
+
+func (r *redisBackend) DeleteMessage(id string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	key := messageKey(id)
+	if !r.doesMessageExist(id) || r.client.HGet(r.ctx, key, "Deleted").Val() == "1" {
+		return false
+	}
+
+	r.unindexMessage(id, r.client.HGet(r.ctx, key, "Contents").Val())
+	r.client.HSet(r.ctx, key, "Contents", "", "Deleted", true)
+	log.Logger.Info("Deleted message %s", id)
+	return true
+}
+
+// Messages without a timestamp are never considered expired.
+func (r *redisBackend) DeleteMessagesBefore(channelname string, before time.Time) int {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.purgeMessages(channelname, func(index int, count int, msg *types.ChatMessage) bool {
+		return !msg.Timestamp.IsZero() && msg.Timestamp.Before(before)
+	})
+}
+
+func (r *redisBackend) TrimMessages(channelname string, maxCount int) int {
+	r.Lock()
+	defer r.Unlock()
+
+	return r.purgeMessages(channelname, func(index int, count int, msg *types.ChatMessage) bool {
+		return index < count-maxCount
+	})
+}
+
+// Removes the messages for which purge returns true, the caller has to hold the lock.
+// Nothing is removed from a channel which doesn't exist, since it might have been renamed or deleted meanwhile.
+func (r *redisBackend) purgeMessages(channelname string, purge func(index int, count int, msg *types.ChatMessage) bool) int {
+	messagesKey := "messages/general:"
+	if channelname != "" {
+		if !r.doesChannelExist(channelname) {
+			return 0
+		}
+		messagesKey = "messages/" + r.channelName(channelname) + ":"
+	}
+
+	history := r.readChatHistory(messagesKey)
+	purged := make(map[string]bool)
+	for index, msg := range history {
+		if purge(index, len(history), msg) {
+			purged[msg.Id] = true
+		}
+	}
+	// Replies are removed together with the message which started the thread, so no thread is left without it.
+	for _, msg := range history {
+		if purged[msg.ParentId] {
+			purged[msg.Id] = true
+		}
+	}
+
+	for _, msg := range history {
+		if !purged[msg.Id] {
+			continue
+		}
+
+		key := messageKey(msg.Id)
+		r.client.SRem(r.ctx, messagesKey, key)
+		r.deleteMessage(key)
+		if msg.ParentId != "" && r.doesMessageExist(msg.ParentId) {
+			r.client.SRem(r.ctx, repliesKey(msg.ParentId), key)
+			r.client.HIncrBy(r.ctx, messageKey(msg.ParentId), "ReplyCount", -1)
+		}
+	}
+	return len(purged)
+}
+
+func (r *redisBackend) StoreAttachment(attachment *types.Attachment) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := attachmentKey(attachment.Id)
+	if r.client.Exists(r.ctx, key).Val() != 0 {
+		log.Logger.Panic("Attachment %s already exists", attachment.Id)
+	}
+
+	value := reflect.ValueOf(attachment).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		r.client.HSet(r.ctx, key, value.Type().Field(i).Name, value.Field(i).Interface())
+	}
+}
+
+func (r *redisBackend) GetAttachment(id string) *types.Attachment {
+	r.RLock()
+	defer r.RUnlock()
+
+	data := r.client.HGetAll(r.ctx, attachmentKey(id)).Val()
+	if len(data) == 0 {
+		return nil
+	}
+
+	attachment := &types.Attachment{}
+	value := reflect.ValueOf(attachment).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		setFieldValue(value.Field(i), data[value.Type().Field(i).Name])
+	}
+	return attachment
+}
+
+func (r *redisBackend) DeleteAttachment(id string) {
+	r.Lock()
+	defer r.Unlock()
+	r.client.Del(r.ctx, attachmentKey(id))
+}
+
+func (r *redisBackend) GetLoginAttempts(key string) *types.LoginAttempts {
+	r.RLock()
+	defer r.RUnlock()
+
+	data := r.client.HGetAll(r.ctx, loginAttemptsKey(key)).Val()
+	if len(data) == 0 {
+		return nil
+	}
+
+	attempts := &types.LoginAttempts{}
+	value := reflect.ValueOf(attempts).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		setFieldValue(value.Field(i), data[value.Type().Field(i).Name])
+	}
+	return attempts
+}
+
+func (r *redisBackend) SetLoginAttempts(key string, attempts *types.LoginAttempts, ttl time.Duration) {
+	r.Lock()
+	defer r.Unlock()
+
+	hashKey := loginAttemptsKey(key)
+	value := reflect.ValueOf(attempts).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		r.client.HSet(r.ctx, hashKey, value.Type().Field(i).Name, value.Field(i).Interface())
+	}
+	// Redis drops the attempts itself once they expire.
+	r.client.Expire(r.ctx, hashKey, ttl)
+}
+
+func (r *redisBackend) DeleteLoginAttempts(key string) {
+	r.Lock()
+	defer r.Unlock()
+	r.client.Del(r.ctx, loginAttemptsKey(key))
+}
+
+func (r *redisBackend) AddReaction(id string, username string, emoji string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	key := messageKey(id)
+	if !r.doesMessageExist(id) || r.client.HGet(r.ctx, key, "Deleted").Val() == "1" {
+		return false
+	}
+
+	// SADD returns the number of added members, which is zero if the participant has already reacted.
+	if r.client.SAdd(r.ctx, reactionsKey(id, emoji), username).Val() == 0 {
+		return false
+	}
+	r.client.HIncrBy(r.ctx, key, reactionPrefix+emoji, 1)
+	return true
+}
+
+func (r *redisBackend) RemoveReaction(id string, username string, emoji string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	key := messageKey(id)
+	if !r.doesMessageExist(id) {
+		return false
+	}
+
+	if r.client.SRem(r.ctx, reactionsKey(id, emoji), username).Val() == 0 {
+		return false
+	}
+	if r.client.HIncrBy(r.ctx, key, reactionPrefix+emoji, -1).Val() <= 0 {
+		r.client.HDel(r.ctx, key, reactionPrefix+emoji)
+	}
+	return true
+}
+
+func (r *redisBackend) EnqueueMessage(username string, id string, capacity int) {
+	r.Lock()
+	defer r.Unlock()
+
+	// The participant might have deleted their account since the message was sent.
+	if !r.doesParticipantExist(username) {
+		return
+	}
+
+	key := queueKey(username)
+	r.client.ZAdd(r.ctx, key, redis.Z{Score: float64(time.Now().UnixNano()), Member: id})
+	if capacity > 0 {
+		// Keep only the most recent capacity members.
+		r.client.ZRemRangeByRank(r.ctx, key, 0, int64(-capacity-1))
+	}
+}
+
+// Messages which were removed from the history since they were enqueued are skipped.
+func (r *redisBackend) GetQueuedMessages(username string, maxAge time.Duration) []*types.ChatMessage {
+	r.RLock()
+	defer r.RUnlock()
+
+	minScore := "-inf"
+	if maxAge != 0 {
+		minScore = strconv.FormatInt(time.Now().Add(-maxAge).UnixNano(), 10)
+	}
+	ids := r.client.ZRangeByScore(r.ctx, queueKey(username), &redis.ZRangeBy{Min: minScore, Max: "+inf"}).Val()
+
+	var messages []*types.ChatMessage
+	for _, id := range ids {
+		data := r.client.HGetAll(r.ctx, messageKey(id)).Val()
+		if len(data) == 0 {
+			continue
+		}
+		if msg := readMessage(data); !msg.Deleted {
+			messages = append(messages, msg)
+		}
+	}
+	return messages
+}
+
+func (r *redisBackend) DeleteQueuedMessages(username string) {
+	r.Lock()
+	defer r.Unlock()
+	r.client.Del(r.ctx, queueKey(username))
+}
+
+func (r *redisBackend) SetReadMarker(username string, channelname string, seq uint64) {
+	r.Lock()
+	defer r.Unlock()
+
+	key := markersKey(username)
+	field := markerField(channelname)
+	current, _ := strconv.ParseUint(r.client.HGet(r.ctx, key, field).Val(), 10, 64)
+	if seq > current {
+		r.client.HSet(r.ctx, key, field, seq)
+	}
+}
+
+func (r *redisBackend) GetReadMarkers(username string) map[string]uint64 {
+	r.RLock()
+	defer r.RUnlock()
+
+	data := r.client.HGetAll(r.ctx, markersKey(username)).Val()
+	markers := make(map[string]uint64, len(data))
+	for field, value := range data {
+		seq, _ := strconv.ParseUint(value, 10, 64)
+		if field == markerField("") {
+			field = ""
+		}
+		markers[field] = seq
+	}
+	return markers
+}
+
+func (r *redisBackend) SearchMessages(query *types.SearchQuery) ([]*types.ChatMessage, int) {
+	r.RLock()
+	defer r.RUnlock()
+
+	if len(query.Terms) == 0 {
+		return nil, 0
+	}
+
+	// Start with the messages containing the first term and drop the ones missing any of the others.
+	scores := make(map[string]int)
+	for _, member := range r.client.ZRangeWithScores(r.ctx, indexKey(query.Terms[0]), 0, -1).Val() {
+		scores[member.Member.(string)] = int(member.Score)
+	}
+	for _, term := range query.Terms[1:] {
+		for id := range scores {
+			count, err := r.client.ZScore(r.ctx, indexKey(term), id).Result()
+			if err != nil {
+				delete(scores, id)
+				continue
+			}
+			scores[id] += int(count)
+		}
+	}
+
+	hits := make([]backend.SearchHit, 0, len(scores))
+	for id, score := range scores {
+		if data := r.client.HGetAll(r.ctx, messageKey(id)).Val(); len(data) != 0 {
+			hits = append(hits, backend.SearchHit{Message: readMessage(data), Score: score})
+		}
+	}
+	return backend.RankSearchHits(hits, query)
+}
+
+func (r *redisBackend) DeleteMessages(channels ...string) {
 	r.Lock()
 	defer r.Unlock()
 
@@ -195,14 +875,14 @@ func (r *redisBackend) deleteMessages(channels ...string) {
 				log.Logger.Panic("Cannot delete messages, channel %s doesn't exist", chName)
 			}
 
-			channelMessagesKey := "messages/" + chName + ":"
+			channelMessagesKey := "messages/" + r.channelName(chName) + ":"
 			messages := r.client.SMembers(r.ctx, channelMessagesKey).Val()
 
 			for _, messageId := range messages {
 				if len(r.client.HGetAll(r.ctx, messageId).Val()) == 0 {
 					log.Logger.Panic("Message id:%s doesn't exist", messageId)
 				}
-				r.client.Del(r.ctx, messageId)
+				r.deleteMessage(messageId)
 			}
 			r.client.SPopN(r.ctx, channelMessagesKey, int64(len(messages)))
 		}
@@ -211,7 +891,7 @@ func (r *redisBackend) deleteMessages(channels ...string) {
 		members := r.client.SMembers(r.ctx, generalMessagesKey).Val()
 		for _, messageId := range members {
 			r.client.SRem(r.ctx, generalMessagesKey, messageId)
-			r.client.Del(r.ctx, messageId)
+			r.deleteMessage(messageId)
 		}
 		r.client.SPopN(r.ctx, generalMessagesKey, int64(len(members)))
 
@@ -248,20 +928,134 @@ func (r *redisBackend) RegisterChannel(channel *types.Channel) {
 
 	if len(r.client.HGetAll(r.ctx, channelHash).Val()) != 0 {
 		r.client.SAdd(r.ctx, "channels:", channel.Name)
+		r.client.HSet(r.ctx, channelNamesKey, canonical.Key(channel.Name), channel.Name)
 		log.Logger.Info("Registered %s channel", channel.Name)
 	} else {
 		log.Logger.Info("Failed to register channel %s", channel.Name)
 	}
 }
 
+func (r *redisBackend) UpdateChannel(channelname string, channel *types.Channel) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.doesChannelExist(channelname) {
+		log.Logger.Panic("Failed to update channel, channel %s doesn't exist", channelname)
+	}
+	channelname = r.channelName(channelname)
+
+	channelHash := util.Sha256Checksum([]byte(channelname))
+
+	if channel.Name != channelname {
+		// Changing only the case of the name doesn't clash with the channel itself.
+		if !canonical.Equal(channel.Name, channelname) && r.doesChannelExist(channel.Name) {
+			return false
+		}
+
+		oldMessagesKey := "messages/" + channelname + ":"
+		for _, messageId := range r.client.SMembers(r.ctx, oldMessagesKey).Val() {
+			r.client.HSet(r.ctx, messageId, "Channel", channel.Name)
+		}
+
+		// Markers are stored in the hashes of every participant under channel's name.
+		for _, username := range r.client.SMembers(r.ctx, "participants:").Val() {
+			key := markersKey(username)
+			if seq, err := r.client.HGet(r.ctx, key, channelname).Result(); err == nil {
+				r.client.HSet(r.ctx, key, channel.Name, seq)
+				r.client.HDel(r.ctx, key, channelname)
+			}
+		}
+
+		r.renameKey(oldMessagesKey, "messages/"+channel.Name+":")
+		r.renameKey("sequence/"+channelname+":", "sequence/"+channel.Name+":")
+		r.renameKey(membersKey(channelname), membersKey(channel.Name))
+		r.renameKey(pinsKey(channelname), pinsKey(channel.Name))
+
+		newChannelHash := util.Sha256Checksum([]byte(channel.Name))
+		r.renameKey(channelHash, newChannelHash)
+		channelHash = newChannelHash
+
+		r.client.SRem(r.ctx, "channels:", channelname)
+		r.client.SAdd(r.ctx, "channels:", channel.Name)
+		r.client.HDel(r.ctx, channelNamesKey, canonical.Key(channelname))
+		r.client.HSet(r.ctx, channelNamesKey, canonical.Key(channel.Name), channel.Name)
+		log.Logger.Info("Renamed channel %s to %s", channelname, channel.Name)
+	}
+
+	r.client.HSet(r.ctx, channelHash, "Name", channel.Name, "Desc", channel.Desc)
+	return true
+}
+
+// RENAME fails if the key doesn't exist, for example if nothing was posted in a channel yet.
+func (r *redisBackend) renameKey(key string, newKey string) {
+	if r.client.Exists(r.ctx, key).Val() != 0 {
+		r.client.Rename(r.ctx, key, newKey)
+	}
+}
+
+func (r *redisBackend) AddChannelMember(channelname string, username string) {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.doesChannelExist(channelname) {
+		log.Logger.Panic("Failed to add a member, channel %s doesn't exist", channelname)
+	}
+	r.client.SAdd(r.ctx, membersKey(r.channelName(channelname)), username)
+}
+
+// Only messages which belong to the channel can be pinned, and every message only once.
+func (r *redisBackend) PinMessage(channelname string, id string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.doesChannelExist(channelname) {
+		log.Logger.Panic("Failed to pin a message, channel %s doesn't exist", channelname)
+	}
+	channelname = r.channelName(channelname)
+
+	data := r.client.HGetAll(r.ctx, messageKey(id)).Val()
+	if len(data) == 0 || data["Deleted"] == "1" || data["Channel"] != channelname {
+		return false
+	}
+
+	key := pinsKey(channelname)
+	if r.client.LPos(r.ctx, key, id, redis.LPosArgs{}).Err() == nil {
+		return false
+	}
+	r.client.RPush(r.ctx, key, id)
+	return true
+}
+
+func (r *redisBackend) UnpinMessage(channelname string, id string) bool {
+	r.Lock()
+	defer r.Unlock()
+
+	if !r.doesChannelExist(channelname) {
+		log.Logger.Panic("Failed to unpin a message, channel %s doesn't exist", channelname)
+	}
+	return r.client.LRem(r.ctx, pinsKey(r.channelName(channelname)), 0, id).Val() != 0
+}
+
 func (r *redisBackend) DeleteChannel(channelname string) bool {
 	r.Lock()
 	defer r.Unlock()
 
 	if r.doesChannelExist(channelname) {
+		channelname = r.channelName(channelname)
 		r.client.SRem(r.ctx, "channels:", channelname)
+		r.client.HDel(r.ctx, channelNamesKey, canonical.Key(channelname))
+
+		// Messages are removed together with their replies, reactions and index entries.
+		messagesKey := "messages/" + channelname + ":"
+		for _, messageId := range r.client.SMembers(r.ctx, messagesKey).Val() {
+			r.deleteMessage(messageId)
+		}
+		for _, username := range r.client.SMembers(r.ctx, "participants:").Val() {
+			r.client.HDel(r.ctx, markersKey(username), channelname)
+		}
+
 		channelHash := util.Sha256Checksum([]byte(channelname))
-		r.client.Del(r.ctx, channelHash)
+		r.client.Del(r.ctx, channelHash, membersKey(channelname), pinsKey(channelname), messagesKey, "sequence/"+channelname+":")
 		log.Logger.Info("Channel %s was deleted", channelname)
 
 		return true
@@ -282,11 +1076,17 @@ func (r *redisBackend) GetChatHistory(channelname ...string) []*types.ChatMessag
 		if !r.doesChannelExist(channelname[0]) {
 			log.Logger.Panic("Failed to retrieve chat history, channel %s doesn't exist", channelname)
 		}
-		messagesKey = "messages/" + channelname[0] + ":"
+		messagesKey = "messages/" + r.channelName(channelname[0]) + ":"
 	} else {
 		messagesKey = "messages/general:"
 	}
 
+	return r.readChatHistory(messagesKey)
+}
+
+// Reads all the messages in the set under messagesKey, sorted by their sequence numbers.
+// The caller has to hold the lock.
+func (r *redisBackend) readChatHistory(messagesKey string) []*types.ChatMessage {
 	if members := r.client.SMembers(r.ctx, messagesKey).Val(); len(members) != 0 {
 		messages := make([]*types.ChatMessage, 0, len(members))
 		for _, messageid := range members { // O(n^2)
@@ -295,28 +1095,200 @@ func (r *redisBackend) GetChatHistory(channelname ...string) []*types.ChatMessag
 				log.Logger.Panic("Message %s not found", messageid)
 			}
 
-			message := &types.ChatMessage{}
-
-			value := reflect.ValueOf(message).Elem()
-			for i := 0; i < value.NumField(); i++ {
-				fieldname := value.Type().Field(i).Name
-				if fieldname == value.Type().Field(0).Name {
-					contents := data[fieldname]
-					buf := bytes.NewBuffer(make([]byte, 0, len(contents)))
-					buf.WriteString(contents)
-					value.Field(i).Set(reflect.ValueOf(buf))
-					continue
-				}
-				value.Field(i).Set(reflect.ValueOf(data[fieldname]))
-			}
-			message = (*types.ChatMessage)(value.Addr().UnsafePointer())
-			messages = append(messages, message)
+			messages = append(messages, readMessage(data))
 		}
+
+		// Messages are stored in a set which doesn't preserve the order,
+		// so we have to sort them by their sequence numbers.
+		sort.Slice(messages, func(i, j int) bool { return messages[i].Seq < messages[j].Seq })
+
 		return messages
 	}
 	return nil
 }
 
+// Messages are stored in hashes under message/<id> keys,
+// while the sets of messages/<channel>: keys hold the keys of all the messages in a channel.
+func messageKey(id string) string {
+	return "message/" + id
+}
+
+// The set under replies/<id>: key holds the keys of all the replies to a message.
+func repliesKey(id string) string {
+	return "replies/" + id + ":"
+}
+
+// The set under reactions/<id>/<emoji>: key holds the usernames of all the participants
+// who reacted to a message with an emoji, and the message's hash holds the count in Reaction:<emoji> field.
+func reactionsKey(id string, emoji string) string {
+	return "reactions/" + id + "/" + emoji + ":"
+}
+
+const reactionPrefix = "Reaction:"
+
+// The sorted set under queue/<username>: key holds the ids of the messages
+// queued for an offline participant, scored by the time they were enqueued at.
+// Keys of participant's data contain the canonical key of the username, so they're found however the name is typed.
+func queueKey(username string) string {
+	return "queue/" + canonical.Key(username) + ":"
+}
+
+// The set under members/<channel>: key holds the usernames of all the channel's members.
+func membersKey(channelname string) string {
+	return "members/" + channelname + ":"
+}
+
+// The hash under markers/<username>: key holds participant's read marker in each channel,
+// the marker in the general chat is stored under the general field, the same way as its messages.
+func markersKey(username string) string {
+	return "markers/" + canonical.Key(username) + ":"
+}
+
+func markerField(channelname string) string {
+	if channelname == "" {
+		return "general"
+	}
+	return channelname
+}
+
+// Metadata of a shared file is stored in a hash under attachment/<id> key.
+func attachmentKey(id string) string {
+	return "attachment/" + id
+}
+
+func codeKey(code string) string {
+	return "code/" + code + ":"
+}
+
+// An API token is stored in a hash under apitoken/<hash>: key,
+// and the set under apitokens/<username>: key holds the hashes of participant's tokens.
+func apiTokenKey(hash string) string {
+	return "apitoken/" + hash + ":"
+}
+
+func apiTokensKey(username string) string {
+	return "apitokens/" + canonical.Key(username) + ":"
+}
+
+// TOTP secret of a participant is stored in a hash under twofactor/<username>: key,
+// and the hashed recovery codes in a set under recovery/<username>: key.
+func twoFactorKey(username string) string {
+	return "twofactor/" + canonical.Key(username) + ":"
+}
+
+func recoveryCodesKey(username string) string {
+	return "recovery/" + canonical.Key(username) + ":"
+}
+
+// The last code sent to a participant for a purpose is stored in a hash under sentcode/<username>/<purpose>: key.
+func sentCodeKey(username string, purpose string) string {
+	return "sentcode/" + canonical.Key(username) + "/" + purpose + ":"
+}
+
+func loginAttemptsKey(key string) string {
+	return "attempts/" + key + ":"
+}
+
+// The list under pins/<channel>: key holds the ids of the pinned messages in the order they were pinned.
+func pinsKey(channelname string) string {
+	return "pins/" + channelname + ":"
+}
+
+// The sorted set under index/<term>: key holds the ids of all the messages containing the term,
+// scored by the number of its occurrences. Used for searching without the RediSearch module.
+func indexKey(term string) string {
+	return "index/" + term + ":"
+}
+
+// The caller has to hold the lock.
+func (r *redisBackend) indexMessage(id string, contents string) {
+	for term, count := range backend.TermCounts(contents) {
+		r.client.ZAdd(r.ctx, indexKey(term), redis.Z{Score: float64(count), Member: id})
+	}
+}
+
+// The caller has to hold the lock.
+func (r *redisBackend) unindexMessage(id string, contents string) {
+	for term := range backend.TermCounts(contents) {
+		r.client.ZRem(r.ctx, indexKey(term), id)
+	}
+}
+
+// Removes the message's hash together with all the keys which belong to it,
+// the caller has to hold the lock.
+func (r *redisBackend) deleteMessage(messageId string) {
+	id := strings.TrimPrefix(messageId, messageKey(""))
+	keys := []string{messageId, repliesKey(id)}
+	data := r.client.HGetAll(r.ctx, messageId).Val()
+	r.unindexMessage(id, data["Contents"])
+	for field := range data {
+		if emoji, found := strings.CutPrefix(field, reactionPrefix); found {
+			keys = append(keys, reactionsKey(id, emoji))
+		}
+	}
+	r.client.Del(r.ctx, keys...)
+}
+
+func (r *redisBackend) doesMessageExist(id string) bool {
+	return r.client.Exists(r.ctx, messageKey(id)).Val() != 0
+}
+
+func readMessage(data map[string]string) *types.ChatMessage {
+	message := &types.ChatMessage{}
+
+	value := reflect.ValueOf(message).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		if value.Field(i).Kind() == reflect.Map {
+			continue
+		}
+		setFieldValue(value.Field(i), data[value.Type().Field(i).Name])
+	}
+
+	for field, count := range data {
+		if emoji, found := strings.CutPrefix(field, reactionPrefix); found {
+			if message.Reactions == nil {
+				message.Reactions = make(map[string]uint64)
+			}
+			message.Reactions[emoji], _ = strconv.ParseUint(count, 10, 64)
+		}
+	}
+	return (*types.ChatMessage)(value.Addr().UnsafePointer())
+}
+
+// Converts a value read from a redis hash into the type of the struct's field.
+func setFieldValue(field reflect.Value, data string) {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(data)
+
+	case reflect.Bool:
+		field.SetBool(data == "1" || data == "true")
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		number, _ := strconv.ParseInt(data, 10, 64)
+		field.SetInt(number)
+
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		number, _ := strconv.ParseUint(data, 10, 64)
+		field.SetUint(number)
+
+	default:
+		if field.Type() == reflect.TypeOf(time.Time{}) {
+			// go-redis writes time.Time values in RFC3339Nano format.
+			timestamp, _ := time.Parse(time.RFC3339Nano, data)
+			field.Set(reflect.ValueOf(timestamp))
+			return
+		}
+		if field.Type() == reflect.TypeOf(&bytes.Buffer{}) {
+			buf := bytes.NewBuffer(make([]byte, 0, len(data)))
+			buf.WriteString(data)
+			field.Set(reflect.ValueOf(buf))
+			return
+		}
+		log.Logger.Panic("Unsupported field type %s", field.Type().String())
+	}
+}
+
 func (r *redisBackend) GetChannels() []*types.Channel {
 	r.RLock()
 	defer r.RUnlock()
@@ -349,8 +1321,13 @@ func (r *redisBackend) GetChannels() []*types.Channel {
 			}
 		}
 		channel = (*types.Channel)(value.Addr().UnsafePointer())
+		channel.Members = r.client.SMembers(r.ctx, membersKey(channelName)).Val()
+		sort.Strings(channel.Members)
+		channel.Pins = r.client.LRange(r.ctx, pinsKey(channelName), 0, -1).Val()
 		channels = append(channels, channel)
 	}
+	// Channels are selected by their position in the list, so the order has to be stable.
+	sort.Slice(channels, func(i, j int) bool { return channels[i].Name < channels[j].Name })
 	return channels
 }
 
@@ -380,9 +1357,8 @@ func (r *redisBackend) GetParticipants() []*types.Participant {
 		value := reflect.ValueOf(participant).Elem()
 		for i := 0; i < value.NumField(); i++ {
 			fieldname := value.Type().Field(i).Name
-			value.Field(i).Set(reflect.ValueOf(data.Val()[fieldname]))
+			setFieldValue(value.Field(i), data.Val()[fieldname])
 		}
-		participant = (*types.Participant)(value.Addr().UnsafePointer())
 		participants = append(participants, participant)
 	}
 	return participants
@@ -2,12 +2,16 @@
 package redis
 
 import (
+	"bytes"
 	"os"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 
 	"github.com/isnastish/chat/pkg/testsetup"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
 
 	"github.com/isnastish/chat/pkg/backend"
 )
@@ -44,11 +48,18 @@ func clearChannels(rb *redisBackend, t *testing.T) {
 
 func clearParticipants(rb *redisBackend, t *testing.T) {
 	for _, p := range testsetup.Participants {
-		rb.deleteParticipant(p.Username)
+		rb.DeleteParticipant(p.Username)
 		assert.False(t, rb.HasParticipant(p.Username))
 	}
 }
 
+func TestClientOptions(t *testing.T) {
+	options := clientOptions(&backend.RedisConfig{Endpoint: "redis.example.com:6380", Username: "chat", Password: "secret"})
+	assert.Equal(t, "redis.example.com:6380", options.Addr)
+	assert.Equal(t, "chat", options.Username)
+	assert.Equal(t, "secret", options.Password)
+}
+
 func TestRegisterParticipant(t *testing.T) {
 	backend, err := NewRedisBackend(&redisConfig)
 	assert.True(t, err == nil)
@@ -110,7 +121,7 @@ func TestStoreGeneralMessages(t *testing.T) {
 	backend, err := NewRedisBackend(&redisConfig)
 	assert.True(t, err == nil)
 	defer func() {
-		backend.deleteMessages()
+		backend.DeleteMessages()
 		assert.Equal(t, len(backend.GetChatHistory()), 0)
 	}()
 
@@ -133,7 +144,7 @@ func TestStoreChannelMessages(t *testing.T) {
 	}
 
 	defer func() {
-		backend.deleteMessages(testsetup.Channels[0].Name)
+		backend.DeleteMessages(testsetup.Channels[0].Name)
 		assert.Equal(t, len(backend.GetChatHistory(testsetup.Channels[0].Name)), 0)
 	}()
 	for _, msg := range testsetup.BooksChannelMessages {
@@ -143,3 +154,433 @@ func TestStoreChannelMessages(t *testing.T) {
 	channelHistory := backend.GetChatHistory(testsetup.Channels[0].Name)
 	assert.True(t, testsetup.Match(channelHistory, testsetup.BooksChannelMessages, testsetup.ContainsMessage))
 }
+
+func TestEditDeleteMessage(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer func() {
+		backend.DeleteMessages()
+		assert.Equal(t, len(backend.GetChatHistory()), 0)
+	}()
+
+	for _, msg := range testsetup.GeneralMessages {
+		backend.StoreMessage(&msg)
+	}
+
+	id := backend.GetChatHistory()[0].Id
+	assert.True(t, backend.EditMessage(id, bytes.NewBufferString("Edited message"), "12:00:00"))
+	msg := backend.GetMessage(id)
+	assert.Equal(t, "Edited message", msg.Contents.String())
+	assert.Equal(t, "12:00:00", msg.EditTime)
+
+	assert.True(t, backend.DeleteMessage(id))
+	assert.False(t, backend.EditMessage(id, bytes.NewBufferString("Edited again"), "12:00:01"))
+	assert.True(t, backend.GetMessage(id).Deleted)
+}
+
+func TestReplies(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearChannels(backend, t)
+	defer clearChannels(backend, t)
+	backend.RegisterChannel(&testsetup.Channels[1])
+	defer backend.DeleteMessages(testsetup.Channels[1].Name)
+
+	parent := testsetup.ProgrammingChannelMessages[0]
+	backend.StoreMessage(&parent)
+	for _, msg := range testsetup.ProgrammingChannelMessages[1:] {
+		msg.ParentId = parent.Id
+		backend.StoreMessage(&msg)
+	}
+
+	replies := backend.GetReplies(parent.Id)
+	assert.Equal(t, len(testsetup.ProgrammingChannelMessages)-1, len(replies))
+	assert.Equal(t, uint64(len(replies)), backend.GetMessage(parent.Id).ReplyCount)
+}
+
+func TestReactions(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteMessages()
+
+	msg := testsetup.GeneralMessages[0]
+	backend.StoreMessage(&msg)
+
+	assert.True(t, backend.AddReaction(msg.Id, "alice", "👍"))
+	assert.True(t, backend.AddReaction(msg.Id, "bob", "👍"))
+	assert.True(t, backend.AddReaction(msg.Id, "bob", "🎉"))
+	assert.False(t, backend.AddReaction(msg.Id, "alice", "👍"))
+	assert.Equal(t, map[string]uint64{"👍": 2, "🎉": 1}, backend.GetMessage(msg.Id).Reactions)
+
+	assert.True(t, backend.RemoveReaction(msg.Id, "bob", "🎉"))
+	assert.False(t, backend.RemoveReaction(msg.Id, "bob", "🎉"))
+	assert.Equal(t, map[string]uint64{"👍": 2}, backend.GetMessage(msg.Id).Reactions)
+}
+
+func TestOfflineQueue(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearParticipants(backend, t)
+	defer clearParticipants(backend, t)
+	defer backend.DeleteMessages()
+
+	username := testsetup.Participants[0].Username
+	backend.RegisterParticipant(&testsetup.Participants[0])
+	defer backend.DeleteQueuedMessages(username)
+
+	var ids []string
+	for _, contents := range []string{"first", "second", "third"} {
+		msg := types.BuildChatMsg([]byte(contents), "alice")
+		backend.StoreMessage(msg)
+		backend.EnqueueMessage(username, msg.Id, 2)
+		ids = append(ids, msg.Id)
+	}
+
+	queued := backend.GetQueuedMessages(username, 0)
+	assert.Equal(t, 2, len(queued))
+	assert.Equal(t, ids[1], queued[0].Id)
+	assert.Equal(t, ids[2], queued[1].Id)
+
+	// Messages are queued only once
+	backend.EnqueueMessage(username, ids[1], 2)
+	queued = backend.GetQueuedMessages(username, 0)
+	assert.Equal(t, 2, len(queued))
+	assert.Equal(t, ids[2], queued[0].Id)
+	assert.Equal(t, ids[1], queued[1].Id)
+
+	backend.DeleteQueuedMessages(username)
+	assert.Equal(t, 0, len(backend.GetQueuedMessages(username, 0)))
+
+	backend.EnqueueMessage("nonexistent", ids[0], 2)
+	assert.Equal(t, 0, len(backend.GetQueuedMessages("nonexistent", 0)))
+}
+
+func TestSearchMessages(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteMessages()
+
+	first := types.BuildChatMsg([]byte("Go channels are great, channels everywhere"), "alice")
+	second := types.BuildChatMsg([]byte("Channels in Go are typed"), "bob")
+	backend.StoreMessage(first)
+	backend.StoreMessage(second)
+
+	messages, total := backend.SearchMessages(&types.SearchQuery{Terms: []string{"go", "channels"}})
+	assert.Equal(t, 2, total)
+	assert.Equal(t, first.Id, messages[0].Id)
+	assert.False(t, messages[0].Timestamp.IsZero())
+
+	backend.EditMessage(first.Id, bytes.NewBufferString("Edited"), "")
+	_, total = backend.SearchMessages(&types.SearchQuery{Terms: []string{"channels"}, Sender: "alice"})
+	assert.Equal(t, 0, total)
+}
+
+func TestReadMarkers(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	username := testsetup.Participants[0].Username
+	defer backend.client.Del(backend.ctx, markersKey(username))
+
+	backend.SetReadMarker(username, "", 3)
+	backend.SetReadMarker(username, testsetup.Channels[0].Name, 5)
+	backend.SetReadMarker(username, "", 2)
+	assert.Equal(t, map[string]uint64{"": 3, testsetup.Channels[0].Name: 5}, backend.GetReadMarkers(username))
+}
+
+func TestPurgeMessages(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteMessages()
+
+	var ids []string
+	for _, contents := range []string{"first", "second", "third"} {
+		msg := types.BuildChatMsg([]byte(contents), "alice")
+		backend.StoreMessage(msg)
+		ids = append(ids, msg.Id)
+	}
+
+	assert.Equal(t, 1, backend.TrimMessages("", 2))
+	assert.True(t, backend.GetMessage(ids[0]) == nil)
+	assert.Equal(t, 2, backend.DeleteMessagesBefore("", time.Now()))
+	assert.Equal(t, 0, len(backend.GetChatHistory()))
+}
+
+func TestPins(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearChannels(backend, t)
+	defer clearChannels(backend, t)
+	backend.RegisterChannel(&testsetup.Channels[0])
+	channelname := testsetup.Channels[0].Name
+	defer backend.DeleteMessages(channelname)
+
+	msg := types.BuildChatMsg([]byte("first"), "alice", channelname)
+	backend.StoreMessage(msg)
+
+	assert.True(t, backend.PinMessage(channelname, msg.Id))
+	assert.False(t, backend.PinMessage(channelname, msg.Id))
+	assert.Equal(t, []string{msg.Id}, backend.GetChannels()[0].Pins)
+
+	assert.True(t, backend.UnpinMessage(channelname, msg.Id))
+	assert.False(t, backend.UnpinMessage(channelname, msg.Id))
+}
+
+func TestUpdateChannel(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearChannels(backend, t)
+	defer clearChannels(backend, t)
+	backend.RegisterChannel(&testsetup.Channels[0])
+	oldName := testsetup.Channels[0].Name
+
+	msg := types.BuildChatMsg([]byte("first"), "alice", oldName)
+	backend.StoreMessage(msg)
+	backend.AddChannelMember(oldName, "alice")
+
+	assert.True(t, backend.UpdateChannel(oldName, &types.Channel{Name: "RenamedChannel", Desc: "New topic"}))
+	defer backend.DeleteChannel("RenamedChannel")
+	defer backend.DeleteMessages("RenamedChannel")
+
+	assert.False(t, backend.HasChannel(oldName))
+	history := backend.GetChatHistory("RenamedChannel")
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, "RenamedChannel", history[0].Channel)
+
+	channels := backend.GetChannels()
+	assert.Equal(t, 1, len(channels))
+	assert.Equal(t, "New topic", channels[0].Desc)
+	assert.Equal(t, []string{"alice"}, channels[0].Members)
+}
+
+func TestDeleteChannel(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearChannels(backend, t)
+	defer clearChannels(backend, t)
+	clearParticipants(backend, t)
+	defer clearParticipants(backend, t)
+	backend.RegisterChannel(&testsetup.Channels[0])
+	backend.RegisterParticipant(&testsetup.Participants[0])
+	channelname := testsetup.Channels[0].Name
+	username := testsetup.Participants[0].Username
+
+	msg := types.BuildChatMsg([]byte("first"), username, channelname)
+	backend.StoreMessage(msg)
+	backend.AddReaction(msg.Id, username, "👍")
+	backend.SetReadMarker(username, channelname, msg.Seq)
+
+	assert.True(t, backend.DeleteChannel(channelname))
+	assert.True(t, backend.GetMessage(msg.Id) == nil)
+	assert.True(t, backend.client.ZScore(backend.ctx, indexKey("first"), msg.Id).Err() != nil)
+	assert.Equal(t, map[string]uint64{}, backend.GetReadMarkers(username))
+	for _, key := range []string{"messages/" + channelname + ":", "sequence/" + channelname + ":", reactionsKey(msg.Id, "👍")} {
+		assert.Equal(t, int64(0), backend.client.Exists(backend.ctx, key).Val())
+	}
+}
+
+func TestAttachments(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	attachment := &types.Attachment{Id: "a1b2c3d4", Name: "notes.txt", Size: 5, Checksum: util.Sha256Checksum([]byte("notes")), Uploader: "alice"}
+	defer backend.DeleteAttachment(attachment.Id)
+
+	assert.True(t, backend.GetAttachment(attachment.Id) == nil)
+	backend.StoreAttachment(attachment)
+	assert.Equal(t, attachment, backend.GetAttachment(attachment.Id))
+
+	backend.DeleteAttachment(attachment.Id)
+	assert.True(t, backend.GetAttachment(attachment.Id) == nil)
+}
+
+func TestLoginAttempts(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteLoginAttempts("username:alice")
+
+	assert.True(t, backend.GetLoginAttempts("username:alice") == nil)
+
+	attempts := &types.LoginAttempts{Failures: 2, LastFailure: time.Now().UTC(), LockedUntil: time.Now().Add(time.Minute).UTC()}
+	backend.SetLoginAttempts("username:alice", attempts, time.Hour)
+	stored := backend.GetLoginAttempts("username:alice")
+	assert.Equal(t, attempts.Failures, stored.Failures)
+	assert.True(t, attempts.LastFailure.Equal(stored.LastFailure))
+	assert.True(t, attempts.LockedUntil.Equal(stored.LockedUntil))
+}
+
+func TestUpdatePassword(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearParticipants(backend, t)
+	defer clearParticipants(backend, t)
+	participant := testsetup.Participants[0]
+	backend.RegisterParticipant(&participant)
+
+	assert.True(t, backend.UpdatePassword(participant.Username, "NewPassword#1234"))
+	assert.False(t, backend.AuthParticipant(&participant))
+	assert.True(t, backend.AuthParticipant(&types.Participant{Username: participant.Username, Password: "NewPassword#1234"}))
+	assert.Equal(t, participant.Email, backend.GetParticipant(participant.Username).Email)
+	assert.True(t, backend.GetParticipant("nonexistent") == nil)
+}
+
+func TestMarkEmailVerified(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearParticipants(backend, t)
+	defer clearParticipants(backend, t)
+	participant := testsetup.Participants[0]
+	participant.Unverified = true
+	backend.RegisterParticipant(&participant)
+	assert.True(t, backend.GetParticipant(participant.Username).Unverified)
+
+	assert.True(t, backend.MarkEmailVerified(participant.Username))
+	assert.False(t, backend.GetParticipant(participant.Username).Unverified)
+	assert.False(t, backend.MarkEmailVerified("nonexistent"))
+}
+
+func TestDeleteParticipant(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearParticipants(backend, t)
+	clearChannels(backend, t)
+	defer clearChannels(backend, t)
+	participant := testsetup.Participants[0]
+	backend.RegisterParticipant(&participant)
+	backend.RegisterChannel(&testsetup.Channels[0])
+	backend.AddChannelMember(testsetup.Channels[0].Name, participant.Username)
+	backend.SetReadMarker(participant.Username, testsetup.Channels[0].Name, 1)
+	backend.SetTwoFactor(participant.Username, &types.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true})
+	backend.StoreAPIToken(&types.APIToken{Id: "a", Hash: "hash-a", Username: participant.Username, Created: time.Now()})
+
+	assert.True(t, backend.DeleteParticipant(participant.Username))
+	assert.False(t, backend.HasParticipant(participant.Username))
+	assert.Equal(t, 0, len(backend.GetChannels()[0].Members))
+	assert.Equal(t, 0, len(backend.GetReadMarkers(participant.Username)))
+	assert.True(t, backend.GetTwoFactor(participant.Username) == nil)
+	assert.True(t, backend.GetAPIToken("hash-a") == nil)
+	assert.False(t, backend.DeleteParticipant(participant.Username))
+}
+
+func TestSetMessageSender(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteMessages()
+	msg := testsetup.GeneralMessages[0]
+	backend.StoreMessage(&msg)
+
+	assert.True(t, backend.SetMessageSender(msg.Id, "[deleted]"))
+	assert.Equal(t, "[deleted]", backend.GetMessage(msg.Id).Sender)
+	assert.False(t, backend.SetMessageSender("nonexistent", "[deleted]"))
+}
+
+func TestUpdateProfile(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	clearParticipants(backend, t)
+	defer clearParticipants(backend, t)
+	participant := testsetup.Participants[0]
+	participant.Registered = time.Now().Add(-time.Hour)
+	backend.RegisterParticipant(&participant)
+
+	assert.True(t, backend.UpdateProfile(participant.Username, "Nick", "Sci-fi reader", "Europe/Berlin"))
+	lastSeen := time.Now()
+	assert.True(t, backend.SetLastSeen(participant.Username, lastSeen))
+
+	registered := backend.GetParticipant(participant.Username)
+	assert.Equal(t, "Nick", registered.DisplayName)
+	assert.Equal(t, "Sci-fi reader", registered.Bio)
+	assert.Equal(t, "Europe/Berlin", registered.Timezone)
+	assert.True(t, registered.Registered.Equal(participant.Registered))
+	assert.True(t, registered.LastSeen.Equal(lastSeen))
+
+	assert.False(t, backend.UpdateProfile("nonexistent", "", "", ""))
+	assert.False(t, backend.SetLastSeen("nonexistent", lastSeen))
+}
+
+func TestCanonicalNames(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteParticipant("MarkLutz")
+	defer backend.DeleteChannel("BooksChannel")
+	defer backend.DeleteMessages("BooksChannel")
+	participant := types.Participant{Username: "MarkLutz", Password: "Secret#12345", Email: "mark@gmail.com"}
+	backend.RegisterParticipant(&participant)
+	assert.True(t, backend.HasParticipant("marklutz"))
+	assert.True(t, backend.HasParticipant("Mark1utz"))
+	assert.Equal(t, "MarkLutz", backend.GetParticipant("MARKLUTZ").Username)
+	assert.True(t, backend.AuthParticipant(&types.Participant{Username: "marklutz", Password: "Secret#12345"}))
+	assert.Panics(t, func() {
+		backend.RegisterParticipant(&types.Participant{Username: "marklutz", Password: "Secret#12345"})
+	})
+
+	backend.RegisterChannel(&types.Channel{Name: "BooksChannel"})
+	assert.True(t, backend.HasChannel("bookschannel"))
+	msg := types.ChatMessage{Contents: bytes.NewBufferString("hello"), Sender: "MarkLutz", Channel: "BOOKSCHANNEL"}
+	backend.StoreMessage(&msg)
+	assert.Equal(t, "BooksChannel", backend.GetChatHistory("bookschannel")[0].Channel)
+
+	// Only the case of the name changes, the channel doesn't clash with itself
+	assert.True(t, backend.UpdateChannel("bookschannel", &types.Channel{Name: "Bookschannel"}))
+	assert.True(t, backend.HasChannel("BooksChannel"))
+	assert.Equal(t, "Bookschannel", backend.GetChannels()[0].Name)
+
+	assert.True(t, backend.DeleteParticipant("MARKLUTZ"))
+	assert.False(t, backend.HasParticipant("MarkLutz"))
+}
+
+func TestAPITokens(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteAPIToken("alice", "a")
+	defer backend.DeleteAPIToken("alice", "b")
+
+	now := time.Now().UTC()
+	backend.StoreAPIToken(&types.APIToken{Id: "b", Hash: "hash-b", Username: "alice", Scopes: "read", Created: now.Add(time.Second)})
+	backend.StoreAPIToken(&types.APIToken{Id: "a", Hash: "hash-a", Username: "alice", Scopes: "post", Created: now})
+
+	token := backend.GetAPIToken("hash-a")
+	assert.Equal(t, "alice", token.Username)
+	assert.Equal(t, "post", token.Scopes)
+	assert.True(t, token.Created.Equal(now))
+
+	tokens := backend.GetAPITokens("alice")
+	assert.Equal(t, 2, len(tokens))
+	assert.Equal(t, "a", tokens[0].Id)
+
+	assert.Equal(t, "hash-a", backend.DeleteAPIToken("alice", "a").Hash)
+	assert.True(t, backend.GetAPIToken("hash-a") == nil)
+	assert.True(t, backend.DeleteAPIToken("alice", "a") == nil)
+}
+
+func TestTwoFactor(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	defer backend.DeleteTwoFactor("alice")
+	assert.True(t, backend.GetTwoFactor("alice") == nil)
+
+	backend.SetTwoFactor("alice", &types.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true, LastStep: 42})
+	assert.Equal(t, types.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true, LastStep: 42}, *backend.GetTwoFactor("alice"))
+
+	backend.SetRecoveryCodes("alice", []string{"first", "second"})
+	assert.True(t, backend.RedeemRecoveryCode("alice", "first"))
+	assert.False(t, backend.RedeemRecoveryCode("alice", "first"))
+
+	backend.SetRecoveryCodes("alice", []string{"third"})
+	assert.False(t, backend.RedeemRecoveryCode("alice", "second"))
+
+	backend.DeleteTwoFactor("alice")
+	assert.True(t, backend.GetTwoFactor("alice") == nil)
+	assert.False(t, backend.RedeemRecoveryCode("alice", "third"))
+}
+
+func TestOneTimeCodes(t *testing.T) {
+	backend, err := NewRedisBackend(&redisConfig)
+	assert.True(t, err == nil)
+	backend.StoreOneTimeCode("reset:code", "alice", time.Hour)
+
+	username, ok := backend.RedeemOneTimeCode("reset:code")
+	assert.True(t, ok)
+	assert.Equal(t, "alice", username)
+
+	_, ok = backend.RedeemOneTimeCode("reset:code")
+	assert.False(t, ok)
+}
@@ -0,0 +1,79 @@
+package backend
+
+import (
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/types"
+)
+
+// A message which contains all the terms of a query,
+// the score is the total number of occurrences of the terms.
+type SearchHit struct {
+	Message *types.ChatMessage
+	Score   int
+}
+
+// Splits the text into lower case words, all the backends use it for building their indexes,
+// so the terms of a query have to be tokenized the same way.
+func Tokenize(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+// Returns the number of occurrences of each term in the text.
+func TermCounts(text string) map[string]int {
+	counts := make(map[string]int)
+	for _, term := range Tokenize(text) {
+		counts[term]++
+	}
+	return counts
+}
+
+// Filters the hits by the query's channels, sender (all compared by their canonical keys) and time, sorts them by score (the most recent first
+// if the scores are equal), and returns the requested page together with the total number of matches.
+func RankSearchHits(hits []SearchHit, query *types.SearchQuery) ([]*types.ChatMessage, int) {
+	matched := make([]SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		msg := hit.Message
+		if msg.Deleted ||
+			(query.Channel != "" && !canonical.Equal(msg.Channel, query.Channel)) ||
+			(query.Channels != nil && !containsChannel(query.Channels, msg.Channel)) ||
+			(query.Sender != "" && !canonical.Equal(msg.Sender, query.Sender)) ||
+			(!query.Since.IsZero() && msg.Timestamp.Before(query.Since)) {
+			continue
+		}
+		matched = append(matched, hit)
+	}
+
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Score != matched[j].Score {
+			return matched[i].Score > matched[j].Score
+		}
+		return matched[i].Message.Timestamp.After(matched[j].Message.Timestamp)
+	})
+
+	start := min(max(query.Offset, 0), len(matched))
+	end := len(matched)
+	if query.Limit > 0 {
+		end = min(start+query.Limit, len(matched))
+	}
+
+	page := make([]*types.ChatMessage, 0, end-start)
+	for _, hit := range matched[start:end] {
+		page = append(page, hit.Message)
+	}
+	return page, len(matched)
+}
+
+func containsChannel(channels []string, channel string) bool {
+	for _, c := range channels {
+		if canonical.Equal(c, channel) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+// Blob stores keep the contents of the files shared by participants,
+// while the files' metadata is stored in the backend.
+package blobstore
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+type BlobStore interface {
+	Put(id string, data []byte) error
+	Get(id string) ([]byte, error)
+	Delete(id string) error
+}
+
+// Stores every blob in a separate file named after its id.
+type localStore struct {
+	dir string
+}
+
+func NewLocalStore(dir string) (*localStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+	return &localStore{dir: dir}, nil
+}
+
+func (l *localStore) path(id string) string {
+	// The id is generated by the session, but make sure it cannot escape the directory.
+	return filepath.Join(l.dir, filepath.Base(id))
+}
+
+func (l *localStore) Put(id string, data []byte) error {
+	return os.WriteFile(l.path(id), data, 0o644)
+}
+
+func (l *localStore) Get(id string) ([]byte, error) {
+	data, err := os.ReadFile(l.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+func (l *localStore) Delete(id string) error {
+	err := os.Remove(l.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// Keeps the blobs in memory, used for local development and testing.
+type memoryStore struct {
+	blobs map[string][]byte
+	mu    sync.RWMutex
+}
+
+func NewMemoryStore() *memoryStore {
+	return &memoryStore{blobs: make(map[string][]byte)}
+}
+
+func (m *memoryStore) Put(id string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.blobs[id] = append([]byte(nil), data...)
+	return nil
+}
+
+func (m *memoryStore) Get(id string) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	data, exists := m.blobs[id]
+	if !exists {
+		return nil, ErrNotFound
+	}
+	return data, nil
+}
+
+func (m *memoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, exists := m.blobs[id]; !exists {
+		return ErrNotFound
+	}
+	delete(m.blobs, id)
+	return nil
+}
@@ -0,0 +1,30 @@
+package blobstore
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testStore(t *testing.T, store BlobStore) {
+	assert.Nil(t, store.Put("0a1b2c3d", []byte("contents")))
+
+	data, err := store.Get("0a1b2c3d")
+	assert.Nil(t, err)
+	assert.Equal(t, []byte("contents"), data)
+
+	assert.Nil(t, store.Delete("0a1b2c3d"))
+	_, err = store.Get("0a1b2c3d")
+	assert.Equal(t, ErrNotFound, err)
+	assert.Equal(t, ErrNotFound, store.Delete("0a1b2c3d"))
+}
+
+func TestLocalStore(t *testing.T) {
+	store, err := NewLocalStore(t.TempDir())
+	assert.Nil(t, err)
+	testStore(t, store)
+}
+
+func TestMemoryStore(t *testing.T) {
+	testStore(t, NewMemoryStore())
+}
@@ -0,0 +1,78 @@
+// Canonical forms of usernames and channel names, used to decide whether two names refer to the same
+// participant or channel. Names are compared by their keys: the NFKC normalized, case folded name,
+// with characters which look like latin letters replaced by them (UTS #39 confusables, limited to single characters).
+// Thus MarkLutz, marklutz, MARKLUTZ, Mark1utz and Mаrklutz with a cyrillic а share a key.
+// The names themselves are kept as registered, for display.
+package canonical
+
+import (
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/cases"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Characters which are easily mistaken for a latin letter, mapped to that letter.
+// Names are case folded before they're mapped, thus only lower case letters are listed.
+var confusables = map[rune]rune{
+	// Latin and digits
+	'1': 'l', '0': 'o', 'ı': 'i',
+
+	// Cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o', 'р': 'p', 'с': 'c', 'т': 't',
+	'у': 'y', 'х': 'x', 'ѕ': 's', 'і': 'i', 'ј': 'j', 'ԁ': 'd', 'ԛ': 'q', 'ԝ': 'w', 'ү': 'y', 'һ': 'h',
+
+	// Greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'ζ': 'z', 'η': 'n', 'ι': 'i', 'κ': 'k', 'μ': 'u', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+// Japanese names mix these scripts, they're treated as one.
+var japanese = []*unicode.RangeTable{unicode.Han, unicode.Hiragana, unicode.Katakana}
+
+// Returns the key two names have to share in order to refer to the same participant or channel.
+func Key(name string) string {
+	// Folding might produce characters which aren't normalized, thus the name is normalized again.
+	folded := norm.NFKC.String(cases.Fold().String(norm.NFKC.String(name)))
+	return strings.Map(func(c rune) rune {
+		if mapped, exists := confusables[c]; exists {
+			return mapped
+		}
+		return c
+	}, folded)
+}
+
+func Equal(a, b string) bool {
+	return Key(a) == Key(b)
+}
+
+// Reports whether all the letters of the name belong to the same script, for example all latin or all cyrillic.
+// Names mixing scripts are most likely attempts to impersonate someone.
+func SingleScript(name string) bool {
+	var script string
+	for _, c := range norm.NFKC.String(name) {
+		if !unicode.IsLetter(c) {
+			continue
+		}
+		current := scriptOf(c)
+		if script == "" {
+			script = current
+		} else if current != script {
+			return false
+		}
+	}
+	return true
+}
+
+func scriptOf(c rune) string {
+	if unicode.In(c, japanese...) {
+		return "Japanese"
+	}
+	for name, table := range unicode.Scripts {
+		if name != "Common" && name != "Inherited" && unicode.Is(table, c) {
+			return name
+		}
+	}
+	return "Common"
+}
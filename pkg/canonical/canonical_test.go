@@ -0,0 +1,36 @@
+package canonical
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKey(t *testing.T) {
+	assert.Equal(t, "marklutz", Key("MarkLutz"))
+	assert.True(t, Equal("MarkLutz", "marklutz"))
+	assert.True(t, Equal("MarkLutz", "MARKLUTZ"))
+
+	// Full width and other compatibility characters are normalized
+	assert.True(t, Equal("ＭａｒｋＬｕｔｚ", "marklutz"))
+	// Full case folding, not just lower case
+	assert.True(t, Equal("Straße_name", "STRASSE_NAME"))
+	// Composed and decomposed characters
+	assert.True(t, Equal("Ren\u00e9e_Smith", "Rene\u0301e_Smith"))
+
+	// Confusables
+	assert.True(t, Equal("Mark1utz", "marklutz"))
+	assert.True(t, Equal("Mаrklutz", "marklutz"))
+	assert.True(t, Equal("СОРЕ", "cope"))
+
+	assert.False(t, Equal("marklutz", "markluts"))
+	assert.False(t, Equal("Владимир", "Vladimir"))
+}
+
+func TestSingleScript(t *testing.T) {
+	assert.True(t, SingleScript("MarkLutz_1999"))
+	assert.True(t, SingleScript("Владимир"))
+	assert.True(t, SingleScript("やまだ太郎"))
+	assert.False(t, SingleScript("MаrkLutz"))
+	assert.False(t, SingleScript("ΑlexanderΒ"))
+}
@@ -7,10 +7,13 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"strconv"
 	"time"
 
 	"github.com/isnastish/chat/pkg/logger"
@@ -18,10 +21,26 @@ import (
 	"github.com/isnastish/chat/pkg/utilities"
 )
 
+const (
+	// Typing events are sent at most once per interval while the participant is typing.
+	typingInterval = 3 * time.Second
+	// Typing indicator of a participant is displayed again if no events were received for that long.
+	typingDisplayTimeout = 10 * time.Second
+)
+
 type Config struct {
 	Network      string
 	Addr         string
 	RetriesCount int
+	// Files larger than that are not uploaded.
+	MaxUploadSize int
+	// Directory where downloaded files are saved.
+	DownloadDir string
+	// API token used for logging in instead of going through the menu.
+	Token string
+	// Connect over TLS, the session's certificate is verified against CAFile if set, or the system's roots otherwise.
+	TLS    bool
+	CAFile string
 }
 
 type client struct {
@@ -30,8 +49,33 @@ type client struct {
 	quitChan         chan struct{}
 	incomingMessages chan *types.ChatMessage
 	outgoingMessages chan *types.ChatMessage
+	connectionLost   chan struct{}
 	ctx              context.Context
 	cancel           context.CancelFunc
+
+	// An incomplete control frame left from the previous read.
+	pendingFrame []byte
+	// Issued by the session once the participant is authenticated.
+	resumeToken string
+	// Last received sequence number in each channel.
+	sequences map[string]uint64
+	// Set when the session closed the connection on purpose, so we shouldn't reconnect.
+	closedBySession bool
+	// The output is suppressed while the session is being resumed,
+	// otherwise the menu sent on connecting would be displayed.
+	resuming bool
+	// A file being uploaded, nil if there is no upload in progress.
+	upload *upload
+	// Files being downloaded by their ids.
+	downloads map[string]*download
+	// Nil unless the terminal is in character mode.
+	editor *lineEditor
+	// Signaled by the input goroutine when the participant is typing a message.
+	typing chan struct{}
+	// When a typing event was last sent, only accessed by the input goroutine.
+	lastTyping time.Time
+	// When a typing indicator of each participant was last displayed.
+	typists map[string]time.Time
 }
 
 func CreateClient(config *Config) *client {
@@ -41,14 +85,38 @@ func CreateClient(config *Config) *client {
 		quitChan:         make(chan struct{}),
 		incomingMessages: make(chan *types.ChatMessage),
 		outgoingMessages: make(chan *types.ChatMessage),
+		connectionLost:   make(chan struct{}),
+		sequences:        make(map[string]uint64),
+		downloads:        make(map[string]*download),
+		typing:           make(chan struct{}, 1),
+		typists:          make(map[string]time.Time),
 		ctx:              ctx,
 		cancel:           cancle,
 	}
 }
 
+func (c *client) dial() (net.Conn, error) {
+	if !c.config.TLS {
+		return net.Dial(c.config.Network, c.config.Addr)
+	}
+
+	tlsConfig := &tls.Config{MinVersion: tls.VersionTLS12}
+	if c.config.CAFile != "" {
+		pem, err := os.ReadFile(c.config.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.config.CAFile)
+		}
+	}
+	return tls.Dial(c.config.Network, c.config.Addr, tlsConfig)
+}
+
 func (c *client) tryConnect(delay time.Duration) (net.Conn, bool) {
 	for retries := 0; ; retries++ {
-		sessionConn, err := net.Dial(c.config.Network, c.config.Addr)
+		sessionConn, err := c.dial()
 		if err == nil {
 			log.Logger.Info("Connected to %s", sessionConn.RemoteAddr().String())
 			return sessionConn, true
@@ -71,18 +139,42 @@ func (c *client) Run() {
 	}
 	c.remoteConn = conn
 
-	defer c.remoteConn.Close()
+	// The connection is replaced every time we reconnect.
+	defer func() { c.remoteConn.Close() }()
+
+	if restore, ok := enableCharacterMode(int(os.Stdin.Fd())); ok {
+		defer restore()
+		c.editor = newLineEditor(os.Stdout)
+	}
+
+	c.loginWithToken()
 
-	go c.handleRemoteConnection()
+	go c.handleRemoteConnection(c.remoteConn)
 	go c.processInput()
 
 	for {
 		select {
 		case msg := <-c.incomingMessages:
-			fmt.Printf("%s", msg.Contents.String())
+			c.processIncoming(msg.Contents.Bytes())
 
 		case msg := <-c.outgoingMessages:
-			util.WriteBytes(c.remoteConn, msg.Contents)
+			if path, isUpload := parseUploadCommand(msg.Contents.String()); isUpload {
+				c.startUpload(path)
+			} else {
+				util.WriteBytes(c.remoteConn, msg.Contents)
+			}
+
+		case <-c.typing:
+			// Typing events only make sense once the participant is authenticated.
+			if c.resumeToken != "" {
+				util.WriteBytes(c.remoteConn, bytes.NewBufferString(types.BuildControlFrame(types.FrameTyping)))
+			}
+
+		case <-c.connectionLost:
+			if !c.reconnect() {
+				c.cancel()
+				return
+			}
 
 		case <-c.ctx.Done():
 			return
@@ -90,29 +182,198 @@ func (c *client) Run() {
 	}
 }
 
-func (c *client) handleRemoteConnection() {
+// Reconnects to the session after the connection has dropped,
+// and resumes the session if the participant has already been authenticated.
+// Returns false if the client should stop.
+func (c *client) reconnect() bool {
+	c.remoteConn.Close()
+
+	if c.closedBySession {
+		log.Logger.Info("Session closed the connection")
+		return false
+	}
+
+	log.Logger.Warn("Connection lost, reconnecting")
+
+	conn, succeeded := c.tryConnect(2 * time.Second)
+	if !succeeded {
+		log.Logger.Error("Failed to reconnect")
+		return false
+	}
+	c.remoteConn = conn
+	c.pendingFrame = nil
+	// Transfers can't be continued over a new connection.
+	if c.upload != nil {
+		c.print(fmt.Sprintf("Upload of %s interrupted\r\n", c.upload.name))
+		c.upload = nil
+	}
+	clear(c.downloads)
+
+	if c.resumeToken != "" {
+		args := []string{c.resumeToken}
+		for channel, seq := range c.sequences {
+			args = append(args, channel+":"+strconv.FormatUint(seq, 10))
+		}
+		util.WriteBytes(c.remoteConn, bytes.NewBufferString(types.BuildControlFrame(types.FrameResume, args...)))
+
+		// Every token can only be used once, the session issues a new one if resuming succeeded.
+		c.resumeToken = ""
+		c.resuming = true
+	} else {
+		c.loginWithToken()
+	}
+
+	go c.handleRemoteConnection(c.remoteConn)
+	return true
+}
+
+func (c *client) loginWithToken() {
+	if c.config.Token != "" {
+		util.WriteBytes(c.remoteConn, bytes.NewBufferString(types.BuildControlFrame(types.FrameLogin, c.config.Token)))
+	}
+}
+
+func (c *client) processIncoming(data []byte) {
+	var chunks []string
+	chunks, c.pendingFrame = splitControlFrames(append(c.pendingFrame, data...))
+
+	for _, chunk := range chunks {
+		if name, args, isFrame := types.ParseControlFrame(chunk); isFrame {
+			c.processFrame(name, args)
+			continue
+		}
+		if !c.resuming {
+			c.print(chunk)
+		}
+	}
+}
+
+// Prints the text above the line being typed if the terminal is in character mode.
+func (c *client) print(text string) {
+	if c.editor != nil {
+		c.editor.write(text)
+		return
+	}
+	fmt.Print(text)
+}
+
+func (c *client) processFrame(name string, args []string) {
+	switch name {
+	case types.FrameResumeToken:
+		if len(args) > 0 {
+			c.resumeToken = args[0]
+		}
+		c.resuming = false
+
+	case types.FrameResumeRejected:
+		c.resuming = false
+
+	case types.FrameSequence:
+		if len(args) == 2 {
+			if seq, err := strconv.ParseUint(args[1], 10, 64); err == nil {
+				c.sequences[args[0]] = seq
+			}
+		}
+
+	case types.FrameChannelRenamed:
+		if len(args) == 2 {
+			if seq, exists := c.sequences[args[0]]; exists {
+				c.sequences[args[1]] = seq
+				delete(c.sequences, args[0])
+			}
+		}
+
+	case types.FrameUploadReady, types.FrameUploadAck, types.FrameUploadDone, types.FrameUploadRejected:
+		c.processUploadFrame(name, args)
+
+	case types.FrameDownloadStart, types.FrameDownloadChunk, types.FrameDownloadEnd:
+		c.processDownloadFrame(name, args)
+
+	case types.FrameTyping:
+		if len(args) == 1 && !c.resuming {
+			c.showTyping(args[0])
+		}
+
+	case types.FrameClose:
+		c.closedBySession = true
+	}
+}
+
+// Splits the data received from the session into chunks of text to be displayed and control frames,
+// preserving their order. An incomplete control frame at the end of the data is returned as a remainder,
+// so it can be completed by the next read.
+func splitControlFrames(data []byte) ([]string, []byte) {
+	var chunks []string
+	prefix := []byte(types.ControlFramePrefix)
+
+	for len(data) > 0 {
+		start := bytes.Index(data, prefix)
+		if start < 0 {
+			chunks = append(chunks, string(data))
+			return chunks, nil
+		}
+		if start > 0 {
+			chunks = append(chunks, string(data[:start]))
+		}
+
+		end := bytes.Index(data[start:], []byte("\r\n"))
+		if end < 0 {
+			return chunks, bytes.Clone(data[start:])
+		}
+		chunks = append(chunks, string(data[start:start+end]))
+		data = data[start+end+2:]
+	}
+	return chunks, nil
+}
+
+func (c *client) handleRemoteConnection(conn net.Conn) {
 	for {
 		tmpBuf := make([]byte, 1024)
-		bytesRead, err := c.remoteConn.Read(tmpBuf)
+		bytesRead, err := conn.Read(tmpBuf)
 		buffer := bytes.NewBuffer(tmpBuf[:bytesRead] /*util.TrimWhitespaces(tmpBuf[:bytesRead])*/)
 
 		if err != nil && err != io.EOF {
 			log.Logger.Error("Failed to read from a remote connection %v", err)
-			c.cancel()
+			c.notifyConnectionLost()
 			return
 		}
 
 		if bytesRead == 0 { // io.EOF
 			log.Logger.Error("Remote closed the connection")
-			c.cancel()
+			c.notifyConnectionLost()
+			return
+		}
+
+		select {
+		case c.incomingMessages <- types.BuildChatMsg(buffer.Bytes(), "none"):
+		case <-c.ctx.Done():
 			return
 		}
+	}
+}
 
-		c.incomingMessages <- types.BuildChatMsg(buffer.Bytes(), "none")
+func (c *client) notifyConnectionLost() {
+	select {
+	case c.connectionLost <- struct{}{}:
+	case <-c.ctx.Done():
 	}
 }
 
+// The indicator is displayed once while the participant keeps typing.
+func (c *client) showTyping(username string) {
+	now := time.Now()
+	if last, exists := c.typists[username]; !exists || now.Sub(last) > typingDisplayTimeout {
+		c.print(fmt.Sprintf("%s is typing...\r\n", username))
+	}
+	c.typists[username] = now
+}
+
 func (c *client) processInput() {
+	if c.editor != nil {
+		c.processKeys()
+		return
+	}
+
 	reader := bufio.NewReader(os.Stdin)
 	for {
 		select {
@@ -133,3 +394,42 @@ func (c *client) processInput() {
 		}
 	}
 }
+
+// Reads the input key by key when the terminal is in character mode,
+// and notifies the session when the participant is typing a message.
+func (c *client) processKeys() {
+	tmpBuf := make([]byte, 256)
+	for {
+		bytesRead, err := os.Stdin.Read(tmpBuf)
+		if err != nil {
+			log.Logger.Error("Failed to read the input %v", err)
+			return
+		}
+
+		for _, b := range tmpBuf[:bytesRead] {
+			result, line := c.editor.key(b)
+			switch result {
+			case keyTyped:
+				if !c.editor.typingCommand() && time.Since(c.lastTyping) >= typingInterval {
+					c.lastTyping = time.Now()
+					select {
+					case c.typing <- struct{}{}:
+					default:
+					}
+				}
+
+			case keySubmitted:
+				c.lastTyping = time.Time{}
+				select {
+				case c.outgoingMessages <- types.BuildChatMsg(util.TrimWhitespaces([]byte(line)), "none"):
+				case <-c.ctx.Done():
+					return
+				}
+
+			case keyInterrupted:
+				c.cancel()
+				return
+			}
+		}
+	}
+}
@@ -5,15 +5,20 @@ import (
 	"bufio"
 	"bytes"
 	"context"
+	"encoding/base64"
 	"fmt"
 	"io"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
 	"go.uber.org/goleak"
 
+	"github.com/isnastish/chat/pkg/types"
 	"github.com/isnastish/chat/pkg/utilities"
 )
 
@@ -105,3 +110,58 @@ func TestClosingConnectionOnOppositeSideCausesZeroBytesRead(t *testing.T) {
 		break
 	}
 }
+
+func TestSplitControlFrames(t *testing.T) {
+	frame := types.BuildControlFrame(types.FrameSequence, "BooksChannel", "12")
+	chunks, remainder := splitControlFrames([]byte("enter option: " + frame + "{MarkLutz:12:00:00} Hi\r\n"))
+	assert.Equal(t, []string{"enter option: ", strings.TrimSuffix(frame, "\r\n"), "{MarkLutz:12:00:00} Hi\r\n"}, chunks)
+	assert.Equal(t, 0, len(remainder))
+
+	name, args, isFrame := types.ParseControlFrame(chunks[1])
+	assert.True(t, isFrame)
+	assert.Equal(t, types.FrameSequence, name)
+	assert.Equal(t, []string{"BooksChannel", "12"}, args)
+
+	// A frame split across two reads
+	chunks, remainder = splitControlFrames([]byte("text" + frame[:5]))
+	assert.Equal(t, []string{"text"}, chunks)
+	chunks, remainder = splitControlFrames(append(remainder, []byte(frame[5:])...))
+	assert.Equal(t, []string{strings.TrimSuffix(frame, "\r\n")}, chunks)
+	assert.Equal(t, 0, len(remainder))
+}
+
+func TestParseUploadCommand(t *testing.T) {
+	path, isUpload := parseUploadCommand(":upload ./notes.txt")
+	assert.True(t, isUpload)
+	assert.Equal(t, "./notes.txt", path)
+
+	_, isUpload = parseUploadCommand(":upload")
+	assert.False(t, isUpload)
+	_, isUpload = parseUploadCommand("uploading a file")
+	assert.False(t, isUpload)
+}
+
+func TestDownloadFile(t *testing.T) {
+	client := CreateClient(&Config{DownloadDir: t.TempDir()})
+	data := bytes.Repeat([]byte("chunked file "), 200)
+	checksum := util.Sha256Checksum(data)
+
+	var frames strings.Builder
+	frames.WriteString(types.BuildControlFrame(types.FrameDownloadStart, "a1b2c3d4", "my+notes.txt", fmt.Sprint(len(data)), checksum))
+	for offset := 0; offset < len(data); offset += 1000 {
+		chunk := data[offset:min(offset+1000, len(data))]
+		frames.WriteString(types.BuildControlFrame(types.FrameDownloadChunk, "a1b2c3d4", base64.StdEncoding.EncodeToString(chunk)))
+	}
+	frames.WriteString(types.BuildControlFrame(types.FrameDownloadEnd, "a1b2c3d4"))
+
+	// Frames are split across reads
+	stream := []byte(frames.String())
+	for offset := 0; offset < len(stream); offset += 1024 {
+		client.processIncoming(stream[offset:min(offset+1024, len(stream))])
+	}
+
+	saved, err := os.ReadFile(filepath.Join(client.config.DownloadDir, "my notes.txt"))
+	assert.True(t, err == nil)
+	assert.Equal(t, data, saved)
+	assert.Equal(t, 0, len(client.downloads))
+}
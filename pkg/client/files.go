@@ -0,0 +1,171 @@
+package client
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// A file being sent to the session, the next chunk is sent once the previous one is acknowledged.
+type upload struct {
+	id     string
+	name   string
+	data   []byte
+	offset int
+}
+
+// A file being received from the session.
+type download struct {
+	name     string
+	size     uint64
+	checksum string
+	data     bytes.Buffer
+}
+
+// Returns the path if the input is an :upload command, which is handled by the client itself.
+func parseUploadCommand(input string) (string, bool) {
+	fields := strings.Fields(input)
+	if len(fields) != 2 || strings.ToLower(fields[0]) != ":upload" {
+		return "", false
+	}
+	return fields[1], true
+}
+
+func (c *client) startUpload(path string) {
+	if c.upload != nil {
+		c.print(fmt.Sprintf("Upload of %s is in progress\r\n", c.upload.name))
+		return
+	}
+
+	info, err := os.Stat(path)
+	if err != nil || !info.Mode().IsRegular() {
+		c.print(fmt.Sprintf("Cannot upload %s: not a regular file\r\n", path))
+		return
+	}
+	if info.Size() > int64(c.config.MaxUploadSize) {
+		c.print(fmt.Sprintf("Cannot upload %s: file exceeds the maximum size of %d bytes\r\n", path, c.config.MaxUploadSize))
+		return
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		c.print(fmt.Sprintf("Cannot upload %s: %v\r\n", path, err))
+		return
+	}
+
+	c.upload = &upload{name: filepath.Base(path), data: data}
+	util.WriteBytes(c.remoteConn, bytes.NewBufferString(types.BuildControlFrame(
+		types.FrameUploadStart, url.QueryEscape(c.upload.name), strconv.Itoa(len(data)), util.Sha256Checksum(data),
+	)))
+}
+
+// Sends the next chunk of the file, or finishes the upload if all the data has been sent.
+func (c *client) sendNextChunk() {
+	if c.upload.offset >= len(c.upload.data) {
+		util.WriteBytes(c.remoteConn, bytes.NewBufferString(types.BuildControlFrame(types.FrameUploadEnd, c.upload.id)))
+		return
+	}
+
+	end := min(c.upload.offset+types.UploadChunkSize, len(c.upload.data))
+	chunk := base64.StdEncoding.EncodeToString(c.upload.data[c.upload.offset:end])
+	c.upload.offset = end
+	util.WriteBytes(c.remoteConn, bytes.NewBufferString(types.BuildControlFrame(types.FrameUploadChunk, c.upload.id, chunk)))
+}
+
+func (c *client) processUploadFrame(name string, args []string) {
+	if c.upload == nil {
+		return
+	}
+
+	switch name {
+	case types.FrameUploadReady:
+		if len(args) == 1 {
+			c.upload.id = args[0]
+			c.sendNextChunk()
+		}
+
+	case types.FrameUploadAck:
+		if len(args) == 2 && args[0] == c.upload.id {
+			c.sendNextChunk()
+		}
+
+	case types.FrameUploadDone, types.FrameUploadRejected:
+		// The session describes the outcome in a message following the frame.
+		c.upload = nil
+	}
+}
+
+func (c *client) processDownloadFrame(name string, args []string) {
+	if len(args) == 0 {
+		return
+	}
+	id := args[0]
+
+	switch name {
+	case types.FrameDownloadStart:
+		if len(args) != 4 {
+			return
+		}
+		fileName, nameErr := url.QueryUnescape(args[1])
+		size, sizeErr := strconv.ParseUint(args[2], 10, 64)
+		if nameErr != nil || sizeErr != nil {
+			return
+		}
+		c.downloads[id] = &download{name: filepath.Base(fileName), size: size, checksum: args[3]}
+
+	case types.FrameDownloadChunk:
+		file, exists := c.downloads[id]
+		if !exists || len(args) != 2 {
+			return
+		}
+		chunk, err := base64.StdEncoding.DecodeString(args[1])
+		if err != nil {
+			c.print(fmt.Sprintf("Download of %s failed: malformed chunk\r\n", file.name))
+			delete(c.downloads, id)
+			return
+		}
+		file.data.Write(chunk)
+
+	case types.FrameDownloadEnd:
+		file, exists := c.downloads[id]
+		if !exists {
+			return
+		}
+		delete(c.downloads, id)
+
+		path, err := c.saveDownload(id, file)
+		if err != nil {
+			c.print(fmt.Sprintf("Download of %s failed: %v\r\n", file.name, err))
+			return
+		}
+		c.print(fmt.Sprintf("Downloaded %s to %s\r\n", file.name, path))
+	}
+}
+
+// Verifies the downloaded file and writes it into the download directory.
+// The file's id is prepended to its name if a file with the same name already exists.
+func (c *client) saveDownload(id string, file *download) (string, error) {
+	if uint64(file.data.Len()) != file.size {
+		return "", fmt.Errorf("expected %d bytes, received %d", file.size, file.data.Len())
+	}
+	if util.Sha256Checksum(file.data.Bytes()) != file.checksum {
+		return "", fmt.Errorf("checksum mismatch")
+	}
+
+	path := filepath.Join(c.config.DownloadDir, file.name)
+	if _, err := os.Stat(path); err == nil {
+		path = filepath.Join(c.config.DownloadDir, id+"-"+file.name)
+	}
+	if err := os.WriteFile(path, file.data.Bytes(), 0644); err != nil {
+		return "", err
+	}
+	return path, nil
+}
@@ -0,0 +1,113 @@
+package client
+
+import (
+	"io"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+type keyResult int8
+
+const (
+	keyNone keyResult = iota
+	// A character was added to the line.
+	keyTyped
+	// The line was submitted with Enter.
+	keySubmitted
+	// Ctrl-C was pressed.
+	keyInterrupted
+)
+
+// Minimal line editor used when the terminal is in character mode.
+// It echoes the input itself, so the output received from the session
+// can be printed above the line being typed without interrupting it.
+type lineEditor struct {
+	out   io.Writer
+	input []byte
+	// Output printed after the last newline, for example the username prompt.
+	// It's displayed in front of the input.
+	prompt string
+	// Set while skipping an escape sequence, for example produced by arrow keys.
+	escape int
+	mu     sync.Mutex
+}
+
+func newLineEditor(out io.Writer) *lineEditor {
+	return &lineEditor{out: out}
+}
+
+// Prints the output received from the session and redraws the line being typed.
+func (e *lineEditor) write(text string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	output := e.prompt + text
+	if newline := strings.LastIndex(output, "\n"); newline >= 0 {
+		e.prompt = output[newline+1:]
+	} else {
+		e.prompt = output
+	}
+
+	io.WriteString(e.out, "\r\x1b[K"+output+string(e.input))
+}
+
+// Processes a single byte of the input, the line is returned once it's submitted.
+func (e *lineEditor) key(b byte) (keyResult, string) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.escape == 1 {
+		e.escape = 0
+		if b == '[' {
+			e.escape = 2
+		}
+		return keyNone, ""
+	}
+	if e.escape == 2 {
+		// A control sequence ends with a byte in the range 0x40-0x7e.
+		if b >= 0x40 && b <= 0x7e {
+			e.escape = 0
+		}
+		return keyNone, ""
+	}
+
+	switch {
+	case b == '\n' || b == '\r':
+		line := string(e.input)
+		e.input = nil
+		e.prompt = ""
+		io.WriteString(e.out, "\r\n")
+		return keySubmitted, line
+
+	case b == 0x7f || b == '\b':
+		if len(e.input) > 0 {
+			_, size := utf8.DecodeLastRune(e.input)
+			e.input = e.input[:len(e.input)-size]
+			io.WriteString(e.out, "\b \b")
+		}
+
+	case b == 0x03: // Ctrl-C
+		return keyInterrupted, ""
+
+	case b == 0x15: // Ctrl-U
+		e.input = nil
+		io.WriteString(e.out, "\r\x1b[K"+e.prompt)
+
+	case b == 0x1b:
+		e.escape = 1
+
+	case b >= 0x20 || b == '\t':
+		e.input = append(e.input, b)
+		e.out.Write([]byte{b})
+		return keyTyped, ""
+	}
+	return keyNone, ""
+}
+
+// Returns true if a command is being typed, other participants aren't notified about those.
+func (e *lineEditor) typingCommand() bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return strings.HasPrefix(string(e.input), ":")
+}
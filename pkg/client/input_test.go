@@ -0,0 +1,32 @@
+package client
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineEditor(t *testing.T) {
+	var out bytes.Buffer
+	editor := newLineEditor(&out)
+
+	for _, b := range []byte("helo\x7flo\x1b[D") {
+		editor.key(b)
+	}
+	assert.Equal(t, "hello", string(editor.input))
+
+	// The output is printed above the line being typed, which is redrawn afterwards
+	out.Reset()
+	editor.write("{server} enter username: ")
+	assert.Equal(t, "\r\x1b[K{server} enter username: hello", out.String())
+	assert.Equal(t, "{server} enter username: ", editor.prompt)
+
+	result, line := editor.key('\n')
+	assert.Equal(t, keySubmitted, result)
+	assert.Equal(t, "hello", line)
+	assert.Equal(t, "", editor.prompt)
+
+	result, _ = editor.key(0x03)
+	assert.Equal(t, keyInterrupted, result)
+}
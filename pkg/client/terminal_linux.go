@@ -0,0 +1,27 @@
+//go:build linux
+
+package client
+
+import (
+	"golang.org/x/sys/unix"
+)
+
+// Switches the terminal into non-canonical mode without echo, so the input can be read key by key.
+// Signals are disabled as well, Ctrl-C is handled by the line editor in order to restore the terminal.
+// Returns false if the file descriptor doesn't refer to a terminal.
+func enableCharacterMode(fd int) (func(), bool) {
+	termios, err := unix.IoctlGetTermios(fd, unix.TCGETS)
+	if err != nil {
+		return nil, false
+	}
+
+	original := *termios
+	termios.Lflag &^= unix.ICANON | unix.ECHO | unix.ISIG
+	termios.Cc[unix.VMIN] = 1
+	termios.Cc[unix.VTIME] = 0
+	if err := unix.IoctlSetTermios(fd, unix.TCSETS, termios); err != nil {
+		return nil, false
+	}
+
+	return func() { unix.IoctlSetTermios(fd, unix.TCSETS, &original) }, true
+}
@@ -0,0 +1,9 @@
+//go:build !linux
+
+package client
+
+// Character mode is only supported on linux, the input is read line by line elsewhere,
+// so typing events are not sent.
+func enableCharacterMode(fd int) (func(), bool) {
+	return nil, false
+}
@@ -5,6 +5,7 @@ import (
 	"bytes"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/isnastish/chat/pkg/logger"
 	"github.com/isnastish/chat/pkg/utilities"
@@ -19,11 +20,39 @@ const (
 	CommandListMembers
 	CommandListChannels
 	CommandListCommands
+	CommandEditMessage
+	CommandDeleteMessage
+	CommandReplyMessage
+	CommandDisplayThread
+	CommandReactMessage
+	CommandUnreactMessage
+	CommandSearchMessages
+	CommandMarkRead
+	CommandPinMessage
+	CommandUnpinMessage
+	CommandListPins
+	CommandSetTopic
+	CommandRenameChannel
+	CommandUploadFile
+	CommandDownloadFile
+	CommandSetStatus
+	CommandChangePassword
+	CommandVerifyEmail
+	CommandResendCode
+	CommandTwoFactor
+	CommandAPIToken
+	CommandDeleteAccount
+	CommandExportData
+	CommandSetProfile
+	CommandWhois
 
 	// This type should always be the last
 	commandSentinel
 )
 
+// Highest page of search results which can be requested, so the offset of the page cannot overflow.
+const MaxPage = 100000
+
 type errorType int8
 
 const (
@@ -46,6 +75,12 @@ type ParseResult struct {
 	CommandType
 	Channel string
 	Period  uint
+	From    string
+	Since   time.Time
+	Page    uint
+	// Positional arguments in the order they were declared.
+	// Optional arguments which weren't specified are omitted.
+	Args    []string
 	Error   *parseError
 	Matched bool
 }
@@ -56,10 +91,20 @@ type option struct {
 	hint string
 }
 
+// Positional arguments have to be specified before any options.
+type argument struct {
+	name string
+	// A variadic argument consumes all the words up to the first option,
+	// thus it should be the last one.
+	variadic bool
+	optional bool
+}
+
 type command struct {
 	_type   CommandType
 	name    string
 	desc    string
+	args    []*argument
 	options []*option
 }
 
@@ -81,6 +126,49 @@ func (c *command) addOption(name, arg, hint string) *command {
 	return c
 }
 
+func (c *command) addArgument(name string) *command {
+	c.args = append(c.args, &argument{name: name})
+	return c
+}
+
+func (c *command) addVariadicArgument(name string) *command {
+	c.args = append(c.args, &argument{name: name, variadic: true})
+	return c
+}
+
+func (c *command) addOptionalArgument(name string) *command {
+	c.args = append(c.args, &argument{name: name, optional: true})
+	return c
+}
+
+func (c *command) addOptionalVariadicArgument(name string) *command {
+	c.args = append(c.args, &argument{name: name, variadic: true, optional: true})
+	return c
+}
+
+func (c *command) isOption(name string) bool {
+	for _, opt := range c.options {
+		if opt.name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the command's name followed by its arguments, for example :edit <id> <text>.
+func (c *command) usage() string {
+	var builder strings.Builder
+	builder.WriteString(c.name)
+	for _, arg := range c.args {
+		if arg.optional {
+			builder.WriteString(util.Fmt(" [<%s>]", arg.name))
+		} else {
+			builder.WriteString(util.Fmt(" <%s>", arg.name))
+		}
+	}
+	return builder.String()
+}
+
 func index(cmd CommandType) int {
 	if cmd <= CommandNull || cmd >= commandSentinel {
 		log.Logger.Panic("Index out of range")
@@ -108,6 +196,93 @@ func init() {
 			addOption("-channel", "<name>", "Channel's name")
 	commandTable[index(CommandListChannels)] = newCommand(CommandListChannels, ":channels", "Display all channels")
 	commandTable[index(CommandListCommands)] = newCommand(CommandListCommands, ":commands", "Display commands")
+	commandTable[index(CommandEditMessage)] =
+		newCommand(CommandEditMessage, ":edit", "Edit a message").
+			addArgument("id").
+			addVariadicArgument("text")
+	commandTable[index(CommandDeleteMessage)] =
+		newCommand(CommandDeleteMessage, ":delete", "Delete a message").
+			addArgument("id")
+	commandTable[index(CommandReplyMessage)] =
+		newCommand(CommandReplyMessage, ":reply", "Reply to a message in a thread").
+			addArgument("id").
+			addVariadicArgument("text")
+	commandTable[index(CommandDisplayThread)] =
+		newCommand(CommandDisplayThread, ":thread", "Display a message with all its replies").
+			addArgument("id")
+	commandTable[index(CommandReactMessage)] =
+		newCommand(CommandReactMessage, ":react", "React to a message with an emoji").
+			addArgument("id").
+			addArgument("emoji")
+	commandTable[index(CommandUnreactMessage)] =
+		newCommand(CommandUnreactMessage, ":unreact", "Remove a reaction from a message").
+			addArgument("id").
+			addArgument("emoji")
+	commandTable[index(CommandSearchMessages)] =
+		newCommand(CommandSearchMessages, ":search", "Search messages").
+			addVariadicArgument("terms").
+			addOption("-channel", "<name>", "Channel's name").
+			addOption("-from", "<username>", "Sender's username").
+			addOption("-since", "<time>", "Duration (2h) or date (2006-01-02)").
+			addOption("-page", "<n>", "Page number")
+	commandTable[index(CommandMarkRead)] =
+		newCommand(CommandMarkRead, ":markread", "Mark all messages in the current channel as read").
+			addOption("-channel", "<name>", "Channel's name")
+	commandTable[index(CommandPinMessage)] =
+		newCommand(CommandPinMessage, ":pin", "Pin a message in its channel").
+			addArgument("id")
+	commandTable[index(CommandUnpinMessage)] =
+		newCommand(CommandUnpinMessage, ":unpin", "Unpin a message").
+			addArgument("id")
+	commandTable[index(CommandListPins)] = newCommand(CommandListPins, ":pins", "Display pinned messages in the current channel")
+	commandTable[index(CommandSetTopic)] =
+		newCommand(CommandSetTopic, ":topic", "Change the topic of the current channel").
+			addVariadicArgument("text")
+	commandTable[index(CommandRenameChannel)] =
+		newCommand(CommandRenameChannel, ":rename", "Rename the current channel").
+			addArgument("name")
+	commandTable[index(CommandUploadFile)] =
+		newCommand(CommandUploadFile, ":upload", "Share a file in the current channel").
+			addArgument("path")
+	commandTable[index(CommandDownloadFile)] =
+		newCommand(CommandDownloadFile, ":download", "Download a shared file").
+			addArgument("id")
+	commandTable[index(CommandSetStatus)] =
+		newCommand(CommandSetStatus, ":status", "Set presence (online, away or dnd) with an optional status text").
+			addArgument("state").
+			addOptionalVariadicArgument("text")
+	commandTable[index(CommandChangePassword)] =
+		newCommand(CommandChangePassword, ":passwd", "Change the password").
+			addArgument("current").
+			addArgument("new")
+	commandTable[index(CommandVerifyEmail)] =
+		newCommand(CommandVerifyEmail, ":verify", "Verify the email address with a code sent to it").
+			addArgument("code")
+	commandTable[index(CommandResendCode)] =
+		newCommand(CommandResendCode, ":resendcode", "Send a new email verification code")
+	commandTable[index(CommandTwoFactor)] =
+		newCommand(CommandTwoFactor, ":2fa", "Manage two-factor authentication (enable, confirm <code>, disable <password> or codes <password>)").
+			addArgument("action").
+			addOptionalArgument("value")
+	commandTable[index(CommandAPIToken)] =
+		newCommand(CommandAPIToken, ":token", "Manage API tokens (create <name> [<scopes>], list or revoke <id>)").
+			addArgument("action").
+			addOptionalArgument("name").
+			addOptionalVariadicArgument("scopes")
+	commandTable[index(CommandDeleteAccount)] =
+		newCommand(CommandDeleteAccount, ":deleteaccount", "Delete the account, messages are anonymized unless remove is specified").
+			addArgument("password").
+			addOptionalArgument("messages")
+	commandTable[index(CommandExportData)] =
+		newCommand(CommandExportData, ":exportdata", "Download the profile, memberships and messages as a json archive")
+	commandTable[index(CommandSetProfile)] =
+		newCommand(CommandSetProfile, ":profile", "Update the profile (set name, bio or timezone, an empty value clears it)").
+			addArgument("action").
+			addArgument("field").
+			addOptionalVariadicArgument("value")
+	commandTable[index(CommandWhois)] =
+		newCommand(CommandWhois, ":whois", "Display participant's profile").
+			addArgument("username")
 
 	errorsTable = make([]string, errorSentinel-errorSuccess)
 	errorsTable[errorSuccess] = "Success"
@@ -120,7 +295,7 @@ func init() {
 	CommandsBuilder.WriteString("commands:\n")
 
 	for _, cmd := range commandTable {
-		CommandsBuilder.WriteString(util.Fmtln("\t%-20s\t%s", cmd.name, cmd.desc))
+		CommandsBuilder.WriteString(util.Fmtln("\t%-20s\t%s", cmd.usage(), cmd.desc))
 		for _, opt := range cmd.options {
 			CommandsBuilder.WriteString(util.Fmtln("\t%-20s\t%s %s %s", "", opt.name, opt.arg, opt.hint))
 		}
@@ -135,8 +310,33 @@ func ParseCommand(buffer *bytes.Buffer) *ParseResult {
 		for _, cmd := range commandTable {
 			if strings.ToLower(arguments[0]) == cmd.name {
 				result.CommandType = cmd._type
+
+				first := 1
+				for _, arg := range cmd.args {
+					if first >= len(arguments) || cmd.isOption(arguments[first]) {
+						if arg.optional {
+							break
+						}
+						result.Error = &parseError{t: errorArgumentNotSpecified, msg: arg.name}
+						return result
+					}
+
+					if arg.variadic {
+						last := first
+						for last < len(arguments) && !cmd.isOption(arguments[last]) {
+							last++
+						}
+						result.Args = append(result.Args, strings.Join(arguments[first:last], " "))
+						first = last
+						continue
+					}
+
+					result.Args = append(result.Args, arguments[first])
+					first++
+				}
+
 				optionIndex := 0
-				for i := 1; i < len(arguments); i += 2 {
+				for i := first; i < len(arguments); i += 2 {
 					if optionIndex >= len(cmd.options) {
 						result.Error = &parseError{
 							t:   errorUnexpectedArgument,
@@ -168,6 +368,37 @@ func ParseCommand(buffer *bytes.Buffer) *ParseResult {
 								result.Period = uint(count)
 								result.Matched = true
 								break
+
+							} else if opt.name == "-from" {
+								result.From = arguments[i+1]
+								result.Matched = true
+								break
+
+							} else if opt.name == "-since" {
+								since, ok := parseSince(arguments[i+1])
+								if !ok {
+									result.Error = &parseError{
+										t:   errorInvalidValue,
+										msg: util.Fmt("since %s", arguments[i+1])}
+									return result
+								}
+
+								result.Since = since
+								result.Matched = true
+								break
+
+							} else if opt.name == "-page" {
+								page, err := strconv.Atoi(arguments[i+1])
+								if err != nil || page < 1 || page > MaxPage {
+									result.Error = &parseError{
+										t:   errorInvalidValue,
+										msg: util.Fmt("page %s", arguments[i+1])}
+									return result
+								}
+
+								result.Page = uint(page)
+								result.Matched = true
+								break
 							}
 						}
 					}
@@ -192,3 +423,17 @@ func ParseCommand(buffer *bytes.Buffer) *ParseResult {
 
 	return result
 }
+
+// Accepts either a duration relative to the current time, for example 90m or 2h,
+// or a date in 2006-01-02 format, optionally followed by the time 2006-01-02T15:04:05.
+func parseSince(value string) (time.Time, bool) {
+	if duration, err := time.ParseDuration(value); err == nil && duration >= 0 {
+		return time.Now().Add(-duration), true
+	}
+	for _, layout := range []string{time.DateOnly, "2006-01-02T15:04:05"} {
+		if since, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return since, true
+		}
+	}
+	return time.Time{}, false
+}
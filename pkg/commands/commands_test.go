@@ -48,6 +48,12 @@ func TestErrorParsingACommand(t *testing.T) {
 	commandParseError(t, ":history -period -234", errorInvalidValue) // debug
 	commandParseError(t, ":history -period string", errorInvalidValue)
 	commandParseError(t, ":members -channel", errorArgumentNotSpecified)
+	commandParseError(t, ":edit", errorArgumentNotSpecified)
+	commandParseError(t, ":edit 0a1b2c3d", errorArgumentNotSpecified)
+	commandParseError(t, ":delete", errorArgumentNotSpecified)
+	commandParseError(t, ":delete 0a1b2c3d unexpected", errorUnexpectedArgument)
+	commandParseError(t, ":reply 0a1b2c3d", errorArgumentNotSpecified)
+	commandParseError(t, ":thread", errorArgumentNotSpecified)
 	// nil on success
 	// commandParseError(t, ":members -channel Books", errorSuccess)
 }
@@ -84,3 +90,56 @@ func TestValidCommands(t *testing.T) {
 	assert.Equal(t, CommandListMembers, result.CommandType)
 	assert.Equal(t, "Books", result.Channel)
 }
+
+func TestPositionalArguments(t *testing.T) {
+	result := ParseCommand(str2bytes(":edit 0a1b2c3d The new contents -of a message"))
+	assert.True(t, result.Matched)
+	assert.Equal(t, CommandEditMessage, result.CommandType)
+	assert.Equal(t, []string{"0a1b2c3d", "The new contents -of a message"}, result.Args)
+
+	result = ParseCommand(str2bytes(":delete 0a1b2c3d"))
+	assert.True(t, result.Matched)
+	assert.Equal(t, CommandDeleteMessage, result.CommandType)
+	assert.Equal(t, []string{"0a1b2c3d"}, result.Args)
+
+	result = ParseCommand(str2bytes(":reply 0a1b2c3d Sounds good"))
+	assert.True(t, result.Matched)
+	assert.Equal(t, CommandReplyMessage, result.CommandType)
+	assert.Equal(t, []string{"0a1b2c3d", "Sounds good"}, result.Args)
+
+	result = ParseCommand(str2bytes(":react 0a1b2c3d 👍"))
+	assert.True(t, result.Matched)
+	assert.Equal(t, CommandReactMessage, result.CommandType)
+	assert.Equal(t, []string{"0a1b2c3d", "👍"}, result.Args)
+}
+
+func TestSearchCommand(t *testing.T) {
+	result := ParseCommand(str2bytes(":search go channels -from alice -page 2"))
+	assert.True(t, result.Matched)
+	assert.Equal(t, CommandSearchMessages, result.CommandType)
+	assert.Equal(t, []string{"go channels"}, result.Args)
+	assert.Equal(t, "alice", result.From)
+	assert.Equal(t, uint(2), result.Page)
+
+	result = ParseCommand(str2bytes(":search go -since 2024-05-01"))
+	assert.True(t, result.Error == nil)
+	assert.Equal(t, 2024, result.Since.Year())
+
+	result = ParseCommand(str2bytes(":search go -since yesterday"))
+	assert.Equal(t, errorInvalidValue, result.Error.t)
+
+	result = ParseCommand(str2bytes(":search go -page 9223372036854775807"))
+	assert.Equal(t, errorInvalidValue, result.Error.t)
+}
+
+func TestStatusCommand(t *testing.T) {
+	result := ParseCommand(str2bytes(":status dnd in a meeting"))
+	assert.True(t, result.Matched)
+	assert.Equal(t, CommandSetStatus, result.CommandType)
+	assert.Equal(t, []string{"dnd", "in a meeting"}, result.Args)
+
+	// Status text is optional
+	result = ParseCommand(str2bytes(":status online"))
+	assert.True(t, result.Error == nil)
+	assert.Equal(t, []string{"online"}, result.Args)
+}
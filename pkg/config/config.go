@@ -0,0 +1,372 @@
+// Configuration of the session service. It's read from a YAML file, and every value can be overridden
+// by an environment variable named after its path in the file, prefixed with CHAT, for example
+// CHAT_BACKEND_REDIS_PASSWORD overrides backend.redis.password and CHAT_TIMEOUTS_SESSION overrides timeouts.session.
+// Values which are set neither in the file nor in the environment keep their defaults.
+// Durations are written with a unit, like 90s, 15m or 24h.
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/isnastish/chat/pkg/backend"
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/mailer"
+	"github.com/isnastish/chat/pkg/session"
+	"github.com/isnastish/chat/pkg/validation"
+)
+
+// Prefix of the environment variables overriding the values.
+const envPrefix = "CHAT"
+
+type Listener struct {
+	// tcp, tcp4 or tcp6
+	Network string `yaml:"network"`
+	Address string `yaml:"address"`
+	TLS     TLS    `yaml:"tls"`
+}
+
+// Connections are accepted over TLS if both files are set.
+type TLS struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+type Backend struct {
+	// redis, dynamodb or memory
+	Type  string              `yaml:"type"`
+	Redis backend.RedisConfig `yaml:"redis"`
+}
+
+type Timeouts struct {
+	// Time for the session to tear down if nobody connected.
+	Session time.Duration `yaml:"session"`
+	// Time for an idle participant to be disconnected.
+	Participant time.Duration `yaml:"participant"`
+	// Time for the participant to reconnect and resume the session after the connection dropped.
+	Resume time.Duration `yaml:"resume"`
+	// Time for an idle participant to be displayed as away, zero disables it.
+	Away time.Duration `yaml:"away"`
+	// Time a queued message is kept for an offline participant.
+	OfflineQueue time.Duration `yaml:"offlineQueue"`
+	// Time a password reset token stays valid.
+	ResetToken time.Duration `yaml:"resetToken"`
+	// Minimum time between two reset tokens sent to the same participant.
+	ResetRequest time.Duration `yaml:"resetRequest"`
+}
+
+type Limits struct {
+	// Maximum size of a message in bytes, zero disables the limit.
+	MaxMessageSize int `yaml:"maxMessageSize"`
+	// Maximum size of an uploaded file in bytes.
+	MaxUploadSize int `yaml:"maxUploadSize"`
+	// Maximum number of messages queued for an offline participant.
+	OfflineQueueSize int                     `yaml:"offlineQueueSize"`
+	Flood            session.FloodProtection `yaml:"flood"`
+}
+
+type Retention struct {
+	session.RetentionPolicy `yaml:",inline"`
+	// How often the policies are enforced, zero disables it.
+	Interval time.Duration `yaml:"interval"`
+	// Policies overriding the limits above in the given channels.
+	Channels map[string]session.RetentionPolicy `yaml:"channels"`
+}
+
+type Validation struct {
+	validation.Config `yaml:",inline"`
+	// File with passwords which are not allowed, one per line, in addition to the listed ones.
+	BannedPasswordsFile string `yaml:"bannedPasswordsFile"`
+}
+
+type Mailer struct {
+	// smtp, file or stdout
+	Type string `yaml:"type"`
+	// Address of the SMTP server in host:port form.
+	Address  string `yaml:"address"`
+	Username string `yaml:"username"`
+	Password string `yaml:"password"`
+	From     string `yaml:"from"`
+	// File the emails are appended to by the file mailer.
+	Path string `yaml:"path"`
+}
+
+type Logging struct {
+	// debug, info, warning, error, fatal, panic, trace or disabled
+	Level string `yaml:"level"`
+	// File the audit log is appended to, stderr if not set.
+	Audit string `yaml:"audit"`
+}
+
+type Config struct {
+	Listener     Listener                   `yaml:"listener"`
+	Backend      Backend                    `yaml:"backend"`
+	Timeouts     Timeouts                   `yaml:"timeouts"`
+	Limits       Limits                     `yaml:"limits"`
+	Login        session.LoginPolicy        `yaml:"login"`
+	Verification session.VerificationPolicy `yaml:"verification"`
+	Retention    Retention                  `yaml:"retention"`
+	Validation   Validation                 `yaml:"validation"`
+	Mailer       Mailer                     `yaml:"mailer"`
+	Logging      Logging                    `yaml:"logging"`
+	// Participants allowed to moderate all the channels.
+	Moderators []string `yaml:"moderators"`
+	// Issuer displayed by authenticator apps for the two-factor authentication.
+	TOTPIssuer string `yaml:"totpIssuer"`
+	// Directory where uploaded files are stored.
+	BlobDir string `yaml:"blobDir"`
+}
+
+func Default() *Config {
+	return &Config{
+		Listener: Listener{Network: "tcp", Address: ":8080"},
+		Backend:  Backend{Type: backend.BackendTypes[backend.BackendTypeMemory]},
+		Timeouts: Timeouts{
+			Session:      24 * time.Hour,
+			Participant:  24 * time.Hour,
+			Resume:       5 * time.Minute,
+			Away:         5 * time.Minute,
+			OfflineQueue: 7 * 24 * time.Hour,
+			ResetToken:   time.Hour,
+			ResetRequest: time.Minute,
+		},
+		Limits: Limits{
+			MaxMessageSize:   1024,
+			MaxUploadSize:    10 * 1024 * 1024,
+			OfflineQueueSize: 100,
+			Flood: session.FloodProtection{
+				Participant:     session.RateLimit{Rate: 5, Burst: 10},
+				Address:         session.RateLimit{Rate: 20, Burst: 40},
+				ThrottleAfter:   3,
+				MuteAfter:       10,
+				DisconnectAfter: 20,
+				MuteDuration:    time.Minute,
+			},
+		},
+		Login: session.LoginPolicy{
+			MaxAttempts:     5,
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			LockoutDuration: 15 * time.Minute,
+			ResetAfter:      time.Hour,
+		},
+		Verification: session.VerificationPolicy{
+			CodeTimeout:    24 * time.Hour,
+			ResendInterval: time.Minute,
+			Restrictions:   session.Restrictions{Posting: true, CreatingChannels: true, Uploading: true},
+		},
+		Retention:  Retention{Interval: time.Hour},
+		Validation: Validation{Config: validation.DefaultConfig()},
+		Mailer: Mailer{
+			Type: mailer.MailerTypes[mailer.MailerTypeStdout],
+			From: "chat@localhost",
+			Path: "mail.txt",
+		},
+		Logging:    Logging{Level: "debug"},
+		TOTPIssuer: "chat",
+		BlobDir:    "blobs",
+	}
+}
+
+// Reads the file, if the path isn't empty, on top of the defaults and applies the overrides from the environment.
+// The returned error lists all the invalid values at once.
+func Load(path string, lookupEnv func(string) (string, bool)) (*Config, error) {
+	config := Default()
+	if path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+
+		decoder := yaml.NewDecoder(file)
+		// Misspelled keys would be silently ignored otherwise.
+		decoder.KnownFields(true)
+		if err := decoder.Decode(config); err != nil && err != io.EOF {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	errs := []error{applyEnv(config, envPrefix, lookupEnv)}
+
+	if config.Validation.BannedPasswordsFile != "" {
+		passwords, err := readBannedPasswords(config.Validation.BannedPasswordsFile)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("validation.bannedPasswordsFile: %w", err))
+		}
+		config.Validation.BannedPasswords = append(config.Validation.BannedPasswords, passwords...)
+	}
+
+	if err := errors.Join(append(errs, config.Validate())...); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// Empty lines and lines starting with # are skipped.
+func readBannedPasswords(path string) ([]string, error) {
+	contents, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var passwords []string
+	for _, line := range strings.Split(string(contents), "\n") {
+		line = strings.TrimSpace(line)
+		if line != "" && !strings.HasPrefix(line, "#") {
+			passwords = append(passwords, line)
+		}
+	}
+	return passwords, nil
+}
+
+// Returns all the invalid values joined into a single error, nil if the config is valid.
+func (c *Config) Validate() error {
+	var errs []error
+	check := func(valid bool, key string, format string, args ...any) {
+		if !valid {
+			errs = append(errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+		}
+	}
+	nonNegative := func(key string, value time.Duration) {
+		check(value >= 0, key, "cannot be negative")
+	}
+
+	check(c.Listener.Network == "tcp" || c.Listener.Network == "tcp4" || c.Listener.Network == "tcp6",
+		"listener.network", "unknown network %q, expected tcp, tcp4 or tcp6", c.Listener.Network)
+	check(c.Listener.Address != "", "listener.address", "is required")
+	check((c.Listener.TLS.CertFile == "") == (c.Listener.TLS.KeyFile == ""), "listener.tls", "both certFile and keyFile have to be set")
+	if c.Listener.TLS.CertFile != "" && c.Listener.TLS.KeyFile != "" {
+		_, err := tls.LoadX509KeyPair(c.Listener.TLS.CertFile, c.Listener.TLS.KeyFile)
+		check(err == nil, "listener.tls", "%v", err)
+	}
+
+	switch c.Backend.Type {
+	case backend.BackendTypes[backend.BackendTypeRedis]:
+		check(c.Backend.Redis.Endpoint != "", "backend.redis.endpoint", "is required by the redis backend")
+	case backend.BackendTypes[backend.BackendTypeDynamodb], backend.BackendTypes[backend.BackendTypeMemory]:
+	default:
+		check(false, "backend.type", "unknown backend %q, expected redis, dynamodb or memory", c.Backend.Type)
+	}
+
+	check(c.Timeouts.Session > 0, "timeouts.session", "has to be positive")
+	check(c.Timeouts.Participant > 0, "timeouts.participant", "has to be positive")
+	check(c.Timeouts.ResetToken > 0, "timeouts.resetToken", "has to be positive")
+	nonNegative("timeouts.resetRequest", c.Timeouts.ResetRequest)
+	nonNegative("timeouts.resume", c.Timeouts.Resume)
+	nonNegative("timeouts.away", c.Timeouts.Away)
+	nonNegative("timeouts.offlineQueue", c.Timeouts.OfflineQueue)
+
+	check(c.Limits.MaxMessageSize >= 0, "limits.maxMessageSize", "cannot be negative")
+	check(c.Limits.MaxUploadSize > 0, "limits.maxUploadSize", "has to be positive")
+	check(c.Limits.OfflineQueueSize >= 0, "limits.offlineQueueSize", "cannot be negative")
+	flood := &c.Limits.Flood
+	check(flood.Participant.Rate >= 0 && flood.Participant.Burst >= 0, "limits.flood.participant", "cannot be negative")
+	check(flood.Address.Rate >= 0 && flood.Address.Burst >= 0, "limits.flood.address", "cannot be negative")
+	check(flood.ThrottleAfter >= 0 && flood.MuteAfter >= 0 && flood.DisconnectAfter >= 0,
+		"limits.flood", "violation counts cannot be negative")
+	nonNegative("limits.flood.muteDuration", flood.MuteDuration)
+
+	check(c.Login.MaxAttempts >= 0, "login.maxAttempts", "cannot be negative")
+	nonNegative("login.baseDelay", c.Login.BaseDelay)
+	check(c.Login.MaxDelay >= c.Login.BaseDelay, "login.maxDelay", "cannot be shorter than login.baseDelay")
+	nonNegative("login.lockoutDuration", c.Login.LockoutDuration)
+	nonNegative("login.resetAfter", c.Login.ResetAfter)
+
+	check(c.Verification.CodeTimeout > 0, "verification.codeTimeout", "has to be positive")
+	nonNegative("verification.resendInterval", c.Verification.ResendInterval)
+
+	nonNegative("retention.maxAge", c.Retention.MaxAge)
+	check(c.Retention.MaxCount >= 0, "retention.maxCount", "cannot be negative")
+	nonNegative("retention.interval", c.Retention.Interval)
+	for channel, policy := range c.Retention.Channels {
+		check(policy.MaxAge >= 0 && policy.MaxCount >= 0, "retention.channels."+channel, "cannot be negative")
+	}
+
+	rules := &c.Validation.Config
+	check(rules.PasswordMinLength > 0, "validation.passwordMinLength", "has to be positive")
+	check(rules.PasswordMaxLength >= rules.PasswordMinLength, "validation.passwordMaxLength", "cannot be less than passwordMinLength")
+	check(!rules.RequireSymbol || rules.PasswordSymbols != "", "validation.passwordSymbols", "are required by requireSymbol")
+	check(rules.NameMinLength > 0, "validation.nameMinLength", "has to be positive")
+	check(rules.NameMaxLength >= rules.NameMinLength, "validation.nameMaxLength", "cannot be less than nameMinLength")
+
+	switch c.Mailer.Type {
+	case mailer.MailerTypes[mailer.MailerTypeSmtp]:
+		check(c.Mailer.Address != "" && c.Mailer.From != "", "mailer", "smtp mailer requires the server's address and the sender")
+	case mailer.MailerTypes[mailer.MailerTypeFile]:
+		check(c.Mailer.Path != "", "mailer.path", "is required by the file mailer")
+	case mailer.MailerTypes[mailer.MailerTypeStdout]:
+	default:
+		check(false, "mailer.type", "unknown mailer %q, expected smtp, file or stdout", c.Mailer.Type)
+	}
+
+	check(log.IsLevel(c.Logging.Level), "logging.level", "unknown level %q", c.Logging.Level)
+
+	return errors.Join(errs...)
+}
+
+// Has to be called on a valid config.
+func (c *Config) Session() session.Config {
+	config := session.Config{
+		Network:              c.Listener.Network,
+		Addr:                 c.Listener.Address,
+		TLSCertFile:          c.Listener.TLS.CertFile,
+		TLSKeyFile:           c.Listener.TLS.KeyFile,
+		SessionTimeout:       c.Timeouts.Session,
+		ParticipantTimeout:   c.Timeouts.Participant,
+		ResumeTimeout:        c.Timeouts.Resume,
+		AwayTimeout:          c.Timeouts.Away,
+		OfflineQueueSize:     c.Limits.OfflineQueueSize,
+		OfflineQueueTimeout:  c.Timeouts.OfflineQueue,
+		Retention:            c.Retention.RetentionPolicy,
+		ChannelRetention:     c.Retention.Channels,
+		RetentionInterval:    c.Retention.Interval,
+		Moderators:           c.Moderators,
+		MaxMessageSize:       c.Limits.MaxMessageSize,
+		Login:                c.Login,
+		ResetTokenTimeout:    c.Timeouts.ResetToken,
+		ResetRequestInterval: c.Timeouts.ResetRequest,
+		Verification:         c.Verification,
+		TOTPIssuer:           c.TOTPIssuer,
+		Validation:           c.Validation.Config,
+		Mailer: mailer.Config{
+			Addr:     c.Mailer.Address,
+			Username: c.Mailer.Username,
+			Password: c.Mailer.Password,
+			From:     c.Mailer.From,
+			Path:     c.Mailer.Path,
+		},
+		Flood:         c.Limits.Flood,
+		MaxUploadSize: c.Limits.MaxUploadSize,
+		BlobDir:       c.BlobDir,
+	}
+
+	switch c.Mailer.Type {
+	case mailer.MailerTypes[mailer.MailerTypeSmtp]:
+		config.Mailer.MailerType = mailer.MailerTypeSmtp
+	case mailer.MailerTypes[mailer.MailerTypeFile]:
+		config.Mailer.MailerType = mailer.MailerTypeFile
+	default:
+		config.Mailer.MailerType = mailer.MailerTypeStdout
+	}
+
+	switch c.Backend.Type {
+	case backend.BackendTypes[backend.BackendTypeRedis]:
+		config.BackendType = backend.BackendTypeRedis
+		redisConfig := c.Backend.Redis
+		config.RedisConfig = &redisConfig
+	case backend.BackendTypes[backend.BackendTypeDynamodb]:
+		config.BackendType = backend.BackendTypeDynamodb
+		config.DynamodbConfig = &backend.DynamodbConfig{}
+	default:
+		config.BackendType = backend.BackendTypeMemory
+	}
+
+	return config
+}
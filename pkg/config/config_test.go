@@ -0,0 +1,127 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/backend"
+)
+
+func writeConfig(t *testing.T, contents string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	assert.True(t, os.WriteFile(path, []byte(contents), 0o600) == nil)
+	return path
+}
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		value, exists := env[name]
+		return value, exists
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := writeConfig(t, `
+listener:
+  address: ":9090"
+backend:
+  type: redis
+  redis:
+    endpoint: localhost:6379
+timeouts:
+  session: 30m
+login:
+  maxAttempts: 3
+retention:
+  maxCount: 1000
+  channels:
+    announcements:
+      maxAge: 24h
+moderators: [AliceCooper]
+`)
+	env := map[string]string{
+		"CHAT_BACKEND_REDIS_PASSWORD":         "secret",
+		"CHAT_TIMEOUTS_AWAY":                  "90s",
+		"CHAT_LIMITS_FLOOD_PARTICIPANT_RATE":  "2.5",
+		"CHAT_VERIFICATION_REQUIRED":          "true",
+		"CHAT_VALIDATION_EMAIL_DOMAINS":       "example.com,example.org",
+		"CHAT_VALIDATION_PASSWORD_MIN_LENGTH": "16",
+	}
+	config, err := Load(path, lookupIn(env))
+	assert.True(t, err == nil, err)
+
+	assert.Equal(t, ":9090", config.Listener.Address)
+	assert.Equal(t, "tcp", config.Listener.Network)
+	assert.Equal(t, 30*time.Minute, config.Timeouts.Session)
+	assert.Equal(t, 24*time.Hour, config.Timeouts.Participant)
+	assert.Equal(t, 90*time.Second, config.Timeouts.Away)
+	assert.Equal(t, 3, config.Login.MaxAttempts)
+	assert.Equal(t, time.Minute, config.Login.MaxDelay)
+	assert.Equal(t, 1000, config.Retention.MaxCount)
+	assert.Equal(t, 24*time.Hour, config.Retention.Channels["announcements"].MaxAge)
+	assert.Equal(t, 2.5, config.Limits.Flood.Participant.Rate)
+	assert.Equal(t, 10, config.Limits.Flood.Participant.Burst)
+	assert.True(t, config.Verification.Required)
+	assert.True(t, config.Verification.Posting)
+	assert.Equal(t, []string{"example.com", "example.org"}, config.Validation.EmailDomains)
+	assert.Equal(t, 16, config.Validation.PasswordMinLength)
+
+	session := config.Session()
+	assert.Equal(t, backend.BackendTypeRedis, session.BackendType)
+	assert.Equal(t, "secret", session.RedisConfig.Password)
+	assert.Equal(t, "localhost:6379", session.RedisConfig.Endpoint)
+	assert.Equal(t, 30*time.Minute, session.SessionTimeout)
+	assert.Equal(t, []string{"AliceCooper"}, session.Moderators)
+}
+
+func TestLoadDefaults(t *testing.T) {
+	config, err := Load("", lookupIn(nil))
+	assert.True(t, err == nil, err)
+	assert.Equal(t, Default(), config)
+	assert.Equal(t, backend.BackendTypeMemory, config.Session().BackendType)
+}
+
+func TestLoadErrors(t *testing.T) {
+	_, err := Load(writeConfig(t, "listener:\n  adress: \":9090\"\n"), lookupIn(nil))
+	assert.ErrorContains(t, err, "field adress not found")
+
+	// Durations need a unit, a plain number used to be taken as seconds
+	_, err = Load(writeConfig(t, "timeouts:\n  session: 3600\n"), lookupIn(nil))
+	assert.Error(t, err)
+
+	_, err = Load("", lookupIn(map[string]string{"CHAT_TIMEOUTS_SESSION": "3600", "CHAT_LOGIN_MAX_ATTEMPTS": "five", "CHAT_BACKEND_TYPE": "redis"}))
+	assert.ErrorContains(t, err, "CHAT_TIMEOUTS_SESSION: invalid duration")
+	assert.ErrorContains(t, err, "CHAT_LOGIN_MAX_ATTEMPTS: invalid integer")
+	assert.ErrorContains(t, err, "backend.redis.endpoint: is required by the redis backend")
+
+	// All the invalid values are reported at once
+	_, err = Load(writeConfig(t, `
+listener:
+  tls:
+    certFile: cert.pem
+backend:
+  type: redis
+login:
+  baseDelay: 2m
+  maxDelay: 1m
+mailer:
+  type: pigeon
+logging:
+  level: verbose
+`), lookupIn(nil))
+	assert.ErrorContains(t, err, "listener.tls: both certFile and keyFile have to be set")
+	assert.ErrorContains(t, err, "backend.redis.endpoint: is required by the redis backend")
+	assert.ErrorContains(t, err, "login.maxDelay: cannot be shorter than login.baseDelay")
+	assert.ErrorContains(t, err, `mailer.type: unknown mailer "pigeon"`)
+	assert.ErrorContains(t, err, `logging.level: unknown level "verbose"`)
+}
+
+func TestEnvName(t *testing.T) {
+	assert.Equal(t, "MAX_MESSAGE_SIZE", envName("maxMessageSize"))
+	assert.Equal(t, "CERT_FILE", envName("certFile"))
+	assert.Equal(t, "SESSION", envName("session"))
+}
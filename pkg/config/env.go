@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// Overrides the fields of the config with the environment variables named after their yaml keys,
+// for example Listener.TLS.CertFile is overridden by CHAT_LISTENER_TLS_CERT_FILE.
+// Lists are comma-separated, maps can only be set in the file.
+func applyEnv(config *Config, prefix string, lookupEnv func(string) (string, bool)) error {
+	var errs []error
+	applyEnvToStruct(reflect.ValueOf(config).Elem(), prefix, lookupEnv, &errs)
+	return errors.Join(errs...)
+}
+
+func applyEnvToStruct(value reflect.Value, prefix string, lookupEnv func(string) (string, bool), errs *[]error) {
+	for i := 0; i < value.NumField(); i++ {
+		field := value.Type().Field(i)
+		key, options, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if key == "-" || !field.IsExported() {
+			continue
+		}
+
+		name := prefix
+		if options != "inline" {
+			name += "_" + envName(key)
+		}
+
+		fieldValue := value.Field(i)
+		if fieldValue.Kind() == reflect.Struct {
+			applyEnvToStruct(fieldValue, name, lookupEnv, errs)
+			continue
+		}
+
+		env, exists := lookupEnv(name)
+		if !exists {
+			continue
+		}
+		if err := setFromEnv(fieldValue, env); err != nil {
+			*errs = append(*errs, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+}
+
+// maxMessageSize becomes MAX_MESSAGE_SIZE.
+func envName(key string) string {
+	var builder strings.Builder
+	for i, c := range key {
+		if unicode.IsUpper(c) && i > 0 {
+			builder.WriteByte('_')
+		}
+		builder.WriteRune(unicode.ToUpper(c))
+	}
+	return builder.String()
+}
+
+func setFromEnv(value reflect.Value, env string) error {
+	switch value.Kind() {
+	case reflect.String:
+		value.SetString(env)
+
+	case reflect.Bool:
+		parsed, err := strconv.ParseBool(env)
+		if err != nil {
+			return fmt.Errorf("invalid boolean %q", env)
+		}
+		value.SetBool(parsed)
+
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		if value.Type() == durationType {
+			parsed, err := time.ParseDuration(env)
+			if err != nil {
+				return fmt.Errorf("invalid duration %q, expected a value with a unit like 90s or 15m", env)
+			}
+			value.SetInt(int64(parsed))
+			return nil
+		}
+		parsed, err := strconv.ParseInt(env, 10, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid integer %q", env)
+		}
+		value.SetInt(parsed)
+
+	case reflect.Float32, reflect.Float64:
+		parsed, err := strconv.ParseFloat(env, value.Type().Bits())
+		if err != nil {
+			return fmt.Errorf("invalid number %q", env)
+		}
+		value.SetFloat(parsed)
+
+	case reflect.Slice:
+		if value.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("cannot be set from the environment")
+		}
+		var items []string
+		if env != "" {
+			items = strings.Split(env, ",")
+		}
+		value.Set(reflect.ValueOf(items))
+
+	default:
+		return fmt.Errorf("cannot be set from the environment")
+	}
+	return nil
+}
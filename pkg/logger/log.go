@@ -2,6 +2,7 @@ package log
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"runtime"
 	"strings"
@@ -42,29 +43,37 @@ func setupLogger(level string) *logger {
 	return logger
 }
 
-func setLogLevel(level string) error {
-	var logLevelMap = map[string]zerolog.Level{
-		"debug":    zerolog.DebugLevel,
-		"info":     zerolog.InfoLevel,
-		"warning":  zerolog.WarnLevel,
-		"error":    zerolog.ErrorLevel,
-		"fatal":    zerolog.FatalLevel,
-		"panic":    zerolog.PanicLevel,
-		"disabled": zerolog.Disabled,
-		"trace":    zerolog.TraceLevel,
-	}
+var logLevelMap = map[string]zerolog.Level{
+	"debug":    zerolog.DebugLevel,
+	"info":     zerolog.InfoLevel,
+	"warning":  zerolog.WarnLevel,
+	"error":    zerolog.ErrorLevel,
+	"fatal":    zerolog.FatalLevel,
+	"panic":    zerolog.PanicLevel,
+	"disabled": zerolog.Disabled,
+	"trace":    zerolog.TraceLevel,
+}
 
+func setLogLevel(level string) error {
 	zerologLevel, exists := logLevelMap[level]
 	if exists {
 		zerolog.SetGlobalLevel(zerologLevel)
-		globalLevel := zerolog.GlobalLevel()
-		_ = globalLevel
 		return nil
 	}
 
 	return fmt.Errorf("Undefined log level: %s", level)
 }
 
+// Audit events are written regardless of the level, unless logging is disabled.
+func SetLevel(level string) error {
+	return setLogLevel(strings.ToLower(level))
+}
+
+func IsLevel(level string) bool {
+	_, exists := logLevelMap[strings.ToLower(level)]
+	return exists
+}
+
 func (l *logger) log(level zerolog.Level, format string, args ...any) {
 	switch level {
 	case zerolog.InfoLevel:
@@ -113,3 +122,30 @@ func (l *logger) Panic(format string, args ...any) {
 }
 
 var Logger = setupLogger("debug")
+
+// Security related events, such as failed logins and lockouts, are written to a separate audit log,
+// one json object per line. The log is written to stderr unless redirected with SetAuditOutput.
+type auditLogger struct {
+	zerolog zerolog.Logger
+}
+
+func newAuditLogger(out io.Writer) *auditLogger {
+	return &auditLogger{
+		zerolog: zerolog.New(out).With().Timestamp().Str("log", "audit").Logger(),
+	}
+}
+
+// Has to be called before the session starts, the logger is not replaced atomically.
+func SetAuditOutput(out io.Writer) {
+	Audit = newAuditLogger(out)
+}
+
+func (a *auditLogger) Event(event string, fields map[string]string) {
+	entry := a.zerolog.Log().Str("event", event)
+	for name, value := range fields {
+		entry = entry.Str(name, value)
+	}
+	entry.Send()
+}
+
+var Audit = newAuditLogger(os.Stderr)
@@ -0,0 +1,129 @@
+// Mailers deliver emails to participants, for example password reset tokens.
+// SMTP is used in production, while the file and stdout mailers are meant for development and tests.
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+)
+
+type MailerType int8
+
+const (
+	MailerTypeStdout MailerType = iota
+	MailerTypeFile
+	MailerTypeSmtp
+)
+
+var MailerTypes = [...]string{
+	MailerTypeStdout: "stdout",
+	MailerTypeFile:   "file",
+	MailerTypeSmtp:   "smtp",
+}
+
+type Config struct {
+	MailerType
+	// Address of the SMTP server in host:port form.
+	Addr     string
+	Username string
+	Password string
+	From     string
+	// File the emails are appended to by the file mailer.
+	Path string
+}
+
+type Mailer interface {
+	Send(to string, subject string, body string) error
+}
+
+func NewMailer(config *Config) (Mailer, error) {
+	switch config.MailerType {
+	case MailerTypeSmtp:
+		if config.Addr == "" || config.From == "" {
+			return nil, fmt.Errorf("smtp mailer requires the server's address and the sender")
+		}
+		return &smtpMailer{config: *config}, nil
+
+	case MailerTypeFile:
+		if config.Path == "" {
+			return nil, fmt.Errorf("file mailer requires a path")
+		}
+		return &fileMailer{path: config.Path, from: config.From}, nil
+
+	default:
+		return NewWriterMailer(os.Stdout, config.From), nil
+	}
+}
+
+// Message in RFC 5322 format, lines are terminated with CRLF.
+func buildMessage(from, to, subject, body string) []byte {
+	var builder strings.Builder
+	builder.WriteString("From: " + from + "\r\n")
+	builder.WriteString("To: " + to + "\r\n")
+	builder.WriteString("Subject: " + subject + "\r\n")
+	builder.WriteString("\r\n")
+	builder.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	builder.WriteString("\r\n")
+	return []byte(builder.String())
+}
+
+type smtpMailer struct {
+	config Config
+}
+
+func (m *smtpMailer) Send(to string, subject string, body string) error {
+	var auth smtp.Auth
+	if m.config.Username != "" {
+		host, _, err := net.SplitHostPort(m.config.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.config.Username, m.config.Password, host)
+	}
+	return smtp.SendMail(m.config.Addr, auth, m.config.From, []string{to}, buildMessage(m.config.From, to, subject, body))
+}
+
+// Writes every email to the writer, followed by an empty line.
+type writerMailer struct {
+	out  io.Writer
+	from string
+	mu   sync.Mutex
+}
+
+func NewWriterMailer(out io.Writer, from string) *writerMailer {
+	return &writerMailer{out: out, from: from}
+}
+
+func (m *writerMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err := m.out.Write(append(buildMessage(m.from, to, subject, body), "\r\n"...))
+	return err
+}
+
+// Appends every email to the file, the file is opened for every email so it can be rotated.
+type fileMailer struct {
+	path string
+	from string
+	mu   sync.Mutex
+}
+
+func (m *fileMailer) Send(to string, subject string, body string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	file, err := os.OpenFile(m.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	_, err = file.Write(append(buildMessage(m.from, to, subject, body), "\r\n"...))
+	return err
+}
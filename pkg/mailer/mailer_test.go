@@ -0,0 +1,39 @@
+package mailer
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriterMailer(t *testing.T) {
+	var out bytes.Buffer
+	mailer := NewWriterMailer(&out, "chat@example.com")
+
+	assert.True(t, mailer.Send("alice@example.com", "Password reset", "token: 1234") == nil)
+	assert.Equal(t, "From: chat@example.com\r\nTo: alice@example.com\r\nSubject: Password reset\r\n\r\ntoken: 1234\r\n\r\n", out.String())
+}
+
+func TestFileMailer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.txt")
+	mailer, err := NewMailer(&Config{MailerType: MailerTypeFile, Path: path})
+	assert.True(t, err == nil)
+
+	assert.True(t, mailer.Send("alice@example.com", "First", "one") == nil)
+	assert.True(t, mailer.Send("bob@example.com", "Second", "two") == nil)
+
+	contents, err := os.ReadFile(path)
+	assert.True(t, err == nil)
+	assert.Contains(t, string(contents), "Subject: First")
+	assert.Contains(t, string(contents), "Subject: Second")
+}
+
+func TestInvalidConfig(t *testing.T) {
+	_, err := NewMailer(&Config{MailerType: MailerTypeSmtp})
+	assert.True(t, err != nil)
+	_, err = NewMailer(&Config{MailerType: MailerTypeFile})
+	assert.True(t, err != nil)
+}
@@ -0,0 +1,92 @@
+// Token bucket rate limiting, used by the session to protect the backend from flooding.
+package ratelimit
+
+import (
+	"sync"
+	"time"
+)
+
+// Buckets which haven't been used for that long are full again, so they can be dropped.
+const purgeInterval = time.Minute
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// Limiter maintains a token bucket per key, for example a username or an ip address.
+// Every bucket holds at most burst tokens and is refilled at rate tokens per second.
+type Limiter struct {
+	rate      float64
+	burst     float64
+	buckets   map[string]*bucket
+	lastPurge time.Time
+	mu        sync.Mutex
+}
+
+// Zero rate disables the limiter, every request is allowed.
+func NewLimiter(rate float64, burst int) *Limiter {
+	return &Limiter{
+		rate:    rate,
+		burst:   float64(max(burst, 1)),
+		buckets: make(map[string]*bucket),
+	}
+}
+
+func (l *Limiter) refill(key string, now time.Time) *bucket {
+	b, exists := l.buckets[key]
+	if !exists {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens = min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	return b
+}
+
+func (l *Limiter) purge(now time.Time) {
+	if now.Sub(l.lastPurge) < purgeInterval {
+		return
+	}
+	for key, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastPurge = now
+}
+
+// Takes a token from the key's bucket, returns false if the bucket is empty.
+func (l *Limiter) Allow(key string, now time.Time) bool {
+	if l.rate <= 0 {
+		return true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.purge(now)
+
+	b := l.refill(key, now)
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// Returns how long it takes for the key's bucket to have a token available.
+func (l *Limiter) Delay(key string, now time.Time) time.Duration {
+	if l.rate <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b := l.refill(key, now)
+	if b.tokens >= 1 {
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+}
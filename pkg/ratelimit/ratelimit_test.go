@@ -0,0 +1,36 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter(t *testing.T) {
+	limiter := NewLimiter(2, 3)
+	now := time.Now()
+
+	for i := 0; i < 3; i++ {
+		assert.True(t, limiter.Allow("alice", now))
+	}
+	assert.False(t, limiter.Allow("alice", now))
+	assert.Equal(t, 500*time.Millisecond, limiter.Delay("alice", now))
+
+	// Buckets are independent
+	assert.True(t, limiter.Allow("bob", now))
+
+	// Two tokens are added every second
+	now = now.Add(time.Second)
+	assert.True(t, limiter.Allow("alice", now))
+	assert.True(t, limiter.Allow("alice", now))
+	assert.False(t, limiter.Allow("alice", now))
+}
+
+func TestDisabledLimiter(t *testing.T) {
+	limiter := NewLimiter(0, 0)
+	for i := 0; i < 100; i++ {
+		assert.True(t, limiter.Allow("alice", time.Now()))
+	}
+	assert.Equal(t, time.Duration(0), limiter.Delay("alice", time.Now()))
+}
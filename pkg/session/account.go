@@ -0,0 +1,222 @@
+package session
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Sender of the messages of deleted participants which were anonymized.
+// It can't be taken by anyone, since it's not a valid username.
+const deletedSender = "[deleted]"
+
+// What happens to the messages of a participant who deletes their account.
+const (
+	// The messages stay in the history, with the sender replaced.
+	messagesAnonymize = "anonymize"
+	// The messages are deleted, leaving anonymous tombstones in the history, and the shared files are removed.
+	messagesRemove = "remove"
+)
+
+type exportedProfile struct {
+	Username      string    `json:"username"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"emailVerified"`
+	Registered    time.Time `json:"registered"`
+	LastSeen      time.Time `json:"lastSeen"`
+	DisplayName   string    `json:"displayName,omitempty"`
+	Bio           string    `json:"bio,omitempty"`
+	Timezone      string    `json:"timezone,omitempty"`
+	TwoFactor     bool      `json:"twoFactor"`
+}
+
+type exportedMessage struct {
+	Id           string    `json:"id"`
+	Channel      string    `json:"channel"`
+	SentTime     string    `json:"sentTime"`
+	Timestamp    time.Time `json:"timestamp"`
+	Contents     string    `json:"contents"`
+	EditTime     string    `json:"editTime,omitempty"`
+	ParentId     string    `json:"parentId,omitempty"`
+	AttachmentId string    `json:"attachmentId,omitempty"`
+}
+
+type exportedToken struct {
+	Id      string    `json:"id"`
+	Name    string    `json:"name"`
+	Scopes  string    `json:"scopes"`
+	Created time.Time `json:"created"`
+}
+
+// Everything the session keeps about a participant, except for the secrets.
+type accountArchive struct {
+	Exported    time.Time           `json:"exported"`
+	Profile     exportedProfile     `json:"profile"`
+	Memberships []string            `json:"memberships"`
+	ReadMarkers map[string]uint64   `json:"readMarkers"`
+	Messages    []exportedMessage   `json:"messages"`
+	Attachments []*types.Attachment `json:"attachments"`
+	APITokens   []exportedToken     `json:"apiTokens"`
+}
+
+// Returns the messages the participant has sent to the general chat and all the channels, replies included.
+func (s *session) authoredMessages(username string) []*types.ChatMessage {
+	channels := []string{""}
+	for _, channel := range s.storage.GetChannels() {
+		channels = append(channels, channel.Name)
+	}
+
+	var messages []*types.ChatMessage
+	for _, channel := range channels {
+		for _, msg := range s.storage.GetChatHistory(channel) {
+			if canonical.Equal(msg.Sender, username) {
+				messages = append(messages, msg)
+			}
+		}
+	}
+	return messages
+}
+
+func (s *session) buildAccountArchive(username string) *accountArchive {
+	participant := s.storage.GetParticipant(username)
+	if participant == nil {
+		return nil
+	}
+
+	archive := &accountArchive{
+		Exported: time.Now(),
+		Profile: exportedProfile{
+			Username:      participant.Username,
+			Email:         participant.Email,
+			EmailVerified: !participant.Unverified,
+			Registered:    participant.Registered,
+			LastSeen:      participant.LastSeen,
+			DisplayName:   participant.DisplayName,
+			Bio:           participant.Bio,
+			Timezone:      participant.Timezone,
+			TwoFactor:     s.hasSecondFactor(username),
+		},
+		Memberships: []string{},
+		ReadMarkers: s.storage.GetReadMarkers(username),
+		Messages:    []exportedMessage{},
+		Attachments: []*types.Attachment{},
+		APITokens:   []exportedToken{},
+	}
+
+	for _, channel := range s.storage.GetChannels() {
+		for _, member := range channel.Members {
+			if canonical.Equal(member, username) {
+				archive.Memberships = append(archive.Memberships, channel.Name)
+				break
+			}
+		}
+	}
+
+	for _, msg := range s.authoredMessages(username) {
+		if msg.Deleted {
+			continue
+		}
+		archive.Messages = append(archive.Messages, exportedMessage{
+			Id:           msg.Id,
+			Channel:      msg.Channel,
+			SentTime:     msg.SentTime,
+			Timestamp:    msg.Timestamp,
+			Contents:     msg.Contents.String(),
+			EditTime:     msg.EditTime,
+			ParentId:     msg.ParentId,
+			AttachmentId: msg.AttachmentId,
+		})
+		if msg.AttachmentId != "" {
+			if attachment := s.storage.GetAttachment(msg.AttachmentId); attachment != nil {
+				archive.Attachments = append(archive.Attachments, attachment)
+			}
+		}
+	}
+
+	for _, token := range s.storage.GetAPITokens(username) {
+		archive.APITokens = append(archive.APITokens, exportedToken{Id: token.Id, Name: token.Name, Scopes: token.Scopes, Created: token.Created})
+	}
+	return archive
+}
+
+// The archive is sent as a file download, the chat client saves it to its download directory.
+func (r *readerFSM) exportData(session *session) {
+	username := r.conn.participant.Username
+	archive := session.buildAccountArchive(username)
+	if archive == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to export the data", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	data, err := json.MarshalIndent(archive, "", "  ")
+	if err != nil {
+		log.Logger.Error("Failed to export the data of %s: %v", username, err)
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to export the data", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	log.Audit.Event("data-exported", map[string]string{"username": username, "address": r.conn.ipAddr})
+	name := util.Fmt("%s-export-%s.json", username, archive.Exported.Format("20060102-150405"))
+	r.sendFile(session, "export-"+util.RandomHex(types.MessageIdLength), name, util.Sha256Checksum(data), data)
+	session.sendMsg(types.BuildSysMsg(
+		util.Fmtln("{server: %s} Exported %d messages to %s", util.TimeNowStr(), len(archive.Messages), name), r.conn.ipAddr,
+	))
+}
+
+// Arguments: the current password and optionally what happens to the messages, anonymize (the default) or remove.
+// All the connections of the participant are closed afterwards.
+func (r *readerFSM) deleteAccount(session *session, args []string) {
+	messages := messagesAnonymize
+	if len(args) > 1 {
+		messages = strings.ToLower(args[1])
+	}
+	if messages != messagesAnonymize && messages != messagesRemove {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Unknown option %s, expected %s or %s", util.TimeNowStr(), args[1], messagesAnonymize, messagesRemove), r.conn.ipAddr,
+		))
+		return
+	}
+
+	if !r.checkPassword(session, args[0], "account-deletion-failed") {
+		return
+	}
+
+	username := r.conn.participant.Username
+	session.deleteParticipant(username, messages)
+	log.Audit.Event("account-deleted", map[string]string{"username": username, "address": r.conn.ipAddr, "messages": messages})
+
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Account %s deleted", util.TimeNowStr(), username), r.conn.ipAddr))
+
+	// None of the participant's devices can resume their sessions, including the ones which have disconnected.
+	session.resumeTokens.revokeUser(username)
+	r.conn.resumeToken = ""
+
+	// Other devices of the participant are disconnected right away, this one once the message is delivered.
+	session.connMap.closeConnections(func(conn *connection) bool {
+		return canonical.Equal(conn.participant.Username, username) && conn.ipAddr != r.conn.ipAddr
+	})
+	r.updateState(stateDisconnecting)
+}
+
+func (s *session) deleteParticipant(username string, messages string) {
+	for _, msg := range s.authoredMessages(username) {
+		if messages == messagesRemove {
+			if msg.AttachmentId != "" {
+				if err := s.blobs.Delete(msg.AttachmentId); err != nil {
+					log.Logger.Error("Failed to remove file %s: %v", msg.AttachmentId, err)
+				}
+				s.storage.DeleteAttachment(msg.AttachmentId)
+			}
+			s.storage.DeleteMessage(msg.Id)
+		}
+		s.storage.SetMessageSender(msg.Id, deletedSender)
+	}
+
+	s.storage.DeleteParticipant(username)
+	s.presences.remove(username)
+}
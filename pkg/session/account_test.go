@@ -0,0 +1,89 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/blobstore"
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func newAccountSession() *session {
+	s := newTestSession(Config{})
+	s.storage.RegisterChannel(&types.Channel{Name: "announcements", Creator: "AliceCooper"})
+	s.storage.AddChannelMember("announcements", "AliceCooper")
+
+	s.blobs.Put("attachment", []byte("contents"))
+	s.storage.StoreAttachment(&types.Attachment{Id: "attachment", Name: "notes.txt", Size: 8, Uploader: "AliceCooper"})
+	s.storage.StoreMessage(&types.ChatMessage{Id: "first", Sender: "AliceCooper", Contents: bytes.NewBufferString("hello"), AttachmentId: "attachment"})
+	s.storage.StoreMessage(&types.ChatMessage{Id: "second", Sender: "BobMarley", Contents: bytes.NewBufferString("hi")})
+	s.storage.StoreMessage(&types.ChatMessage{Id: "third", Sender: "AliceCooper", Channel: "announcements", Contents: bytes.NewBufferString("news")})
+	return s
+}
+
+func TestAccountArchive(t *testing.T) {
+	s := newAccountSession()
+	s.storage.StoreAPIToken(&types.APIToken{Id: "a", Hash: "hash-a", Username: "AliceCooper", Name: "bot", Scopes: "read", Created: time.Now()})
+
+	archive := s.buildAccountArchive("AliceCooper")
+	assert.Equal(t, "alice@gmail.com", archive.Profile.Email)
+	assert.Equal(t, []string{"announcements"}, archive.Memberships)
+	assert.Equal(t, 2, len(archive.Messages))
+	assert.Equal(t, "notes.txt", archive.Attachments[0].Name)
+	assert.Equal(t, "bot", archive.APITokens[0].Name)
+
+	assert.True(t, s.buildAccountArchive("nonexistent") == nil)
+}
+
+func TestDeleteAccount(t *testing.T) {
+	s := newAccountSession()
+	reader, _ := newTestReader(t, "AliceCooper")
+	reader.conn.resumeToken = s.resumeTokens.issue("AliceCooper", "")
+	// A device which has disconnected recently
+	disconnected := s.resumeTokens.issue("AliceCooper", "")
+	s.resumeTokens.detach(disconnected, "", map[string]uint64{}, time.Minute)
+
+	reader.deleteAccount(s, []string{"Secret#12345", "archive"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Unknown option archive")
+
+	reader.deleteAccount(s, []string{"Wrong#123456"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Current password is incorrect")
+	assert.True(t, s.storage.HasParticipant("AliceCooper"))
+
+	s.presences.set("AliceCooper", presenceDoNotDisturb, "")
+	channels := s.storage.GetChannels()
+	reader.deleteAccount(s, []string{"Secret#12345"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Account AliceCooper deleted")
+	assert.False(t, s.storage.HasParticipant("AliceCooper"))
+	assert.Equal(t, presence{}, s.presences.get("AliceCooper"))
+	assert.Equal(t, 0, len(s.storage.GetChannels()[0].Members))
+	// Channels listed before are left untouched
+	assert.Equal(t, []string{"AliceCooper"}, channels[0].Members)
+	assert.True(t, matchState(reader.state, stateDisconnecting))
+	_, resumed := s.resumeTokens.redeem(disconnected)
+	assert.False(t, resumed)
+	assert.Equal(t, 0, len(s.resumeTokens.entries))
+
+	// Anonymized messages stay in the history
+	assert.Equal(t, deletedSender, s.storage.GetMessage("first").Sender)
+	assert.Equal(t, "hello", s.storage.GetMessage("first").Contents.String())
+	assert.Equal(t, "BobMarley", s.storage.GetMessage("second").Sender)
+	_, err := s.blobs.Get("attachment")
+	assert.True(t, err == nil)
+}
+
+func TestDeleteParticipantRemovesMessages(t *testing.T) {
+	s := newAccountSession()
+	s.deleteParticipant("AliceCooper", messagesRemove)
+
+	assert.True(t, s.storage.GetMessage("first").Deleted)
+	assert.Equal(t, deletedSender, s.storage.GetMessage("third").Sender)
+	assert.True(t, s.storage.GetMessage("third").Deleted)
+	assert.False(t, s.storage.GetMessage("second").Deleted)
+	_, err := s.blobs.Get("attachment")
+	assert.Equal(t, blobstore.ErrNotFound, err)
+	assert.True(t, s.storage.GetAttachment("attachment") == nil)
+}
@@ -0,0 +1,300 @@
+package session
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/commands"
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+	"github.com/isnastish/chat/pkg/validation"
+)
+
+const (
+	// Length of an API token in bytes, it's displayed hex encoded.
+	apiTokenLength = 32
+	// Length of a token's id in bytes.
+	apiTokenIdLength = 4
+
+	// Receive messages and read the history, search and threads.
+	scopeRead = "read"
+	// Post, edit and react to messages.
+	scopePost = "post"
+	// Upload and download files.
+	scopeFiles = "files"
+)
+
+// Scopes of the tokens created without specifying any.
+var defaultScopes = []string{scopeRead, scopePost}
+
+// Scopes required for running the commands with an API token.
+// Commands which aren't listed don't require any scope,
+// and the ones mapped to an empty scope can't be run with a token at all.
+var commandScopes = map[commands.CommandType]string{
+	commands.CommandDisplayHistory: scopeRead,
+	commands.CommandDisplayThread:  scopeRead,
+	commands.CommandSearchMessages: scopeRead,
+	commands.CommandListPins:       scopeRead,
+	commands.CommandEditMessage:    scopePost,
+	commands.CommandDeleteMessage:  scopePost,
+	commands.CommandReplyMessage:   scopePost,
+	commands.CommandReactMessage:   scopePost,
+	commands.CommandUnreactMessage: scopePost,
+	commands.CommandPinMessage:     scopePost,
+	commands.CommandUnpinMessage:   scopePost,
+	commands.CommandSetTopic:       scopePost,
+	commands.CommandRenameChannel:  scopePost,
+	commands.CommandUploadFile:     scopeFiles,
+	commands.CommandDownloadFile:   scopeFiles,
+	commands.CommandChangePassword: "",
+	commands.CommandVerifyEmail:    "",
+	commands.CommandResendCode:     "",
+	commands.CommandTwoFactor:      "",
+	commands.CommandAPIToken:       "",
+	commands.CommandDeleteAccount:  "",
+	commands.CommandExportData:     "",
+	commands.CommandSetProfile:     "",
+}
+
+// Scopes of a connection authenticated with an API token. The read and post scopes
+// can be limited to a channel as read:<channel> and post:<channel>, the general chat is referred to as "-".
+// Nil for the participants who logged in with a password, they're allowed to do anything.
+type tokenScopes map[string]bool
+
+func parseScopes(policy *validation.Policy, fields []string) (tokenScopes, bool) {
+	scopes := make(tokenScopes, len(fields))
+	for _, field := range fields {
+		scope, channel, limited := strings.Cut(field, ":")
+		switch {
+		case scope == scopeFiles && !limited:
+		case scope == scopeRead || scope == scopePost:
+			if limited && channel != types.FrameGeneralChannel && policy.CheckName(channel) != nil {
+				return nil, false
+			}
+		default:
+			return nil, false
+		}
+		scopes[field] = true
+	}
+	return scopes, len(scopes) > 0
+}
+
+// Channels are compared by their canonical keys, since the scopes are typed by the participants.
+func (s tokenScopes) allows(scope string, channel string) bool {
+	if s == nil || s[scope] {
+		return true
+	}
+	for granted := range s {
+		if name, limited := strings.CutPrefix(granted, scope+":"); limited && canonical.Equal(name, encodeFrameChannel(channel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Channels the scope is limited to, nil if it isn't limited.
+func (s tokenScopes) channels(scope string) []string {
+	if s == nil || s[scope] {
+		return nil
+	}
+	channels := make([]string, 0, len(s))
+	for granted := range s {
+		if name, limited := strings.CutPrefix(granted, scope+":"); limited {
+			channels = append(channels, decodeFrameChannel(name))
+		}
+	}
+	return channels
+}
+
+func (s tokenScopes) String() string {
+	scopes := make([]string, 0, len(s))
+	for scope := range s {
+		scopes = append(scopes, scope)
+	}
+	sort.Strings(scopes)
+	return strings.Join(scopes, " ")
+}
+
+func apiTokenHash(token string) string {
+	return util.Sha256Checksum([]byte(token))
+}
+
+// Arguments: the action, an optional name or id, and optional scopes.
+func (r *readerFSM) manageAPITokens(session *session, args []string) {
+	switch strings.ToLower(args[0]) {
+	case "create":
+		if len(args) < 2 {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Token name not specified", util.TimeNowStr()), r.conn.ipAddr))
+			return
+		}
+		fields := defaultScopes
+		if len(args) > 2 {
+			fields = strings.Fields(args[2])
+		}
+		r.createAPIToken(session, args[1], fields)
+
+	case "list":
+		r.listAPITokens(session)
+
+	case "revoke":
+		if len(args) < 2 {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Token id not specified", util.TimeNowStr()), r.conn.ipAddr))
+			return
+		}
+		r.revokeAPIToken(session, args[1])
+
+	default:
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Unknown action %s, expected create, list or revoke", util.TimeNowStr(), args[0]), r.conn.ipAddr,
+		))
+	}
+}
+
+// The token is displayed only once, only its hash is stored.
+func (r *readerFSM) createAPIToken(session *session, name string, fields []string) {
+	scopes, valid := parseScopes(session.policy, fields)
+	if !valid {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Invalid scopes, expected read, post, read:<channel>, post:<channel> or files", util.TimeNowStr()), r.conn.ipAddr,
+		))
+		return
+	}
+
+	token := util.RandomHex(apiTokenLength)
+	apiToken := &types.APIToken{
+		Id:       util.RandomHex(apiTokenIdLength),
+		Hash:     apiTokenHash(token),
+		Username: r.conn.participant.Username,
+		Name:     name,
+		Scopes:   scopes.String(),
+		Created:  time.Now(),
+	}
+	session.storage.StoreAPIToken(apiToken)
+	log.Audit.Event("api-token-created", map[string]string{
+		"username": apiToken.Username, "address": r.conn.ipAddr, "id": apiToken.Id, "scopes": apiToken.Scopes,
+	})
+
+	session.sendMsg(types.BuildSysMsg(util.Fmt(
+		"{server: %s} Token [%s] %s created with scopes: %s\n\t%s\nIt won't be displayed again, log in with it by sending the %s frame.\n",
+		util.TimeNowStr(), apiToken.Id, name, apiToken.Scopes, token, types.FrameLogin,
+	), r.conn.ipAddr))
+}
+
+func (r *readerFSM) listAPITokens(session *session) {
+	tokens := session.storage.GetAPITokens(r.conn.participant.Username)
+	if len(tokens) == 0 {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} No API tokens", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	var builder strings.Builder
+	builder.WriteString("tokens:\n")
+	for _, token := range tokens {
+		builder.WriteString(util.Fmt("\t[%s] %s, scopes: %s, created: %s\n", token.Id, token.Name, token.Scopes, token.Created.Format(time.DateTime)))
+	}
+	session.sendMsg(types.BuildSysMsg(builder.String(), r.conn.ipAddr))
+}
+
+// Connections logged in with the token are closed.
+func (r *readerFSM) revokeAPIToken(session *session, id string) {
+	token := session.storage.DeleteAPIToken(r.conn.participant.Username, id)
+	if token == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Token %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	session.connMap.closeConnections(func(conn *connection) bool { return conn.apiToken == token.Hash })
+	log.Audit.Event("api-token-revoked", map[string]string{"username": token.Username, "address": r.conn.ipAddr, "id": token.Id})
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Token [%s] %s revoked", util.TimeNowStr(), token.Id, token.Name), r.conn.ipAddr))
+}
+
+// Logs in with an API token sent in a single control frame, skipping the menu.
+// Invalid tokens count as failed login attempts of the address.
+func (r *readerFSM) loginWithToken(session *session, args []string) {
+	if !matchState(r.state, stateJoining) || len(args) != 1 {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Token login is only possible right after connecting", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	key := addressAttemptsKey(r.conn.ipAddr)
+	now := time.Now()
+	if delay := session.loginDelay(key, now); delay > 0 {
+		log.Audit.Event("login-rejected", map[string]string{"address": r.conn.ipAddr, "retryAfter": delay.String()})
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many failed attempts, try again in %v", util.TimeNowStr(), delay.Round(time.Second)), r.conn.ipAddr,
+		))
+		return
+	}
+
+	token := session.storage.GetAPIToken(apiTokenHash(args[0]))
+	var participant *types.Participant
+	if token != nil {
+		participant = session.storage.GetParticipant(token.Username)
+	}
+	if participant == nil {
+		log.Audit.Event("token-login-failed", map[string]string{"address": r.conn.ipAddr})
+		if session.recordLoginFailure(key, now) {
+			log.Audit.Event("lockout", map[string]string{
+				"key": key, "address": r.conn.ipAddr, "duration": session.config.Login.LockoutDuration.String(),
+			})
+		}
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to authenticate. Token is invalid.", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	scopes, _ := parseScopes(session.policy, strings.Fields(token.Scopes))
+	r.conn.participant.Username = participant.Username
+	r.conn.participant.Unverified = participant.Unverified
+	r.conn.participant.JoinTime = util.TimeNowStr()
+	r.conn.apiToken = token.Hash
+	session.storage.SetLastSeen(participant.Username, now)
+	r.conn.scopes = scopes
+
+	go r.conn.disconnectIfIdle()
+	r.issueResumeToken(session)
+
+	log.Audit.Event("token-login", map[string]string{"username": participant.Username, "address": r.conn.ipAddr, "id": token.Id})
+	session.sendMsg(types.BuildSysMsg(
+		util.Fmtln("{server: %s} Logged in as %s with token [%s], scopes: %s", util.TimeNowStr(), participant.Username, token.Id, token.Scopes), r.conn.ipAddr,
+	))
+
+	session.connMap.markAsConnected(r.conn.ipAddr)
+	r.updateState(stateAcceptingMessages)
+}
+
+// Returns false and notifies the participant if the token the connection is logged in with doesn't allow the command.
+func (r *readerFSM) commandAllowed(session *session, result *commands.ParseResult) bool {
+	scope, limited := commandScopes[result.CommandType]
+	if r.conn.scopes == nil || !limited {
+		return true
+	}
+
+	channel := r.conn.channel.Name
+	switch result.CommandType {
+	case commands.CommandDisplayHistory:
+		channel = result.Channel
+
+	case commands.CommandSearchMessages:
+		// Searching all the channels only requires reading some of them, the results are limited to those.
+		if result.Channel == "" && len(r.conn.scopes.channels(scopeRead)) > 0 {
+			return true
+		}
+		channel = result.Channel
+
+	// Commands referring to a message are checked against the channel the message was sent to.
+	case commands.CommandEditMessage, commands.CommandDeleteMessage, commands.CommandReplyMessage, commands.CommandDisplayThread,
+		commands.CommandReactMessage, commands.CommandUnreactMessage, commands.CommandPinMessage, commands.CommandUnpinMessage:
+		if msg := session.storage.GetMessage(result.Args[0]); msg != nil {
+			channel = msg.Channel
+		}
+	}
+	if scope != "" && r.conn.scopes.allows(scope, channel) {
+		return true
+	}
+
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Not allowed with this API token", util.TimeNowStr()), r.conn.ipAddr))
+	return false
+}
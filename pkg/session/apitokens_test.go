@@ -0,0 +1,154 @@
+package session
+
+import (
+	"io"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/commands"
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestParseScopes(t *testing.T) {
+	policy := newTestPolicy()
+	scopes, valid := parseScopes(policy, []string{"read", "post:announcements", "post:-", "files"})
+	assert.True(t, valid)
+	assert.Equal(t, "files post:- post:announcements read", scopes.String())
+
+	assert.True(t, scopes.allows(scopeRead, "randomchannel"))
+	assert.True(t, scopes.allows(scopePost, "announcements"))
+	assert.True(t, scopes.allows(scopePost, ""))
+	assert.False(t, scopes.allows(scopePost, "randomchannel"))
+
+	// Participants logged in with a password are allowed to do anything
+	assert.True(t, tokenScopes(nil).allows(scopePost, "randomchannel"))
+
+	_, valid = parseScopes(policy, []string{"admin"})
+	assert.False(t, valid)
+	_, valid = parseScopes(policy, []string{"files:announcements"})
+	assert.False(t, valid)
+	_, valid = parseScopes(policy, nil)
+	assert.False(t, valid)
+}
+
+func TestTokenLogin(t *testing.T) {
+	s := newTestSession(Config{})
+	owner, _ := newTestReader(t, "AliceCooper")
+
+	owner.manageAPITokens(s, []string{"create", "deploy-bot", "read post:announcements"})
+	created := (<-s.sysMessages).Contents.String()
+	assert.Contains(t, created, "post:announcements read")
+	token := regexp.MustCompile(`[0-9a-f]{64}`).FindString(created)
+	id := s.storage.GetAPITokens("AliceCooper")[0].Id
+	// Only the hash is stored
+	assert.Equal(t, apiTokenHash(token), s.storage.GetAPITokens("AliceCooper")[0].Hash)
+
+	bot, botRemote := newTestReader(t, "")
+	s.connMap.addConn(bot.conn)
+	received := make(chan []byte)
+	go func() {
+		data, _ := io.ReadAll(botRemote)
+		received <- data
+	}()
+
+	bot.loginWithToken(s, []string{"0123456789abcdef"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Token is invalid")
+	assert.True(t, matchState(bot.state, stateJoining))
+
+	bot.loginWithToken(s, []string{token})
+	<-s.sysMessages // resume token
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Logged in as AliceCooper")
+	assert.True(t, matchState(bot.state, stateAcceptingMessages))
+	assert.True(t, s.connMap.hasConnectedParticipant("AliceCooper"))
+
+	// Posting is limited to the general channel
+	bot.postMessage(s, types.BuildChatMsg([]byte("hello"), "AliceCooper", "randomchannel"))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Not allowed with this API token")
+
+	// Account commands can't be run with a token
+	assert.False(t, bot.commandAllowed(s, &commands.ParseResult{CommandType: commands.CommandChangePassword}))
+	<-s.sysMessages
+	assert.True(t, bot.commandAllowed(s, &commands.ParseResult{CommandType: commands.CommandDisplayHistory}))
+	assert.True(t, owner.commandAllowed(s, &commands.ParseResult{CommandType: commands.CommandChangePassword}))
+
+	owner.manageAPITokens(s, []string{"revoke", id})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "revoked")
+	assert.Equal(t, 0, len(s.storage.GetAPITokens("AliceCooper")))
+
+	// Connections logged in with the token are closed
+	assert.Contains(t, string(<-received), types.BuildControlFrame(types.FrameClose))
+}
+
+func TestTokenScopedChannels(t *testing.T) {
+	s := newTestSession(Config{})
+	s.storage.RegisterChannel(&types.Channel{Name: "announcements", Creator: "AliceCooper"})
+	general := types.BuildChatMsg([]byte("general news"), "AliceCooper")
+	s.storage.StoreMessage(general)
+	announcement := types.BuildChatMsg([]byte("channel news"), "AliceCooper", "announcements")
+	s.storage.StoreMessage(announcement)
+	s.storage.StoreAPIToken(&types.APIToken{Id: "a", Hash: "hash-a", Username: "AliceCooper", Name: "bot", Scopes: "post:announcements read:announcements", Created: time.Now()})
+
+	bot, _ := newTestReader(t, "AliceCooper")
+	bot.conn.scopes, _ = parseScopes(s.policy, []string{"read:announcements", "post:announcements"})
+
+	// Messages are checked against the channel they were sent to, not the one the bot is in
+	assert.True(t, bot.commandAllowed(s, &commands.ParseResult{CommandType: commands.CommandDisplayThread, Args: []string{announcement.Id}}))
+	assert.False(t, bot.commandAllowed(s, &commands.ParseResult{CommandType: commands.CommandEditMessage, Args: []string{general.Id, "edited"}}))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Not allowed with this API token")
+
+	// Search results are limited to the channels the token can read
+	search := &commands.ParseResult{CommandType: commands.CommandSearchMessages, Args: []string{"news"}}
+	assert.True(t, bot.commandAllowed(s, search))
+	bot.searchMessages(s, search)
+	results := (<-s.sysMessages).Contents.String()
+	assert.Contains(t, results, "Found 1 message(s)")
+	assert.NotContains(t, results, "general news")
+
+	// So are the messages replayed when the session is resumed
+	token := s.resumeTokens.issue("AliceCooper", "hash-a")
+	s.resumeTokens.detach(token, "announcements", map[string]uint64{}, time.Minute)
+	resumed, _ := newTestReader(t, "")
+	s.connMap.addConn(resumed.conn)
+	resumed.resumeSession(s, []string{token})
+	replayed := (<-s.sysMessages).Contents.String()
+	assert.Contains(t, replayed, "1 missed messages")
+	assert.NotContains(t, replayed, "general news")
+}
+
+func TestTokenReadScopeNotifications(t *testing.T) {
+	bob := types.Participant{Username: "BobMarley", Password: "Secret#12345", Email: "bob@gmail.com"}
+	alice := testParticipant
+	s := newTestSession(Config{}, &alice, &bob)
+	channel := &types.Channel{Name: "bookshelf", Creator: "BobMarley"}
+	s.storage.RegisterChannel(channel)
+
+	// The bot can post to the channel it's in, but only read the announcements
+	bot, botRemote := newTestReader(t, "AliceCooper")
+	bot.conn.ipAddr = "127.0.0.1:5000"
+	bot.conn.scopes, _ = parseScopes(s.policy, []string{"read:announcements", "post:bookshelf"})
+	author, authorRemote := newTestReader(t, "BobMarley")
+	author.conn.ipAddr = "127.0.0.1:5001"
+	for _, reader := range []*readerFSM{bot, author} {
+		s.connMap.addConn(reader.conn)
+		s.connMap.markAsConnected(reader.conn.ipAddr)
+		s.connMap.setChannel(reader.conn.ipAddr, channel)
+	}
+	go io.Copy(io.Discard, botRemote)
+	go io.Copy(io.Discard, authorRemote)
+
+	mention := types.BuildChatMsg([]byte("@AliceCooper have a look"), "BobMarley", "bookshelf")
+	s.storage.StoreMessage(mention)
+	assert.Equal(t, 0, len(author.notifyMentions(s, mention)))
+	assert.Equal(t, 0, len(s.sysMessages))
+
+	posted := types.BuildChatMsg([]byte("new arrivals"), "AliceCooper", "bookshelf")
+	s.storage.StoreMessage(posted)
+	bot.editMessage(s, posted.Id, "new arrivals today")
+	// Only delivered to the author
+	assert.Equal(t, 1, s.connMap.broadcastMessage(<-s.sysMessages))
+	bot.deleteMessage(s, posted.Id)
+	assert.Equal(t, 1, s.connMap.broadcastMessage(<-s.sysMessages))
+}
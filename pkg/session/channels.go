@@ -0,0 +1,70 @@
+package session
+
+import (
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Returns the channel the participant is in if they are allowed to modify it,
+// otherwise notifies the participant and returns nil.
+func (r *readerFSM) getModifiableChannel(session *session) *types.Channel {
+	channel := session.findChannel(r.conn.channel.Name)
+	if channel == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Select a channel first", util.TimeNowStr()), r.conn.ipAddr))
+		return nil
+	}
+
+	if !session.isModerator(r.conn.participant.Username, channel.Name) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Not allowed to modify channel %s", util.TimeNowStr(), channel.Name), r.conn.ipAddr))
+		return nil
+	}
+	return channel
+}
+
+func (r *readerFSM) setTopic(session *session, topic string) {
+	channel := r.getModifiableChannel(session)
+	if channel == nil {
+		return
+	}
+
+	updated := *channel
+	updated.Desc = topic
+	session.storage.UpdateChannel(channel.Name, &updated)
+	session.connMap.updateChannel(channel.Name, session.findChannel(channel.Name))
+
+	sysMsg := types.BuildSysMsg(util.Fmtln("{server: %s} %s changed the topic: %s", util.TimeNowStr(), r.conn.participant.Username, topic))
+	sysMsg.Channel = channel.Name
+	session.sendMsg(sysMsg)
+}
+
+// History, members and pins are preserved, participants in the channel stay in it.
+func (r *readerFSM) renameChannel(session *session, name string) {
+	channel := r.getModifiableChannel(session)
+	if channel == nil {
+		return
+	}
+
+	if reasons := session.policy.CheckName(name); reasons != nil {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Channel name {%s} is invalid: %s", util.TimeNowStr(), name, formatReasons(reasons)), r.conn.ipAddr,
+		))
+		return
+	}
+
+	updated := *channel
+	updated.Name = name
+	if !session.storage.UpdateChannel(channel.Name, &updated) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Channel {%s} already exist", util.TimeNowStr(), name), r.conn.ipAddr))
+		return
+	}
+	session.connMap.updateChannel(channel.Name, session.findChannel(name))
+	session.resumeTokens.renameChannel(channel.Name, name)
+
+	// The frame lets clients carry the last received sequence number over to the new name.
+	sysMsg := types.BuildSysMsg(
+		types.BuildControlFrame(types.FrameChannelRenamed, channel.Name, name) +
+			util.Fmtln("{server: %s} %s renamed channel %s to %s", util.TimeNowStr(), r.conn.participant.Username, channel.Name, name),
+	)
+	sysMsg.Channel = name
+	session.sendMsg(sysMsg)
+}
@@ -4,8 +4,11 @@ import (
 	"bytes"
 	"context"
 	"net"
+	"sort"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/isnastish/chat/pkg/logger"
@@ -23,20 +26,33 @@ const (
 var connStateTable []string
 
 type connection struct {
-	netConn                net.Conn
-	ipAddr                 string
-	participant            *types.Participant
-	channel                *types.Channel
-	timeout                time.Duration
+	netConn     net.Conn
+	ipAddr      string
+	participant *types.Participant
+	channel     *types.Channel
+	timeout     time.Duration
+	awayTimeout time.Duration
+	// Set once the connection has been idle for awayTimeout.
+	away                   atomic.Bool
 	ctx                    context.Context
 	cancel                 context.CancelFunc
 	abortConnectionTimeout chan struct{}
 	state                  connectionState
+	// Empty until the participant is authenticated,
+	// and cleared when the participant leaves on purpose.
+	resumeToken string
+	// Hash of the API token the participant logged in with, and its scopes.
+	// Both are empty if the participant logged in with a password.
+	apiToken string
+	scopes   tokenScopes
 }
 
 type connectionMap struct {
 	connections map[string]*connection
-	mu          sync.RWMutex
+	// Connected (authenticated) connections of each participant, keyed by ip address.
+	// A participant can be connected from multiple devices at the same time.
+	participants map[string]map[string]*connection
+	mu           sync.RWMutex
 }
 
 func init() {
@@ -45,7 +61,7 @@ func init() {
 	connStateTable[connectedState] = "online"
 }
 
-func newConn(conn net.Conn, timeout time.Duration) *connection {
+func newConn(conn net.Conn, timeout, awayTimeout time.Duration) *connection {
 	ctx, cancel := context.WithCancel(context.Background())
 	return &connection{
 		netConn:                conn,
@@ -53,6 +69,7 @@ func newConn(conn net.Conn, timeout time.Duration) *connection {
 		participant:            &types.Participant{},
 		channel:                &types.Channel{},
 		timeout:                timeout,
+		awayTimeout:            awayTimeout,
 		ctx:                    ctx,
 		cancel:                 cancel,
 		abortConnectionTimeout: make(chan struct{}),
@@ -62,7 +79,8 @@ func newConn(conn net.Conn, timeout time.Duration) *connection {
 
 func newConnectionMap() *connectionMap {
 	return &connectionMap{
-		connections: make(map[string]*connection),
+		connections:  make(map[string]*connection),
+		participants: make(map[string]map[string]*connection),
 	}
 }
 
@@ -72,8 +90,20 @@ func (c *connection) matchState(state connectionState) bool {
 
 func (c *connection) disconnectIfIdle() {
 	timer := time.NewTimer(c.timeout)
+
+	// The connection is marked as away before it's disconnected, zero timeout disables it.
+	var awayTimer *time.Timer
+	var awayC <-chan time.Time
+	if c.awayTimeout > 0 {
+		awayTimer = time.NewTimer(c.awayTimeout)
+		awayC = awayTimer.C
+		defer awayTimer.Stop()
+	}
+
 	for {
 		select {
+		case <-awayC:
+			c.away.Store(true)
 		case <-timer.C:
 			// The timer has fired, close the net connection manually,
 			// and invoke the cancel() function in order to send a message to the client
@@ -89,6 +119,14 @@ func (c *connection) disconnectIfIdle() {
 				<-timer.C
 			}
 			timer.Reset(c.timeout)
+
+			if awayTimer != nil {
+				// The away timer has already been drained if the connection is away.
+				if !c.away.Swap(false) && !awayTimer.Stop() {
+					<-awayTimer.C
+				}
+				awayTimer.Reset(c.awayTimeout)
+			}
 		case <-c.ctx.Done():
 			// A signal to unblock this procedure was received,
 			// so we can exit gracefully without having go routine leaks.
@@ -116,21 +154,89 @@ func (cm *connectionMap) removeConn(connIpAddr string) {
 		log.Logger.Panic("Connection {%s} doesn't exist", connIpAddr)
 	}
 
+	conn := cm.connections[connIpAddr]
+	if devices, exists := cm.participants[conn.participant.Username]; exists {
+		delete(devices, connIpAddr)
+		if len(devices) == 0 {
+			delete(cm.participants, conn.participant.Username)
+		}
+	}
+
 	delete(cm.connections, connIpAddr)
 }
 
 func (cm *connectionMap) hasConnectedParticipant(username string) bool {
 	cm.mu.RLock()
 	defer cm.mu.RUnlock()
+	return len(cm.participants[username]) != 0
+}
 
-	for _, conn := range cm.connections {
-		if conn.matchState(connectedState) {
-			if conn.participant.Username == username {
-				return true
-			}
+// Returns the ip addresses of all the devices the participant is connected from, sorted.
+func (cm *connectionMap) participantDevices(username string) []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	devices := make([]string, 0, len(cm.participants[username]))
+	for ipAddr := range cm.participants[username] {
+		devices = append(devices, ipAddr)
+	}
+	sort.Strings(devices)
+	return devices
+}
+
+// Returns the ip addresses of participant's devices which are allowed to read the channel.
+func (cm *connectionMap) readingDevices(username string, channel string) []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	devices := make([]string, 0, len(cm.participants[username]))
+	for ipAddr, conn := range cm.participants[username] {
+		if conn.scopes.allows(scopeRead, channel) {
+			devices = append(devices, ipAddr)
 		}
 	}
-	return false
+	sort.Strings(devices)
+	return devices
+}
+
+// Returns true if all the devices the participant is connected from are idle.
+func (cm *connectionMap) isParticipantAway(username string) bool {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	devices := cm.participants[username]
+	for _, conn := range devices {
+		if !conn.away.Load() {
+			return false
+		}
+	}
+	return len(devices) != 0
+}
+
+// Returns online or away depending on whether the device is idle.
+func (cm *connectionMap) deviceState(ipAddr string) string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	if conn, exists := cm.connections[ipAddr]; exists && conn.away.Load() {
+		return presenceStateTable[presenceAway]
+	}
+	return connStateTable[connectedState]
+}
+
+// Returns the ip addresses of all the connected devices in the channel, except the ones of the given participant.
+func (cm *connectionMap) channelDevices(channel string, except string) []string {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	var devices []string
+	for ipAddr, conn := range cm.connections {
+		if conn.matchState(connectedState) && conn.channel.Name == channel && conn.participant.Username != except {
+			devices = append(devices, ipAddr)
+		}
+	}
+	sort.Strings(devices)
+	return devices
 }
 
 func (cm *connectionMap) empty() bool {
@@ -147,7 +253,100 @@ func (cm *connectionMap) markAsConnected(connIpAddr string) {
 		log.Logger.Panic("Connection {%s} doesn't exist", connIpAddr)
 	}
 
-	cm.connections[connIpAddr].state = connectedState
+	conn := cm.connections[connIpAddr]
+	conn.state = connectedState
+
+	devices, exists := cm.participants[conn.participant.Username]
+	if !exists {
+		devices = make(map[string]*connection)
+		cm.participants[conn.participant.Username] = devices
+	}
+	devices[connIpAddr] = conn
+}
+
+// Convert message into a canonical form, which includes the name of the sender and the time when the message was sent.
+// The message is preceded by a control frame holding its sequence number,
+// so the client knows where to resume from if the connection drops.
+func canonicalChatMessage(msg *types.ChatMessage) string {
+	return sequenceFrame(msg) + formatChatMessage(msg)
+}
+
+// Message's id is displayed so participants can refer to the message in commands, for example :edit <id>.
+func formatChatMessage(msg *types.ChatMessage) string {
+	var builder strings.Builder
+	builder.WriteString(util.Fmt("{%s:%s} [%s] ", msg.Sender, msg.SentTime, msg.Id))
+
+	if msg.ParentId != "" {
+		builder.WriteString(util.Fmt("re:[%s] ", msg.ParentId))
+	}
+
+	if msg.Deleted {
+		builder.WriteString("<message deleted>")
+	} else {
+		builder.WriteString(msg.Contents.String())
+		if msg.EditTime != "" {
+			builder.WriteString(util.Fmt(" (edited %s)", msg.EditTime))
+		}
+		if msg.AttachmentId != "" {
+			builder.WriteString(util.Fmt(" [file %s]", msg.AttachmentId))
+		}
+	}
+
+	if msg.ReplyCount != 0 {
+		builder.WriteString(util.Fmt(" (%d replies)", msg.ReplyCount))
+	}
+
+	if len(msg.Reactions) != 0 {
+		emojis := make([]string, 0, len(msg.Reactions))
+		for emoji := range msg.Reactions {
+			emojis = append(emojis, emoji)
+		}
+		sort.Strings(emojis)
+
+		reactions := make([]string, 0, len(emojis))
+		for _, emoji := range emojis {
+			reactions = append(reactions, util.Fmt("%s %d", emoji, msg.Reactions[emoji]))
+		}
+		builder.WriteString(util.Fmt(" (%s)", strings.Join(reactions, " ")))
+	}
+	return util.Fmtln(builder.String())
+}
+
+// Used when messages from different channels are displayed together.
+func channelLabel(channel string) string {
+	if channel == "" {
+		return "general"
+	}
+	return channel
+}
+
+func sequenceFrame(msg *types.ChatMessage) string {
+	return types.BuildControlFrame(types.FrameSequence, encodeFrameChannel(msg.Channel), strconv.FormatUint(msg.Seq, 10))
+}
+
+// Changes the channel the participant is in. Has to be done through the connection map,
+// because the channel is read when broadcasting messages.
+func (cm *connectionMap) setChannel(connIpAddr string, channel *types.Channel) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	if !cm._doesConnExist(connIpAddr) {
+		log.Logger.Panic("Connection {%s} doesn't exist", connIpAddr)
+	}
+
+	cm.connections[connIpAddr].channel = channel
+}
+
+// Replaces the channel of all the participants in it, after it was updated in the backend.
+func (cm *connectionMap) updateChannel(channelname string, channel *types.Channel) {
+	cm.mu.Lock()
+	defer cm.mu.Unlock()
+
+	for _, conn := range cm.connections {
+		if conn.channel.Name == channelname {
+			conn.channel = channel
+		}
+	}
 }
 
 // Pointers to interfaces: https://stackoverflow.com/questions/44370277/type-is-pointer-to-interface-not-interface-confusion
@@ -158,19 +357,21 @@ func (cm *connectionMap) broadcastMessage(msg interface{}) int {
 	defer cm.mu.RUnlock()
 
 	switch msg := msg.(type) {
-	case *types.ChatMessage:
-		senderWasSkipped := false
-		// Convert message into a canonical form, which includes the name of the sender and the time when the message was sent.
-		canonChatMsg := bytes.NewBuffer([]byte(util.Fmtln("{%s:%s} %s", msg.Sender, msg.SentTime, msg.Contents.String())))
+	case *chatEnvelope:
+		canonChatMsg := canonicalChatMessage(msg.message)
+		// The device which sent the message has already displayed it,
+		// it only needs the sequence number in order to be able to resume the session.
+		seqFrame := sequenceFrame(msg.message)
 		for _, conn := range cm.connections {
-			if conn.matchState(connectedState) {
-				if !senderWasSkipped && strings.EqualFold(conn.participant.Username, msg.Sender) {
-					senderWasSkipped = true
-					continue
+			if conn.matchState(connectedState) && conn.scopes.allows(scopeRead, msg.message.Channel) {
+				contents := canonChatMsg
+				if conn.ipAddr == msg.origin {
+					contents = seqFrame
 				}
 
-				n, err := util.WriteBytes(conn.netConn, canonChatMsg)
-				if err != nil || (n != msg.Contents.Len()) {
+				buf := bytes.NewBufferString(contents)
+				n, err := util.WriteBytes(conn.netConn, buf)
+				if err != nil || (n != buf.Len()) {
 					log.Logger.Error("Failed to send a chat message to the participant: %s", conn.participant.Username)
 				} else {
 					sentCount++
@@ -191,9 +392,17 @@ func (cm *connectionMap) broadcastMessage(msg interface{}) int {
 				}
 			}
 		} else {
-			// A case where messages about participants leaving broadcasted to all the other connected participants
+			// A case where messages about participants leaving broadcasted to all the other connected participants.
+			// Messages addressed to a channel are only delivered to participants in that channel.
 			for _, conn := range cm.connections {
 				if conn.matchState(connectedState) {
+					if msg.Channel != "" && conn.channel.Name != msg.Channel {
+						continue
+					}
+					if msg.Scoped && !conn.scopes.allows(scopeRead, msg.Channel) {
+						continue
+					}
+
 					n, err := util.WriteBytes(conn.netConn, msg.Contents)
 					if err != nil || (n != msg.Contents.Len()) {
 						log.Logger.Error("Failed to send a system message to the participant: %s", conn.participant.Username)
@@ -210,3 +419,17 @@ func (cm *connectionMap) broadcastMessage(msg interface{}) int {
 
 	return sentCount
 }
+
+// Closes the connections the participants shouldn't stay connected with, for example the ones
+// logged in with a revoked API token. The clients are told not to reconnect, and the readers disconnect them.
+func (cm *connectionMap) closeConnections(match func(conn *connection) bool) {
+	cm.mu.RLock()
+	defer cm.mu.RUnlock()
+
+	for _, conn := range cm.connections {
+		if match(conn) {
+			util.WriteBytes(conn.netConn, bytes.NewBufferString(types.BuildControlFrame(types.FrameClose)))
+			conn.netConn.Close()
+		}
+	}
+}
@@ -1,14 +1,13 @@
 package session
 
 import (
-// "net"
-// "sync"
-// "testing"
-// "time"
-
-// "github.com/isnastish/chat/pkg/backend"
-// "github.com/stretchr/testify/assert"
-// "go.uber.org/goleak"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/utilities"
 )
 
 // func TestAddNewConnection(t *testing.T) {
@@ -70,3 +69,34 @@ import (
 // 	connMap.removeConn(connIpAdds[0])
 // 	assert.False(t, connMap.isParticipantConnected(connIpAdds[0]))
 // }
+
+func TestMultipleDevicesPerParticipant(t *testing.T) {
+	connMap := newConnectionMap()
+
+	var conns []*connection
+	for i := 0; i < 3; i++ {
+		local, remote := net.Pipe()
+		defer local.Close()
+		defer remote.Close()
+
+		conn := newConn(local, time.Second, 0)
+		conn.ipAddr = util.Fmt("127.0.0.1:500%d", i)
+		conn.participant.Username = "MarkLutz"
+		connMap.addConn(conn)
+		conns = append(conns, conn)
+	}
+
+	// Pending connections are not counted
+	assert.False(t, connMap.hasConnectedParticipant("MarkLutz"))
+
+	connMap.markAsConnected(conns[0].ipAddr)
+	connMap.markAsConnected(conns[1].ipAddr)
+	assert.True(t, connMap.hasConnectedParticipant("MarkLutz"))
+	assert.Equal(t, []string{conns[0].ipAddr, conns[1].ipAddr}, connMap.participantDevices("MarkLutz"))
+
+	connMap.removeConn(conns[0].ipAddr)
+	assert.True(t, connMap.hasConnectedParticipant("MarkLutz"))
+	connMap.removeConn(conns[1].ipAddr)
+	assert.False(t, connMap.hasConnectedParticipant("MarkLutz"))
+	assert.Equal(t, 0, len(connMap.participantDevices("MarkLutz")))
+}
@@ -0,0 +1,175 @@
+package session
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/url"
+	"strconv"
+
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Size of the chunks files are downloaded in, the client reassembles frames split across reads.
+const downloadChunkSize = 32 * 1024
+
+// A file being uploaded by the participant, only one upload per connection is allowed at a time.
+type upload struct {
+	id       string
+	name     string
+	size     uint64
+	checksum string
+	data     bytes.Buffer
+}
+
+func (r *readerFSM) rejectUpload(session *session, reason string) {
+	r.upload = nil
+	session.sendMsg(types.BuildSysMsg(
+		types.BuildControlFrame(types.FrameUploadRejected)+util.Fmtln("{server: %s} Upload failed: %s", util.TimeNowStr(), reason),
+		r.conn.ipAddr,
+	))
+}
+
+// Arguments: file's name (query escaped), size and sha256 checksum.
+func (r *readerFSM) startUpload(session *session, args []string) {
+	if !r.conn.matchState(connectedState) {
+		r.rejectUpload(session, "authentication required")
+		return
+	}
+	if !r.conn.scopes.allows(scopeFiles, "") {
+		r.rejectUpload(session, "not allowed with this API token")
+		return
+	}
+	if session.config.Verification.Uploading && !r.isVerified(session) {
+		r.rejectUpload(session, "email address not verified")
+		return
+	}
+	if r.upload != nil {
+		r.rejectUpload(session, "another upload is in progress")
+		return
+	}
+	if len(args) != 3 {
+		r.rejectUpload(session, "invalid request")
+		return
+	}
+
+	name, nameErr := url.QueryUnescape(args[0])
+	size, sizeErr := strconv.ParseUint(args[1], 10, 64)
+	if nameErr != nil || sizeErr != nil || name == "" {
+		r.rejectUpload(session, "invalid request")
+		return
+	}
+	if size > uint64(session.config.MaxUploadSize) {
+		r.rejectUpload(session, util.Fmt("file exceeds the maximum size of %d bytes", session.config.MaxUploadSize))
+		return
+	}
+
+	id := util.RandomHex(types.MessageIdLength)
+	for session.storage.GetAttachment(id) != nil {
+		id = util.RandomHex(types.MessageIdLength)
+	}
+
+	r.upload = &upload{id: id, name: name, size: size, checksum: args[2]}
+	session.sendMsg(types.BuildSysMsg(types.BuildControlFrame(types.FrameUploadReady, id), r.conn.ipAddr))
+}
+
+// Arguments: upload's id and base64 encoded data.
+func (r *readerFSM) receiveChunk(session *session, args []string) {
+	if r.upload == nil || len(args) != 2 || args[0] != r.upload.id {
+		r.rejectUpload(session, "no upload in progress")
+		return
+	}
+
+	chunk, err := base64.StdEncoding.DecodeString(args[1])
+	if err != nil {
+		r.rejectUpload(session, "malformed chunk")
+		return
+	}
+	if uint64(r.upload.data.Len()+len(chunk)) > r.upload.size {
+		r.rejectUpload(session, "file is larger than announced")
+		return
+	}
+
+	r.upload.data.Write(chunk)
+	session.sendMsg(types.BuildSysMsg(
+		types.BuildControlFrame(types.FrameUploadAck, r.upload.id, strconv.Itoa(r.upload.data.Len())), r.conn.ipAddr,
+	))
+}
+
+// Verifies the file, stores it and posts a message referencing it in participant's current channel.
+func (r *readerFSM) finishUpload(session *session, args []string) {
+	if r.upload == nil || len(args) != 1 || args[0] != r.upload.id {
+		r.rejectUpload(session, "no upload in progress")
+		return
+	}
+
+	file := r.upload
+	if uint64(file.data.Len()) != file.size {
+		r.rejectUpload(session, "file is smaller than announced")
+		return
+	}
+	if util.Sha256Checksum(file.data.Bytes()) != file.checksum {
+		r.rejectUpload(session, "checksum mismatch")
+		return
+	}
+
+	if err := session.blobs.Put(file.id, file.data.Bytes()); err != nil {
+		log.Logger.Error("Failed to store file %s: %v", file.id, err)
+		r.rejectUpload(session, "failed to store the file")
+		return
+	}
+	session.storage.StoreAttachment(&types.Attachment{
+		Id:       file.id,
+		Name:     file.name,
+		Size:     file.size,
+		Checksum: file.checksum,
+		Uploader: r.conn.participant.Username,
+	})
+	r.upload = nil
+
+	session.sendMsg(types.BuildSysMsg(
+		types.BuildControlFrame(types.FrameUploadDone, file.id, file.id)+
+			util.Fmtln("{server: %s} Uploaded %s as %s", util.TimeNowStr(), file.name, file.id),
+		r.conn.ipAddr,
+	))
+
+	// The file's name is used as message's contents, so it can be found with :search.
+	msg := types.BuildChatMsg([]byte(file.name), r.conn.participant.Username, r.conn.channel.Name)
+	msg.AttachmentId = file.id
+	r.postMessage(session, msg)
+}
+
+// Streams the file to the participant in chunks, the client verifies the checksum.
+func (r *readerFSM) downloadFile(session *session, id string) {
+	attachment := session.storage.GetAttachment(id)
+	if attachment == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} File %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	data, err := session.blobs.Get(id)
+	if err != nil {
+		log.Logger.Error("Failed to read file %s: %v", id, err)
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to read file %s", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	r.sendFile(session, id, attachment.Name, attachment.Checksum, data)
+}
+
+// Used for the files which aren't stored in the blob store as well, the id only has to be unique within the connection.
+func (r *readerFSM) sendFile(session *session, id string, name string, checksum string, data []byte) {
+	session.sendMsg(types.BuildSysMsg(types.BuildControlFrame(
+		types.FrameDownloadStart, id, url.QueryEscape(name), strconv.Itoa(len(data)), checksum,
+	), r.conn.ipAddr))
+
+	for offset := 0; offset < len(data); offset += downloadChunkSize {
+		chunk := data[offset:min(offset+downloadChunkSize, len(data))]
+		session.sendMsg(types.BuildSysMsg(
+			types.BuildControlFrame(types.FrameDownloadChunk, id, base64.StdEncoding.EncodeToString(chunk)), r.conn.ipAddr,
+		))
+	}
+
+	session.sendMsg(types.BuildSysMsg(types.BuildControlFrame(types.FrameDownloadEnd, id), r.conn.ipAddr))
+}
@@ -0,0 +1,183 @@
+package session
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/isnastish/chat/pkg/ratelimit"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Violations are forgotten if the participant doesn't exceed the limits for that long.
+const violationResetTimeout = time.Minute
+
+type RateLimit struct {
+	// Messages per second, zero disables the limit.
+	Rate float64 `yaml:"rate"`
+	// Number of messages which can be sent in a row before the rate applies.
+	Burst int `yaml:"burst"`
+}
+
+// Every message (or command) has to pass both the participant's and the ip address's limits.
+// Each time a limit is exceeded counts as a violation, the first ones are warned about and the message is dropped,
+// then the messages are throttled, then the participant is muted, and eventually disconnected.
+type FloodProtection struct {
+	Participant RateLimit `yaml:"participant"`
+	Address     RateLimit `yaml:"address"`
+	// Number of violations after which the participant is throttled, muted and disconnected respectively,
+	// zero disables the step.
+	ThrottleAfter   int `yaml:"throttleAfter"`
+	MuteAfter       int `yaml:"muteAfter"`
+	DisconnectAfter int `yaml:"disconnectAfter"`
+	// How long a participant stays muted.
+	MuteDuration time.Duration `yaml:"muteDuration"`
+}
+
+type floodGuard struct {
+	policy       FloodProtection
+	participants *ratelimit.Limiter
+	addresses    *ratelimit.Limiter
+	// Muted participants (or ip addresses if not authenticated) and when they can post again.
+	mutes map[string]time.Time
+	mu    sync.Mutex
+}
+
+func newFloodGuard(policy FloodProtection) *floodGuard {
+	return &floodGuard{
+		policy:       policy,
+		participants: ratelimit.NewLimiter(policy.Participant.Rate, policy.Participant.Burst),
+		addresses:    ratelimit.NewLimiter(policy.Address.Rate, policy.Address.Burst),
+		mutes:        make(map[string]time.Time),
+	}
+}
+
+func (g *floodGuard) mute(key string, until time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.mutes[key] = until
+}
+
+// Returns the remaining time the key is muted for.
+func (g *floodGuard) muted(key string, now time.Time) time.Duration {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	until, exists := g.mutes[key]
+	if !exists {
+		return 0
+	}
+	if !now.Before(until) {
+		delete(g.mutes, key)
+		return 0
+	}
+	return until.Sub(now)
+}
+
+// Connections from the same host share the limit, regardless of the port.
+func addressHost(ipAddr string) string {
+	if host, _, err := net.SplitHostPort(ipAddr); err == nil {
+		return host
+	}
+	return ipAddr
+}
+
+// Participants are limited by their username once authenticated, so all their devices share the limit.
+func (r *readerFSM) floodKey() string {
+	if r.conn.matchState(connectedState) {
+		return r.conn.participant.Username
+	}
+	return addressHost(r.conn.ipAddr)
+}
+
+func (r *readerFSM) allowMessage(session *session, now time.Time) bool {
+	allowed := session.flood.addresses.Allow(addressHost(r.conn.ipAddr), now)
+	if allowed && r.conn.matchState(connectedState) {
+		allowed = session.flood.participants.Allow(r.conn.participant.Username, now)
+	}
+	return allowed
+}
+
+// Chunks of an upload in progress aren't counted against the rate limits, since the client sends them
+// one at a time after the previous one is acknowledged, and the upload is limited by its announced size.
+func (r *readerFSM) uploadingChunk() bool {
+	name, args, isFrame := types.ParseControlFrame(r.buffer.String())
+	return isFrame && name == types.FrameUploadChunk && r.upload != nil && len(args) > 0 && args[0] == r.upload.id
+}
+
+// Returns false if the message read from the connection should be dropped,
+// either because it's too large or because the participant is flooding.
+func (r *readerFSM) admitMessage(session *session) bool {
+	if matchState(r.state, stateDisconnecting) {
+		return true
+	}
+
+	if r.oversized {
+		r.oversized = false
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Message exceeds the maximum size of %d bytes, discarded", util.TimeNowStr(), session.config.MaxMessageSize),
+			r.conn.ipAddr,
+		))
+		return false
+	}
+
+	now := time.Now()
+	if remaining := session.flood.muted(r.floodKey(), now); remaining > 0 {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} You are muted for %v", util.TimeNowStr(), remaining.Round(time.Second)), r.conn.ipAddr,
+		))
+		return false
+	}
+
+	if r.uploadingChunk() || r.allowMessage(session, now) {
+		return true
+	}
+
+	if now.Sub(r.lastViolation) > violationResetTimeout {
+		r.violations = 0
+	}
+	r.violations++
+	r.lastViolation = now
+
+	policy := session.flood.policy
+	switch {
+	case policy.DisconnectAfter > 0 && r.violations >= policy.DisconnectAfter:
+		// Written directly, because the connection is closed right after.
+		util.WriteBytes(r.conn.netConn, bytes.NewBufferString(
+			util.Fmtln("{server: %s} Too many messages, disconnecting...", util.TimeNowStr()),
+		))
+		// Participants disconnected by the session are not allowed to resume.
+		r.revokeResumeToken(session)
+		r.updateState(stateDisconnecting)
+		return false
+
+	case policy.MuteAfter > 0 && r.violations >= policy.MuteAfter:
+		session.flood.mute(r.floodKey(), now.Add(policy.MuteDuration))
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many messages, you are muted for %v", util.TimeNowStr(), policy.MuteDuration),
+			r.conn.ipAddr,
+		))
+		return false
+
+	case policy.ThrottleAfter > 0 && r.violations >= policy.ThrottleAfter:
+		// The message is delivered once the limit allows it, meanwhile nothing is read from the connection.
+		delay := session.flood.addresses.Delay(addressHost(r.conn.ipAddr), now)
+		if r.conn.matchState(connectedState) {
+			delay = max(delay, session.flood.participants.Delay(r.conn.participant.Username, now))
+		}
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many messages, your messages are delayed", util.TimeNowStr()), r.conn.ipAddr,
+		))
+		time.Sleep(delay)
+		r.allowMessage(session, time.Now())
+		return true
+
+	default:
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many messages, slow down. The message was dropped", util.TimeNowStr()), r.conn.ipAddr,
+		))
+		return false
+	}
+}
@@ -0,0 +1,101 @@
+package session
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestFloodEscalation(t *testing.T) {
+	s := newTestSession(Config{Flood: FloodProtection{
+		Address:         RateLimit{Rate: 0.001, Burst: 1},
+		MuteAfter:       2,
+		DisconnectAfter: 3,
+		MuteDuration:    time.Minute,
+	}})
+	reader, remote := newTestReader(t, "")
+	go io.Copy(io.Discard, remote)
+
+	assert.True(t, reader.admitMessage(s))
+
+	// Warned
+	assert.False(t, reader.admitMessage(s))
+	assert.Equal(t, 1, reader.violations)
+
+	// Muted, messages are dropped without counting as violations
+	assert.False(t, reader.admitMessage(s))
+	assert.False(t, reader.admitMessage(s))
+	assert.Equal(t, 2, reader.violations)
+
+	delete(s.flood.mutes, reader.floodKey())
+	assert.False(t, reader.admitMessage(s))
+	assert.True(t, matchState(reader.state, stateDisconnecting))
+}
+
+func TestOversizedMessage(t *testing.T) {
+	s := newTestSession(Config{MaxMessageSize: 100})
+	reader, remote := newTestReader(t, "")
+	go remote.Write(bytes.Repeat([]byte("a"), 2000))
+
+	reader.read(s)
+	assert.Equal(t, 0, reader.buffer.Len())
+	assert.False(t, reader.admitMessage(s))
+
+	// The next message is read as usual
+	go remote.Write([]byte("hello"))
+	reader.read(s)
+	assert.Equal(t, "hello", reader.buffer.String())
+	assert.True(t, reader.admitMessage(s))
+}
+
+func TestFloodControlFrames(t *testing.T) {
+	s := newTestSession(Config{Flood: FloodProtection{
+		Address:      RateLimit{Rate: 0.001, Burst: 1},
+		MuteAfter:    1,
+		MuteDuration: time.Minute,
+	}})
+	reader, remote := newTestReader(t, "")
+	go io.Copy(io.Discard, remote)
+
+	typing := types.BuildControlFrame(types.FrameTyping)
+	reader.buffer = bytes.NewBufferString(typing)
+	assert.True(t, reader.consumeInput(s))
+	assert.Equal(t, 0, reader.violations)
+
+	// Frames are limited the same way as messages
+	reader.buffer = bytes.NewBufferString(typing)
+	assert.True(t, reader.consumeInput(s))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "you are muted")
+	reader.buffer = bytes.NewBufferString(typing)
+	assert.True(t, reader.consumeInput(s))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "You are muted")
+
+	// Except for the chunks of an upload in progress, which are paced by the acknowledgements
+	delete(s.flood.mutes, reader.floodKey())
+	reader.upload = &upload{id: "upload"}
+	reader.buffer = bytes.NewBufferString(types.BuildControlFrame(types.FrameUploadChunk, "upload", "AAAA"))
+	assert.True(t, reader.admitMessage(s))
+	reader.buffer = bytes.NewBufferString(types.BuildControlFrame(types.FrameUploadChunk, "another", "AAAA"))
+	assert.False(t, reader.admitMessage(s))
+}
+
+func TestOversizedFrame(t *testing.T) {
+	s := newTestSession(Config{MaxMessageSize: 100})
+	reader, remote := newTestReader(t, "")
+
+	// Frames may exceed the maximum message size, but not the read buffer
+	go remote.Write([]byte(types.BuildControlFrame(types.FrameTyping, strings.Repeat("a", 500))))
+	reader.read(s)
+	assert.NotEqual(t, 0, reader.buffer.Len())
+
+	go remote.Write([]byte(types.BuildControlFrame(types.FrameTyping, strings.Repeat("a", 2000))))
+	reader.read(s)
+	assert.Equal(t, 0, reader.buffer.Len())
+	assert.False(t, reader.admitMessage(s))
+}
@@ -0,0 +1,63 @@
+package session
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/isnastish/chat/pkg/backend/memory"
+	"github.com/isnastish/chat/pkg/blobstore"
+	"github.com/isnastish/chat/pkg/mailer"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/validation"
+)
+
+// Registered in every test session, unless the test registers its own participants.
+var testParticipant = types.Participant{Username: "AliceCooper", Password: "Secret#12345", Email: "alice@gmail.com"}
+
+func newTestPolicy() *validation.Policy {
+	config := validation.DefaultConfig()
+	return validation.NewPolicy(&config)
+}
+
+// A session backed by memory, without a listener, whose system messages are buffered so the tests can read them.
+// Unless set, login attempts are limited to 5 and forgotten after a minute.
+// The emails are discarded, tests which read them replace the mailer.
+func newTestSession(config Config, participants ...*types.Participant) *session {
+	if config.Login == (LoginPolicy{}) {
+		config.Login = LoginPolicy{MaxAttempts: 5, ResetAfter: time.Minute}
+	}
+	s := &session{
+		config:       config,
+		connMap:      newConnectionMap(),
+		sysMessages:  make(chan *types.SysMessage, 16),
+		storage:      memory.NewMemoryBackend(),
+		blobs:        blobstore.NewMemoryStore(),
+		mailer:       mailer.NewWriterMailer(io.Discard, "chat@example.com"),
+		resumeTokens: newResumeTable(),
+		presences:    newPresenceTable(),
+		flood:        newFloodGuard(config.Flood),
+		policy:       newTestPolicy(),
+	}
+
+	if len(participants) == 0 {
+		participant := testParticipant
+		participants = append(participants, &participant)
+	}
+	for _, participant := range participants {
+		s.storage.RegisterParticipant(participant)
+	}
+	return s
+}
+
+// A reader of one end of a pipe, logged in as the given participant unless the username is empty.
+// Writes to the connection block until they're read from the returned end.
+func newTestReader(t *testing.T, username string) (*readerFSM, net.Conn) {
+	local, remote := net.Pipe()
+	t.Cleanup(func() { local.Close(); remote.Close() })
+
+	reader := newReader(newConn(local, time.Minute, 0))
+	reader.conn.participant.Username = username
+	return reader, remote
+}
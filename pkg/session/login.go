@@ -0,0 +1,130 @@
+package session
+
+import (
+	"time"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Failed login attempts are tracked per username and per ip address.
+// Every failure delays the next attempt exponentially, and once MaxAttempts is reached
+// the username or the address is locked out. The attempts are persisted in the backend,
+// so they survive session restarts and are shared between sessions using the same backend.
+type LoginPolicy struct {
+	// Failed attempts after which a username or an address is locked out, zero disables lockouts.
+	MaxAttempts int `yaml:"maxAttempts"`
+	// Delay after the first failed attempt, doubled with every subsequent failure up to MaxDelay.
+	BaseDelay time.Duration `yaml:"baseDelay"`
+	MaxDelay  time.Duration `yaml:"maxDelay"`
+	// How long a lockout lasts.
+	LockoutDuration time.Duration `yaml:"lockoutDuration"`
+	// Failed attempts are forgotten after that long without failures.
+	ResetAfter time.Duration `yaml:"resetAfter"`
+}
+
+// Names differing only in case or confusable characters refer to the same participant, and share the attempts.
+func usernameAttemptsKey(username string) string {
+	return "username:" + canonical.Key(username)
+}
+
+func addressAttemptsKey(ipAddr string) string {
+	return "address:" + addressHost(ipAddr)
+}
+
+// Delay before the next attempt after the given number of failures.
+func (p LoginPolicy) backoff(failures int) time.Duration {
+	if failures <= 0 || p.BaseDelay <= 0 {
+		return 0
+	}
+	delay := p.BaseDelay
+	for i := 1; i < failures && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	return min(delay, p.MaxDelay)
+}
+
+// Returns how long the key has to wait before the next login attempt.
+func (s *session) loginDelay(key string, now time.Time) time.Duration {
+	attempts := s.storage.GetLoginAttempts(key)
+	if attempts == nil {
+		return 0
+	}
+	if now.Before(attempts.LockedUntil) {
+		return attempts.LockedUntil.Sub(now)
+	}
+	if next := attempts.LastFailure.Add(s.config.Login.backoff(attempts.Failures)); now.Before(next) {
+		return next.Sub(now)
+	}
+	return 0
+}
+
+// Returns true if the failure caused the key to be locked out.
+func (s *session) recordLoginFailure(key string, now time.Time) bool {
+	policy := s.config.Login
+	attempts := s.storage.GetLoginAttempts(key)
+	if attempts == nil {
+		attempts = &types.LoginAttempts{}
+	}
+
+	attempts.Failures++
+	attempts.LastFailure = now
+
+	locked := policy.MaxAttempts > 0 && attempts.Failures >= policy.MaxAttempts
+	if locked {
+		attempts.Failures = 0
+		attempts.LockedUntil = now.Add(policy.LockoutDuration)
+	}
+
+	s.storage.SetLoginAttempts(key, attempts, max(policy.ResetAfter, policy.LockoutDuration))
+	return locked
+}
+
+// Error messages are the same whether the username exists or not, and whether the password was wrong
+// or the participant has to wait, so they don't reveal anything about the account.
+func (r *readerFSM) authenticate(session *session) bool {
+	username := r.conn.participant.Username
+	keys := []string{usernameAttemptsKey(username), addressAttemptsKey(r.conn.ipAddr)}
+
+	now := time.Now()
+	var delay time.Duration
+	for _, key := range keys {
+		delay = max(delay, session.loginDelay(key, now))
+	}
+	if delay > 0 {
+		log.Audit.Event("login-rejected", map[string]string{"username": username, "address": r.conn.ipAddr, "retryAfter": delay.String()})
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many failed attempts, try again in %v", util.TimeNowStr(), delay.Round(time.Second)), r.conn.ipAddr,
+		))
+		return false
+	}
+
+	// The credentials aren't checked against the validation policy, it might have changed since the participant registered.
+	if session.storage.AuthParticipant(r.conn.participant) {
+		// The name might have been typed in a different case, the one the participant registered with is displayed.
+		if participant := session.storage.GetParticipant(username); participant != nil {
+			r.conn.participant.Username = participant.Username
+		}
+		// Otherwise the password alone would reset the delays of guessing one-time codes.
+		if !session.hasSecondFactor(r.conn.participant.Username) {
+			session.storage.DeleteLoginAttempts(keys[0])
+		}
+		return true
+	}
+
+	log.Audit.Event("login-failed", map[string]string{"username": username, "address": r.conn.ipAddr})
+	for _, key := range keys {
+		if session.recordLoginFailure(key, now) {
+			log.Audit.Event("lockout", map[string]string{
+				"key": key, "username": username, "address": r.conn.ipAddr, "duration": session.config.Login.LockoutDuration.String(),
+			})
+		}
+	}
+
+	session.sendMsg(types.BuildSysMsg(
+		util.Fmtln("{server: %s} Failed to authenticate. Username or password is incorrect.", util.TimeNowStr()), r.conn.ipAddr,
+	))
+	return false
+}
@@ -0,0 +1,76 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestLoginBackoff(t *testing.T) {
+	policy := LoginPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	assert.Equal(t, time.Duration(0), policy.backoff(0))
+	assert.Equal(t, time.Second, policy.backoff(1))
+	assert.Equal(t, 4*time.Second, policy.backoff(3))
+	assert.Equal(t, 10*time.Second, policy.backoff(10))
+}
+
+func TestLoginLockout(t *testing.T) {
+	s := newTestSession(Config{Login: LoginPolicy{MaxAttempts: 2, LockoutDuration: time.Minute, ResetAfter: time.Minute}})
+	reader, _ := newTestReader(t, "AliceCooper")
+
+	reader.conn.participant.Password = "Wrong#123456"
+	assert.False(t, reader.authenticate(s))
+	failed := (<-s.sysMessages).Contents.String()
+	assert.NotContains(t, failed, "Wrong#123456")
+
+	// Nonexistent usernames get the same response
+	reader.conn.participant.Username = "NobodyHere"
+	assert.False(t, reader.authenticate(s))
+	assert.Equal(t, failed[len(failed)-40:], (<-s.sysMessages).Contents.String()[len(failed)-40:])
+
+	// Both failures came from the same address, which is locked out now
+	reader.conn.participant.Username = "AliceCooper"
+	reader.conn.participant.Password = "Secret#12345"
+	assert.False(t, reader.authenticate(s))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Too many failed attempts")
+
+	s.storage.DeleteLoginAttempts(addressAttemptsKey(reader.conn.ipAddr))
+	assert.True(t, reader.authenticate(s))
+	assert.True(t, s.storage.GetLoginAttempts(usernameAttemptsKey("AliceCooper")) == nil)
+}
+
+func TestLoginNameCase(t *testing.T) {
+	s := newTestSession(Config{})
+	reader, _ := newTestReader(t, "")
+
+	// Failures are counted for the participant regardless of how the name was typed
+	reader.conn.participant.Username = "ALICECOOPER"
+	reader.conn.participant.Password = "Wrong#123456"
+	assert.False(t, reader.authenticate(s))
+	<-s.sysMessages
+	assert.Equal(t, 1, s.storage.GetLoginAttempts(usernameAttemptsKey("AliceCooper")).Failures)
+
+	reader.conn.participant.Username = "alicecooper"
+	reader.conn.participant.Password = "Secret#12345"
+	assert.True(t, reader.authenticate(s))
+	assert.Equal(t, "AliceCooper", reader.conn.participant.Username)
+}
+
+func TestLoginNameCaseSecondFactor(t *testing.T) {
+	s := newTestSession(Config{})
+	s.storage.SetTwoFactor("AliceCooper", &types.TwoFactor{Secret: "JBSWY3DPEHPK3PXP", Enabled: true})
+	reader, _ := newTestReader(t, "")
+
+	reader.conn.participant.Username = "alicecooper"
+	reader.conn.participant.Password = "Wrong#123456"
+	assert.False(t, reader.authenticate(s))
+	<-s.sysMessages
+
+	// The failures are kept until the second factor is verified, however the name was typed
+	reader.conn.participant.Password = "Secret#12345"
+	assert.True(t, reader.authenticate(s))
+	assert.Equal(t, 1, s.storage.GetLoginAttempts(usernameAttemptsKey("AliceCooper")).Failures)
+}
@@ -0,0 +1,86 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+func channelHistory(session *session, channelname string) []*types.ChatMessage {
+	if channelname == "" {
+		return session.storage.GetChatHistory()
+	}
+	return session.storage.GetChatHistory(channelname)
+}
+
+// Returns the index of the first message after the read marker, or the length of the history if all were read.
+func firstUnread(history []*types.ChatMessage, marker uint64) int {
+	for index, msg := range history {
+		if msg.Seq > marker {
+			return index
+		}
+	}
+	return len(history)
+}
+
+// Participant's own messages and deleted messages are not counted.
+func countUnread(history []*types.ChatMessage, marker uint64, username string) int {
+	var count int
+	for _, msg := range history[firstUnread(history, marker):] {
+		if msg.Sender != username && !msg.Deleted {
+			count++
+		}
+	}
+	return count
+}
+
+// Moves participant's read marker to the last message in the channel.
+func markAsRead(session *session, username string, channelname string) {
+	if history := channelHistory(session, channelname); len(history) > 0 {
+		session.storage.SetReadMarker(username, channelname, history[len(history)-1].Seq)
+	}
+}
+
+// Displays the history of the channel the participant is in starting from the first unread message,
+// the messages which were already read are only counted. The whole history is marked as read afterwards.
+// Returns the displayed messages.
+func (r *readerFSM) displayChatHistory(session *session) []*types.ChatMessage {
+	channelname := r.conn.channel.Name
+	history := channelHistory(session, channelname)
+	if len(history) == 0 {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Empty chat history", util.TimeNowStr()), r.conn.ipAddr))
+		return nil
+	}
+
+	username := r.conn.participant.Username
+	first := firstUnread(history, session.storage.GetReadMarkers(username)[channelname])
+
+	var builder strings.Builder
+	if first > 0 {
+		builder.WriteString(util.Fmtln("{server: %s} %d earlier message(s), use :history to display them", util.TimeNowStr(), first))
+	}
+	if first == len(history) {
+		builder.WriteString(util.Fmtln("{server: %s} No unread messages", util.TimeNowStr()))
+	} else {
+		builder.WriteString(buildChatHistory(history[first:]))
+	}
+	session.sendMsg(types.BuildSysMsg(builder.String(), r.conn.ipAddr))
+
+	session.storage.SetReadMarker(username, channelname, history[len(history)-1].Seq)
+	return history[first:]
+}
+
+func (r *readerFSM) markRead(session *session, channelname string) {
+	if channelname != "" {
+		channel := session.findChannel(channelname)
+		if channel == nil {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Channel %s doesn't exist", util.TimeNowStr(), channelname), r.conn.ipAddr))
+			return
+		}
+		channelname = channel.Name
+	}
+
+	markAsRead(session, r.conn.participant.Username, channelname)
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Marked %s as read", util.TimeNowStr(), channelLabel(channelname)), r.conn.ipAddr))
+}
@@ -0,0 +1,26 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestCountUnread(t *testing.T) {
+	history := []*types.ChatMessage{
+		{Sender: "alice", Seq: 1},
+		{Sender: "bob", Seq: 2},
+		{Sender: "alice", Seq: 3},
+		{Sender: "bob", Seq: 4, Deleted: true},
+		{Sender: "bob", Seq: 5},
+	}
+
+	assert.Equal(t, 1, firstUnread(history, 1))
+	assert.Equal(t, len(history), firstUnread(history, 5))
+	// Own and deleted messages are not counted
+	assert.Equal(t, 2, countUnread(history, 0, "alice"))
+	assert.Equal(t, 1, countUnread(history, 3, "alice"))
+	assert.Equal(t, 0, countUnread(history, 5, "alice"))
+}
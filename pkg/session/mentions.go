@@ -0,0 +1,85 @@
+package session
+
+import (
+	"regexp"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Makes mention notifications stand out in a terminal (bold yellow).
+const (
+	highlightStart = "\x1b[1;33m"
+	highlightEnd   = "\x1b[0m"
+)
+
+var mentionRe = regexp.MustCompile(`@([\p{L}\p{M}\p{Nd}_]+)`)
+
+// Returns the usernames mentioned in the contents in the order of their first appearance.
+// The usernames are not validated, since that requires a look up in the backend.
+func parseMentions(contents string) []string {
+	var mentions []string
+	seen := make(map[string]bool)
+	for _, match := range mentionRe.FindAllStringSubmatch(contents, -1) {
+		if username := match[1]; !seen[username] {
+			seen[username] = true
+			mentions = append(mentions, username)
+		}
+	}
+	return mentions
+}
+
+func highlight(text string) string {
+	return highlightStart + text + highlightEnd
+}
+
+// Notifies every registered participant mentioned in the message on all their devices allowed to read it.
+// Returns the mentioned participants who are offline, so the message can be queued for them.
+func (r *readerFSM) notifyMentions(session *session, msg *types.ChatMessage) []string {
+	if msg.Deleted {
+		return nil
+	}
+
+	var offline []string
+	for _, mention := range parseMentions(msg.Contents.String()) {
+		participant := session.storage.GetParticipant(mention)
+		if participant == nil || canonical.Equal(participant.Username, msg.Sender) {
+			continue
+		}
+		// Mentions don't have to match the case of the username.
+		username := participant.Username
+
+		if !session.connMap.hasConnectedParticipant(username) {
+			offline = append(offline, username)
+			continue
+		}
+
+		// The participant will see the message in the chat history, but isn't notified.
+		if session.presences.get(username).state == presenceDoNotDisturb {
+			continue
+		}
+
+		location := "general chat"
+		if msg.Channel != "" {
+			location = "channel " + msg.Channel
+		}
+		notification := util.Fmtln(highlight(util.Fmt("{server: %s} %s mentioned you in %s", util.TimeNowStr(), msg.Sender, location))) +
+			formatChatMessage(msg)
+
+		// Devices logged in with an API token might not be allowed to read the channel.
+		for _, ipAddr := range session.connMap.readingDevices(username, msg.Channel) {
+			session.sendMsg(types.BuildSysMsg(notification, ipAddr))
+		}
+	}
+	return offline
+}
+
+func isMentioned(msg *types.ChatMessage, username string) bool {
+	for _, mention := range parseMentions(msg.Contents.String()) {
+		if canonical.Equal(mention, username) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,12 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMentions(t *testing.T) {
+	assert.Equal(t, []string{"MarkLutz", "nasayer"}, parseMentions("@MarkLutz, have you read @nasayer's book? @MarkLutz"))
+	assert.Equal(t, []string(nil), parseMentions("No mentions @ all"))
+}
@@ -0,0 +1,213 @@
+package session
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"github.com/isnastish/chat/pkg/backend"
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/commands"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Moderators listed in session's config can moderate the general chat and all the channels,
+// while channel's creator can only moderate its own channel.
+func (s *session) isModerator(username string, channelname string) bool {
+	for _, moderator := range s.config.Moderators {
+		if canonical.Equal(moderator, username) {
+			return true
+		}
+	}
+
+	if channel := s.findChannel(channelname); channel != nil {
+		return canonical.Equal(channel.Creator, username)
+	}
+	return false
+}
+
+// Returns nil if the channel doesn't exist.
+func (s *session) findChannel(channelname string) *types.Channel {
+	if channelname != "" {
+		for _, channel := range s.storage.GetChannels() {
+			if canonical.Equal(channel.Name, channelname) {
+				return channel
+			}
+		}
+	}
+	return nil
+}
+
+// Returns the message if the participant is allowed to modify it,
+// otherwise notifies the participant and returns nil.
+// Messages can be modified by their authors and moderators.
+func (r *readerFSM) getModifiableMessage(session *session, id string) *types.ChatMessage {
+	msg := session.storage.GetMessage(id)
+	if msg == nil || msg.Deleted {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Message %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+		return nil
+	}
+
+	if !canonical.Equal(msg.Sender, r.conn.participant.Username) && !session.isModerator(r.conn.participant.Username, msg.Channel) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Not allowed to modify message %s", util.TimeNowStr(), id), r.conn.ipAddr))
+		return nil
+	}
+	return msg
+}
+
+// Builds a notification about the message, which is only delivered to the participants
+// in message's channel who are allowed to read it.
+func buildMessageSysMsg(msg *types.ChatMessage, contents string) *types.SysMessage {
+	sysMsg := types.BuildSysMsg(contents)
+	sysMsg.Channel = msg.Channel
+	sysMsg.Scoped = true
+	return sysMsg
+}
+
+func (r *readerFSM) editMessage(session *session, id string, text string) {
+	msg := r.getModifiableMessage(session, id)
+	if msg == nil {
+		return
+	}
+
+	editTime := util.TimeNowStr()
+	if !session.storage.EditMessage(id, bytes.NewBufferString(text), editTime) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to edit message %s", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	// Notify the participants who can see the message, so they can see the new contents.
+	session.sendMsg(buildMessageSysMsg(msg,
+		types.BuildControlFrame(types.FrameMessageEdited, id)+
+			util.Fmtln("{server: %s} %s edited message [%s]: %s", editTime, r.conn.participant.Username, id, text),
+	))
+}
+
+func (r *readerFSM) deleteMessage(session *session, id string) {
+	msg := r.getModifiableMessage(session, id)
+	if msg == nil {
+		return
+	}
+
+	if !session.storage.DeleteMessage(id) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to delete message %s", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	session.sendMsg(buildMessageSysMsg(msg,
+		types.BuildControlFrame(types.FrameMessageDeleted, id)+
+			util.Fmtln("{server: %s} %s deleted message [%s]", util.TimeNowStr(), r.conn.participant.Username, id),
+	))
+}
+
+func (r *readerFSM) replyToMessage(session *session, id string, text string) {
+	parent := session.storage.GetMessage(id)
+	if parent == nil || parent.Deleted {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Message %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	// Threads are flat, replying to a reply adds a message to the same thread.
+	if parent.ParentId != "" {
+		parent = session.storage.GetMessage(parent.ParentId)
+		if parent == nil {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Thread of message %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+			return
+		}
+	}
+
+	// The reply belongs to the same channel as the message which started the thread.
+	msg := types.BuildChatMsg([]byte(text), r.conn.participant.Username, parent.Channel)
+	msg.ParentId = parent.Id
+
+	r.postMessage(session, msg)
+}
+
+func (r *readerFSM) displayThread(session *session, id string) {
+	parent := session.storage.GetMessage(id)
+	if parent == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Message %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	if parent.ParentId != "" {
+		parent = session.storage.GetMessage(parent.ParentId)
+		if parent == nil {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Thread of message %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+			return
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString(formatChatMessage(parent))
+	for _, reply := range session.storage.GetReplies(parent.Id) {
+		builder.WriteString("\t" + formatChatMessage(reply))
+	}
+	session.sendMsg(types.BuildSysMsg(builder.String(), r.conn.ipAddr))
+}
+
+func (r *readerFSM) reactToMessage(session *session, id string, emoji string) {
+	if !session.storage.AddReaction(id, r.conn.participant.Username, emoji) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to react to message %s", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+	r.broadcastReaction(session, id, emoji, "reacted "+emoji+" to")
+}
+
+func (r *readerFSM) unreactToMessage(session *session, id string, emoji string) {
+	if !session.storage.RemoveReaction(id, r.conn.participant.Username, emoji) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to remove reaction from message %s", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+	r.broadcastReaction(session, id, emoji, "removed reaction "+emoji+" from")
+}
+
+// Notifies the participants in message's channel about the new count of the reaction.
+func (r *readerFSM) broadcastReaction(session *session, id string, emoji string, action string) {
+	msg := session.storage.GetMessage(id)
+	if msg == nil {
+		return
+	}
+
+	session.sendMsg(buildMessageSysMsg(msg,
+		types.BuildControlFrame(types.FrameReaction, id, emoji, strconv.FormatUint(msg.Reactions[emoji], 10))+
+			util.Fmtln("{server: %s} %s %s message [%s]", util.TimeNowStr(), r.conn.participant.Username, action, id),
+	))
+}
+
+// Number of search results displayed at once.
+const searchPageSize = 10
+
+func (r *readerFSM) searchMessages(session *session, result *commands.ParseResult) {
+	page := max(result.Page, 1)
+	query := &types.SearchQuery{
+		Terms:   backend.Tokenize(result.Args[0]),
+		Channel: result.Channel,
+		Sender:  result.From,
+		Since:   result.Since,
+		// Results are limited to the channels the API token, if any, is allowed to read.
+		Channels: r.conn.scopes.channels(scopeRead),
+		Offset:   int(page-1) * searchPageSize,
+		Limit:    searchPageSize,
+	}
+
+	messages, total := session.storage.SearchMessages(query)
+	if total == 0 {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} No messages found", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	pages := (total + searchPageSize - 1) / searchPageSize
+	if len(messages) == 0 {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Page %d is out of range, %d page(s) in total", util.TimeNowStr(), page, pages), r.conn.ipAddr))
+		return
+	}
+
+	var builder strings.Builder
+	builder.WriteString(util.Fmtln("{server: %s} Found %d message(s), page %d of %d", util.TimeNowStr(), total, page, pages))
+	for _, msg := range messages {
+		builder.WriteString(util.Fmt("\t#%s ", channelLabel(msg.Channel)) + formatChatMessage(msg))
+	}
+	session.sendMsg(types.BuildSysMsg(builder.String(), r.conn.ipAddr))
+}
@@ -0,0 +1,32 @@
+package session
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestModerationNameCase(t *testing.T) {
+	bob := types.Participant{Username: "BobMarley", Password: "Secret#12345", Email: "bob@gmail.com"}
+	alice := testParticipant
+	s := newTestSession(Config{Moderators: []string{"alicecooper"}}, &alice, &bob)
+	s.storage.RegisterChannel(&types.Channel{Name: "bookshelf", Creator: "BobMarley"})
+
+	assert.True(t, s.isModerator("AliceCooper", ""))
+	assert.True(t, s.isModerator("BOBMARLEY", "bookshelf"))
+	assert.False(t, s.isModerator("BobMarley", ""))
+
+	msg := &types.ChatMessage{Id: "first", Sender: "BobMarley", Contents: bytes.NewBufferString("hello")}
+	s.storage.StoreMessage(msg)
+	assert.Equal(t, 1, len(s.authoredMessages("bobmarley")))
+
+	// Authors are recognized however their name is typed
+	author, _ := newTestReader(t, "bobmarley")
+	assert.True(t, author.getModifiableMessage(s, msg.Id) != nil)
+	other, _ := newTestReader(t, "CharlieSheen")
+	assert.True(t, other.getModifiableMessage(s, msg.Id) == nil)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Not allowed to modify message first")
+}
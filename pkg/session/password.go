@@ -0,0 +1,159 @@
+package session
+
+import (
+	"time"
+
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Length of a password reset token in bytes, it's sent hex encoded.
+const resetTokenLength = 16
+
+// Purpose of the one-time codes used as password reset tokens.
+const resetPurpose = "reset"
+
+// One-time codes are stored hashed, prefixed with their purpose, so a code issued
+// for one purpose can't be redeemed for another.
+func oneTimeCodeKey(purpose string, code string) string {
+	return purpose + ":" + util.Sha256Checksum([]byte(code))
+}
+
+// Confirms a sensitive action of an authenticated participant with the current password.
+// Wrong passwords count as failed login attempts, and are written to the audit log as the event.
+func (r *readerFSM) checkPassword(session *session, password string, event string) bool {
+	username := r.conn.participant.Username
+	key := usernameAttemptsKey(username)
+
+	now := time.Now()
+	if delay := session.loginDelay(key, now); delay > 0 {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many failed attempts, try again in %v", util.TimeNowStr(), delay.Round(time.Second)), r.conn.ipAddr,
+		))
+		return false
+	}
+
+	if !session.storage.AuthParticipant(&types.Participant{Username: username, Password: password}) {
+		log.Audit.Event(event, map[string]string{"username": username, "address": r.conn.ipAddr})
+		if session.recordLoginFailure(key, now) {
+			log.Audit.Event("lockout", map[string]string{"key": key, "username": username, "address": r.conn.ipAddr})
+		}
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Current password is incorrect", util.TimeNowStr()), r.conn.ipAddr))
+		return false
+	}
+	return true
+}
+
+// Arguments: current and new password.
+func (r *readerFSM) changePassword(session *session, args []string) {
+	username := r.conn.participant.Username
+	if !r.checkPassword(session, args[0], "password-change-failed") {
+		return
+	}
+
+	if reasons := session.policy.CheckPassword(args[1]); reasons != nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} New password not valid: %s", util.TimeNowStr(), formatReasons(reasons)), r.conn.ipAddr))
+		return
+	}
+
+	session.storage.UpdatePassword(username, args[1])
+	log.Audit.Event("password-changed", map[string]string{"username": username, "address": r.conn.ipAddr})
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Password changed", util.TimeNowStr()), r.conn.ipAddr))
+}
+
+// The response is the same whether the participant exists or not, and whether the token was sent or not.
+func (r *readerFSM) requestPasswordReset(session *session, username string) {
+	if participant := session.storage.GetParticipant(username); participant != nil && participant.Email != "" {
+		r.sendResetToken(session, participant)
+	}
+
+	log.Audit.Event("password-reset-requested", map[string]string{"username": username, "address": r.conn.ipAddr})
+	session.sendMsg(types.BuildSysMsg(
+		util.Fmtln("{server: %s} If the account exists, a reset token was sent to its email address", util.TimeNowStr()), r.conn.ipAddr,
+	))
+}
+
+// A new token is sent at most once per ResetRequestInterval, and it invalidates the one sent before.
+func (r *readerFSM) sendResetToken(session *session, participant *types.Participant) {
+	now := time.Now()
+	sent := session.storage.GetSentCode(participant.Username, resetPurpose)
+	if sent != nil && now.Sub(sent.SentTime) < session.config.ResetRequestInterval {
+		log.Audit.Event("password-reset-throttled", map[string]string{"username": participant.Username, "address": r.conn.ipAddr})
+		return
+	}
+	if sent != nil {
+		session.storage.RedeemOneTimeCode(sent.Code)
+	}
+
+	token := util.RandomHex(resetTokenLength)
+	timeout := session.config.ResetTokenTimeout
+	code := oneTimeCodeKey(resetPurpose, token)
+	session.storage.StoreOneTimeCode(code, participant.Username, timeout)
+	// Kept as long as the token is valid, so it can still be invalidated by the next one.
+	session.storage.SetSentCode(participant.Username, resetPurpose, &types.SentCode{Code: code, SentTime: now}, max(timeout, session.config.ResetRequestInterval))
+
+	body := util.Fmt("A password reset was requested for %s.\n"+
+		"Choose \"%s\" in the chat menu and enter the token below, it expires in %v.\n\n%s\n\n"+
+		"If you didn't request the reset, ignore this email.", participant.Username, optionTable[opRedeemResetToken], timeout, token)
+	if err := session.mailer.Send(participant.Email, "Password reset", body); err != nil {
+		log.Logger.Error("Failed to send a password reset email to %s: %v", participant.Username, err)
+	}
+}
+
+// Redeems the token read before, and sets the new password.
+func (r *readerFSM) resetPassword(session *session, token string, password string) {
+	if reasons := session.policy.CheckPassword(password); reasons != nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Password not valid: %s", util.TimeNowStr(), formatReasons(reasons)), r.conn.ipAddr))
+		return
+	}
+
+	// Guessing tokens counts as failed login attempts of the address.
+	key := addressAttemptsKey(r.conn.ipAddr)
+	now := time.Now()
+	if delay := session.loginDelay(key, now); delay > 0 {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many failed attempts, try again in %v", util.TimeNowStr(), delay.Round(time.Second)), r.conn.ipAddr,
+		))
+		return
+	}
+
+	username, redeemed := session.storage.RedeemOneTimeCode(oneTimeCodeKey(resetPurpose, token))
+	if !redeemed || !session.storage.UpdatePassword(username, password) {
+		log.Audit.Event("password-reset-failed", map[string]string{"address": r.conn.ipAddr})
+		if session.recordLoginFailure(key, now) {
+			log.Audit.Event("lockout", map[string]string{"key": key, "address": r.conn.ipAddr})
+		}
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Token is invalid or expired", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	// The participant proved the ownership of the account, so the lockout is lifted.
+	session.storage.DeleteLoginAttempts(usernameAttemptsKey(username))
+	log.Audit.Event("password-reset", map[string]string{"username": username, "address": r.conn.ipAddr})
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Password has been reset, you can log in now", util.TimeNowStr()), r.conn.ipAddr))
+}
+
+func onPasswordResetState(reader *readerFSM, session *session) {
+	if !matchState(reader.state, stateRequestingReset) && !matchState(reader.state, stateResettingPassword) {
+		log.Logger.Panic(
+			"Invalid state %s, expected states: %s, %s",
+			stateTable[reader.state], stateTable[stateRequestingReset], stateTable[stateResettingPassword])
+	}
+
+	switch {
+	case matchState(reader.state, stateRequestingReset):
+		reader.requestPasswordReset(session, reader.buffer.String())
+		reader.updateState(stateJoining)
+
+	case matchSubstate(reader.substate, substateReadingToken):
+		reader.resetToken = reader.buffer.String()
+		session.sendMsg(types.BuildSysMsg(util.Fmt("{server: %s} enter new password: ", util.TimeNowStr()), reader.conn.ipAddr))
+		reader.updateState(reader.state, substateReadingPassword)
+
+	case matchSubstate(reader.substate, substateReadingPassword):
+		reader.resetPassword(session, reader.resetToken, reader.buffer.String())
+		reader.resetToken = ""
+		reader.updateState(stateJoining)
+	}
+}
@@ -0,0 +1,74 @@
+package session
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/mailer"
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestPasswordReset(t *testing.T) {
+	var mail bytes.Buffer
+	s := newTestSession(Config{ResetTokenTimeout: time.Minute, ResetRequestInterval: time.Minute})
+	s.mailer = mailer.NewWriterMailer(&mail, "chat@example.com")
+	reader, _ := newTestReader(t, "")
+
+	// Nothing is sent for unknown participants, but the response is the same
+	reader.requestPasswordReset(s, "NobodyHere")
+	unknown := (<-s.sysMessages).Contents.String()
+	assert.Equal(t, 0, mail.Len())
+
+	reader.requestPasswordReset(s, "AliceCooper")
+	assert.Equal(t, unknown[len(unknown)-60:], (<-s.sysMessages).Contents.String()[len(unknown)-60:])
+	assert.Contains(t, mail.String(), "To: alice@gmail.com")
+	token := regexp.MustCompile(`[0-9a-f]{32}`).FindString(mail.String())
+
+	// Another token isn't sent until the interval passes, however the name is typed
+	mail.Reset()
+	reader.requestPasswordReset(s, "alicecooper")
+	<-s.sysMessages
+	assert.Equal(t, 0, mail.Len())
+
+	// The new token invalidates the previous one
+	sent := s.storage.GetSentCode("AliceCooper", resetPurpose)
+	sent.SentTime = sent.SentTime.Add(-time.Minute)
+	s.storage.SetSentCode("AliceCooper", resetPurpose, sent, time.Minute)
+	reader.requestPasswordReset(s, "AliceCooper")
+	<-s.sysMessages
+	previous := token
+	token = regexp.MustCompile(`[0-9a-f]{32}`).FindString(mail.String())
+	assert.NotEqual(t, previous, token)
+	reader.resetPassword(s, previous, "Changed#12345")
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "invalid or expired")
+
+	reader.resetPassword(s, "0123456789abcdef0123456789abcdef", "Changed#12345")
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "invalid or expired")
+
+	reader.resetPassword(s, token, "Changed#12345")
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Password has been reset")
+	assert.True(t, s.storage.AuthParticipant(&types.Participant{Username: "AliceCooper", Password: "Changed#12345"}))
+
+	// Tokens can only be used once
+	reader.resetPassword(s, token, "Another#12345")
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "invalid or expired")
+}
+
+func TestChangePassword(t *testing.T) {
+	s := newTestSession(Config{})
+	reader, _ := newTestReader(t, "AliceCooper")
+
+	reader.changePassword(s, []string{"Wrong#123456", "Changed#12345"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Current password is incorrect")
+
+	reader.changePassword(s, []string{"Secret#12345", "short"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "New password not valid: must be at least 12 characters long")
+
+	reader.changePassword(s, []string{"Secret#12345", "Changed#12345"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Password changed")
+	assert.True(t, s.storage.AuthParticipant(&types.Participant{Username: "AliceCooper", Password: "Changed#12345"}))
+}
@@ -0,0 +1,71 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Pins or unpins a message in the channel it belongs to.
+// Only moderators of the channel are allowed to do that.
+func (r *readerFSM) pinMessage(session *session, id string, pin bool) {
+	msg := session.storage.GetMessage(id)
+	if msg == nil || msg.Deleted {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Message %s not found", util.TimeNowStr(), id), r.conn.ipAddr))
+		return
+	}
+
+	if msg.Channel == "" {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Only messages in channels can be pinned", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	if !session.isModerator(r.conn.participant.Username, msg.Channel) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Not allowed to pin messages in channel %s", util.TimeNowStr(), msg.Channel), r.conn.ipAddr))
+		return
+	}
+
+	var succeeded bool
+	var action string
+	if pin {
+		succeeded, action = session.storage.PinMessage(msg.Channel, id), "pinned"
+	} else {
+		succeeded, action = session.storage.UnpinMessage(msg.Channel, id), "unpinned"
+	}
+	if !succeeded {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Message %s is already %s", util.TimeNowStr(), id, action), r.conn.ipAddr))
+		return
+	}
+
+	session.sendMsg(buildMessageSysMsg(msg, util.Fmtln("{server: %s} %s %s message [%s]", util.TimeNowStr(), r.conn.participant.Username, action, id)))
+}
+
+// Returns an empty string if nothing is pinned in the channel.
+func buildPinsList(session *session, channel *types.Channel) string {
+	var builder strings.Builder
+	for _, id := range channel.Pins {
+		// Pinned messages might have been removed since.
+		if msg := session.storage.GetMessage(id); msg != nil && !msg.Deleted {
+			builder.WriteString("\t" + formatChatMessage(msg))
+		}
+	}
+	if builder.Len() == 0 {
+		return ""
+	}
+	return "pinned:\n" + builder.String()
+}
+
+func (r *readerFSM) displayPins(session *session) {
+	channel := session.findChannel(r.conn.channel.Name)
+	if channel == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Select a channel to display its pinned messages", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	if pins := buildPinsList(session, channel); pins != "" {
+		session.sendMsg(types.BuildSysMsg(pins, r.conn.ipAddr))
+		return
+	}
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} No pinned messages in channel %s", util.TimeNowStr(), channel.Name), r.conn.ipAddr))
+}
@@ -0,0 +1,123 @@
+package session
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+type presenceState int8
+
+const (
+	presenceOnline presenceState = iota
+	presenceAway
+	presenceDoNotDisturb
+)
+
+var presenceStateTable = []string{
+	presenceOnline:       "online",
+	presenceAway:         "away",
+	presenceDoNotDisturb: "dnd",
+}
+
+// Presence set by the participant with :status, kept for the lifetime of the session.
+// A participant who is online becomes away once all of its devices are idle.
+type presence struct {
+	state presenceState
+	text  string
+}
+
+type presenceTable struct {
+	entries map[string]presence
+	mu      sync.Mutex
+}
+
+func newPresenceTable() *presenceTable {
+	return &presenceTable{
+		entries: make(map[string]presence),
+	}
+}
+
+func (t *presenceTable) set(username string, state presenceState, text string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if state == presenceOnline && text == "" {
+		delete(t.entries, username)
+		return
+	}
+	t.entries[username] = presence{state: state, text: text}
+}
+
+func (t *presenceTable) remove(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, username)
+}
+
+func (t *presenceTable) get(username string) presence {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.entries[username]
+}
+
+func parsePresenceState(value string) (presenceState, bool) {
+	for state, name := range presenceStateTable {
+		if strings.ToLower(value) == name {
+			return presenceState(state), true
+		}
+	}
+	return presenceOnline, false
+}
+
+// Presence of a connected participant, taking into account whether its devices are idle.
+func (s *session) participantPresence(username string) presence {
+	p := s.presences.get(username)
+	if p.state == presenceOnline && s.connMap.isParticipantAway(username) {
+		p.state = presenceAway
+	}
+	return p
+}
+
+func formatPresence(p presence) string {
+	if p.text != "" {
+		return util.Fmt("%s (%s)", presenceStateTable[p.state], p.text)
+	}
+	return presenceStateTable[p.state]
+}
+
+// Arguments: online, away or dnd, optionally followed by the status text.
+func (r *readerFSM) setStatus(session *session, args []string) {
+	state, ok := parsePresenceState(args[0])
+	if !ok {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Status %s not supported, expected online, away or dnd", util.TimeNowStr(), args[0]), r.conn.ipAddr,
+		))
+		return
+	}
+
+	var text string
+	if len(args) > 1 {
+		text = args[1]
+	}
+
+	session.presences.set(r.conn.participant.Username, state, text)
+	session.sendMsg(types.BuildSysMsg(
+		util.Fmtln("{server: %s} Status set to %s", util.TimeNowStr(), formatPresence(presence{state: state, text: text})), r.conn.ipAddr,
+	))
+}
+
+// Typing events are ephemeral, they are neither stored nor queued,
+// and only delivered to the other participants in the sender's current channel.
+func (r *readerFSM) broadcastTyping(session *session) {
+	if !r.conn.matchState(connectedState) {
+		return
+	}
+
+	frame := types.BuildControlFrame(types.FrameTyping, r.conn.participant.Username)
+	for _, ipAddr := range session.connMap.channelDevices(r.conn.channel.Name, r.conn.participant.Username) {
+		session.sendMsg(types.BuildSysMsg(frame, ipAddr))
+	}
+}
@@ -0,0 +1,29 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPresenceTable(t *testing.T) {
+	presences := newPresenceTable()
+	assert.Equal(t, presence{}, presences.get("alice"))
+
+	presences.set("alice", presenceDoNotDisturb, "in a meeting")
+	assert.Equal(t, "dnd (in a meeting)", formatPresence(presences.get("alice")))
+
+	// Going back online without a status text removes the entry
+	presences.set("alice", presenceOnline, "")
+	assert.Equal(t, 0, len(presences.entries))
+
+	presences.set("alice", presenceAway, "")
+	presences.remove("alice")
+	assert.Equal(t, 0, len(presences.entries))
+
+	state, ok := parsePresenceState("AWAY")
+	assert.True(t, ok)
+	assert.Equal(t, presenceAway, state)
+	_, ok = parsePresenceState("busy")
+	assert.False(t, ok)
+}
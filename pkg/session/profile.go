@@ -0,0 +1,132 @@
+package session
+
+import (
+	"strings"
+	"time"
+
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+	"github.com/isnastish/chat/pkg/validation"
+)
+
+// Fields which can be changed with :profile set.
+const (
+	profileName     = "name"
+	profileBio      = "bio"
+	profileTimezone = "timezone"
+)
+
+// Arguments: the action (only set is supported), the field and its value.
+// An empty value clears the field.
+func (r *readerFSM) setProfile(session *session, args []string) {
+	if strings.ToLower(args[0]) != "set" {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Unknown action %s, expected set", util.TimeNowStr(), args[0]), r.conn.ipAddr))
+		return
+	}
+
+	value := ""
+	if len(args) > 2 {
+		value = strings.TrimSpace(args[2])
+	}
+
+	username := r.conn.participant.Username
+	participant := session.storage.GetParticipant(username)
+	if participant == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to update the profile", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	field := strings.ToLower(args[1])
+	switch field {
+	case profileName:
+		if value != "" && !validation.ValidateDisplayName(value) {
+			session.sendMsg(types.BuildSysMsg(
+				util.Fmtln("{server: %s} Display name should be at most 32 printable characters", util.TimeNowStr()), r.conn.ipAddr,
+			))
+			return
+		}
+		participant.DisplayName = value
+
+	case profileBio:
+		if !validation.ValidateBio(value) {
+			session.sendMsg(types.BuildSysMsg(
+				util.Fmtln("{server: %s} Bio should be at most 160 printable characters", util.TimeNowStr()), r.conn.ipAddr,
+			))
+			return
+		}
+		participant.Bio = value
+
+	case profileTimezone:
+		if value != "" && !validation.ValidateTimezone(value) {
+			session.sendMsg(types.BuildSysMsg(
+				util.Fmtln("{server: %s} Unknown time zone %s, expected a name like Europe/Berlin", util.TimeNowStr(), value), r.conn.ipAddr,
+			))
+			return
+		}
+		participant.Timezone = value
+
+	default:
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Unknown field %s, expected %s, %s or %s", util.TimeNowStr(), args[1], profileName, profileBio, profileTimezone), r.conn.ipAddr,
+		))
+		return
+	}
+
+	session.storage.UpdateProfile(username, participant.DisplayName, participant.Bio, participant.Timezone)
+	log.Audit.Event("profile-updated", map[string]string{"username": username, "address": r.conn.ipAddr, "field": field})
+
+	if value == "" {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Profile %s cleared", util.TimeNowStr(), field), r.conn.ipAddr))
+		return
+	}
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Profile %s set to %s", util.TimeNowStr(), field, value), r.conn.ipAddr))
+}
+
+func (r *readerFSM) whois(session *session, username string) {
+	participant := session.storage.GetParticipant(username)
+	if participant == nil {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Participant %s not found", util.TimeNowStr(), username), r.conn.ipAddr))
+		return
+	}
+	session.sendMsg(types.BuildSysMsg(buildProfile(session, participant, time.Now()), r.conn.ipAddr))
+}
+
+func buildProfile(session *session, participant *types.Participant, now time.Time) string {
+	var builder strings.Builder
+	builder.WriteString(util.Fmtln("whois %s:", participant.Username))
+	if participant.DisplayName != "" {
+		builder.WriteString(util.Fmtln("\tname: %s", participant.DisplayName))
+	}
+	if participant.Bio != "" {
+		builder.WriteString(util.Fmtln("\tbio: %s", participant.Bio))
+	}
+	if participant.Timezone != "" {
+		if location, err := time.LoadLocation(participant.Timezone); err == nil {
+			builder.WriteString(util.Fmtln("\ttimezone: %s, local time %s", participant.Timezone, now.In(location).Format("15:04")))
+		}
+	}
+	builder.WriteString(util.Fmtln("\tregistered: %s", formatProfileTime(participant.Registered)))
+	if session.connMap.hasConnectedParticipant(participant.Username) {
+		builder.WriteString(util.Fmtln("\tlast seen: now, %s", formatPresence(session.participantPresence(participant.Username))))
+	} else {
+		builder.WriteString(util.Fmtln("\tlast seen: %s", formatProfileTime(participant.LastSeen)))
+	}
+	return builder.String()
+}
+
+// Participants registered before the times were recorded have them unset.
+func formatProfileTime(t time.Time) string {
+	if t.IsZero() {
+		return "unknown"
+	}
+	return t.Format(time.DateTime)
+}
+
+// Username followed by the display name, if the participant has set one.
+func memberName(member *types.Participant) string {
+	if member.DisplayName != "" {
+		return util.Fmt("%s (%s)", member.Username, member.DisplayName)
+	}
+	return member.Username
+}
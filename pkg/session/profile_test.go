@@ -0,0 +1,53 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestProfile(t *testing.T) {
+	registered := time.Date(2024, time.May, 1, 12, 0, 0, 0, time.Local)
+	alice := testParticipant
+	alice.Registered = registered
+	alice.LastSeen = registered
+	s := newTestSession(Config{}, &alice)
+	reader, _ := newTestReader(t, "AliceCooper")
+
+	reader.setProfile(s, []string{"set", "name", "Alice Cooper"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Profile name set to Alice Cooper")
+	reader.setProfile(s, []string{"set", "bio", "Singer and songwriter"})
+	<-s.sysMessages
+	reader.setProfile(s, []string{"set", "timezone", "Mars/Olympus"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Unknown time zone Mars/Olympus")
+	reader.setProfile(s, []string{"set", "timezone", "UTC"})
+	<-s.sysMessages
+	reader.setProfile(s, []string{"set", "email", "alice@yahoo.com"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Unknown field email")
+
+	participant := s.storage.GetParticipant("AliceCooper")
+	assert.Equal(t, "Alice Cooper", participant.DisplayName)
+	assert.Equal(t, "Singer and songwriter", participant.Bio)
+	assert.Equal(t, "UTC", participant.Timezone)
+
+	now := time.Date(2024, time.June, 1, 9, 30, 0, 0, time.UTC)
+	profile := buildProfile(s, participant, now)
+	assert.Contains(t, profile, "name: Alice Cooper")
+	assert.Contains(t, profile, "bio: Singer and songwriter")
+	assert.Contains(t, profile, "timezone: UTC, local time 09:30")
+	assert.Contains(t, profile, "registered: 2024-05-01 12:00:00")
+	assert.Contains(t, profile, "last seen: 2024-05-01 12:00:00")
+
+	members := buildMembersList(s, s.storage.GetParticipants())
+	assert.Contains(t, members, "AliceCooper (Alice Cooper)")
+	assert.Contains(t, members, "offline, last seen 2024-05-01 12:00:00")
+
+	// An empty value clears the field
+	reader.setProfile(s, []string{"set", "name"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Profile name cleared")
+	assert.Equal(t, "", s.storage.GetParticipant("AliceCooper").DisplayName)
+
+	reader.whois(s, "nonexistent")
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Participant nonexistent not found")
+}
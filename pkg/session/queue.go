@@ -0,0 +1,63 @@
+package session
+
+import (
+	"strings"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Queues the message for the offline participants who should see it at their next login:
+// the mentioned ones and the members of the channel the message was posted in.
+// Direct messages should be queued here as well once they are supported.
+func (s *session) queueForOfflineParticipants(msg *types.ChatMessage, mentioned []string) {
+	recipients := mentioned
+	if msg.Channel != "" {
+		if channel := s.findChannel(msg.Channel); channel != nil {
+			recipients = append(recipients, channel.Members...)
+		}
+	}
+
+	queued := make(map[string]bool)
+	for _, username := range recipients {
+		if canonical.Equal(username, msg.Sender) || queued[canonical.Key(username)] || s.connMap.hasConnectedParticipant(username) {
+			continue
+		}
+		queued[canonical.Key(username)] = true
+		s.storage.EnqueueMessage(username, msg.Id, s.config.OfflineQueueSize)
+	}
+}
+
+// Delivers the messages queued while the participant was offline in the order they were sent, and clears the queue.
+// Messages the chat history has just displayed are not repeated.
+func (r *readerFSM) displayQueuedMessages(session *session, displayed []*types.ChatMessage) {
+	username := r.conn.participant.Username
+	queued := session.storage.GetQueuedMessages(username, session.config.OfflineQueueTimeout)
+	session.storage.DeleteQueuedMessages(username)
+
+	skip := make(map[string]bool, len(displayed))
+	for _, msg := range displayed {
+		skip[msg.Id] = true
+	}
+	var messages []*types.ChatMessage
+	for _, msg := range queued {
+		if !skip[msg.Id] {
+			messages = append(messages, msg)
+		}
+	}
+	if len(messages) == 0 {
+		return
+	}
+
+	var builder strings.Builder
+	builder.WriteString(util.Fmtln(highlight(util.Fmt("{server: %s} %d message(s) arrived while you were away", util.TimeNowStr(), len(messages)))))
+	for _, msg := range messages {
+		builder.WriteString(util.Fmt("\t#%s ", channelLabel(msg.Channel)))
+		if isMentioned(msg, username) {
+			builder.WriteString(highlight("@") + " ")
+		}
+		builder.WriteString(formatChatMessage(msg))
+	}
+	session.sendMsg(types.BuildSysMsg(builder.String(), r.conn.ipAddr))
+}
@@ -0,0 +1,33 @@
+package session
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestQueuedMessagesAfterHistory(t *testing.T) {
+	bob := types.Participant{Username: "BobMarley", Password: "Secret#12345", Email: "bob@gmail.com"}
+	alice := testParticipant
+	s := newTestSession(Config{}, &alice, &bob)
+	s.storage.RegisterChannel(&types.Channel{Name: "bookshelf", Creator: "BobMarley"})
+	s.storage.AddChannelMember("bookshelf", "AliceCooper")
+
+	mention := types.BuildChatMsg([]byte("@AliceCooper hello"), "BobMarley")
+	s.storage.StoreMessage(mention)
+	s.queueForOfflineParticipants(mention, []string{"AliceCooper"})
+	posted := types.BuildChatMsg([]byte("new arrivals"), "BobMarley", "bookshelf")
+	s.storage.StoreMessage(posted)
+	s.queueForOfflineParticipants(posted, nil)
+
+	// The mention is displayed in the general chat's history, thus only the channel's message is left in the queue
+	reader, _ := newTestReader(t, "AliceCooper")
+	reader.displayQueuedMessages(s, reader.displayChatHistory(s))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "@AliceCooper hello")
+	queued := (<-s.sysMessages).Contents.String()
+	assert.Contains(t, queued, "1 message(s) arrived while you were away")
+	assert.Contains(t, queued, "new arrivals")
+	assert.Equal(t, 0, len(s.storage.GetQueuedMessages("AliceCooper", 0)))
+}
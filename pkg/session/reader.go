@@ -18,6 +18,7 @@ import (
 	"math/rand"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/isnastish/chat/pkg/commands"
 	"github.com/isnastish/chat/pkg/logger"
@@ -26,6 +27,12 @@ import (
 	"github.com/isnastish/chat/pkg/validation"
 )
 
+// Size of a single read from the connection, unless the maximum message size is larger.
+const minReadSize = 1024
+
+// How long to wait for the rest of an oversized message before reading the next one.
+const discardTimeout = 100 * time.Millisecond
+
 type readerState int8
 type readerSubstate int8
 type option int8
@@ -37,6 +44,8 @@ const (
 	opSelectChannel
 	opListMembers
 	opDisplayChat
+	opRequestReset
+	opRedeemResetToken
 	opExit
 )
 
@@ -49,6 +58,8 @@ const (
 	stateCreatingChannel
 	stateSelectingChannel
 	stateProcessingMenu
+	stateRequestingReset
+	stateResettingPassword
 	stateDisconnecting
 
 	// This state should be the last
@@ -61,6 +72,8 @@ const (
 	substateReadingPassword
 	substateReadingEmailAddress
 	substateReadingChannnelDesc
+	substateReadingToken
+	substateReadingOneTimeCode
 	// substate_Reading        readerSubstate = 0x5
 )
 
@@ -72,6 +85,21 @@ type readerFSM struct {
 
 	buffer *bytes.Buffer
 
+	// A channel being created, becomes participant's current channel once registered.
+	newChannel *types.Channel
+
+	// A file being uploaded, nil if there is no upload in progress.
+	upload *upload
+
+	// Password reset token, kept until the new password is read.
+	resetToken string
+
+	// Set when the last message exceeded the maximum size.
+	oversized bool
+	// Number of times the rate limits were exceeded, see FloodProtection.
+	violations    int
+	lastViolation time.Time
+
 	// Set to true if in development mode.
 	// This allows to disable paticipant's data submission process
 	// and jump straight to exchaning the messages.
@@ -118,6 +146,8 @@ func init() {
 	stateTable[stateCreatingChannel] = "StateCreatingChannel"
 	stateTable[stateSelectingChannel] = "StateSelectingChannel"
 	stateTable[stateProcessingMenu] = "StateProcessingMenu"
+	stateTable[stateRequestingReset] = "StateRequestingReset"
+	stateTable[stateResettingPassword] = "StateResettingPassword"
 	stateTable[stateDisconnecting] = "StateDisconnecting"
 
 	// init transition table
@@ -129,6 +159,8 @@ func init() {
 	transitionTable[stateCreatingChannel] = onCreateChannelState
 	transitionTable[stateSelectingChannel] = onSelectChannelState
 	transitionTable[stateProcessingMenu] = onJoiningState // the same callback as for stateJoining
+	transitionTable[stateRequestingReset] = onPasswordResetState
+	transitionTable[stateResettingPassword] = onPasswordResetState
 	transitionTable[stateDisconnecting] = onDisconnectState
 
 	// options table
@@ -139,6 +171,8 @@ func init() {
 	optionTable[opSelectChannel] = "select channels"
 	optionTable[opListMembers] = "list members"
 	optionTable[opDisplayChat] = "display chat history"
+	optionTable[opRequestReset] = "forgot password"
+	optionTable[opRedeemResetToken] = "reset password with a token"
 	optionTable[opExit] = "exit"
 
 	// Build options string so it can be used as a body of a message
@@ -167,11 +201,24 @@ func (r *readerFSM) read(session *session) {
 	// net.Conn.Read() method accepts the bytes of non-zero length,
 	// thus creating a bytes.Buffer{} and passing it as buffer.Bytes() wouldn't work.
 	// The subsequent operation on the buffer.Len() would return 1024 instead of an actual amount of bytes read from a connection.
-	buffer := make([]byte, 1024)
+	// The buffer is one byte larger than the maximum message size, so oversized messages can be detected.
+	// It's never smaller than minReadSize, since control frames carrying file chunks may exceed the maximum message size,
+	// but they're still limited by the size of the buffer.
+	buffer := make([]byte, max(session.config.MaxMessageSize, minReadSize)+1)
 	bytesRead, err := r.conn.netConn.Read(buffer)
 	trimmedBuffer := util.TrimWhitespaces(buffer[:bytesRead])
 	r.buffer = bytes.NewBuffer(trimmedBuffer)
 
+	limit := session.config.MaxMessageSize
+	if bytes.HasPrefix(trimmedBuffer, []byte(types.ControlFramePrefix)) {
+		limit = len(buffer) - 1
+	}
+	if limit > 0 && bytesRead > limit {
+		r.oversized = true
+		r.buffer.Reset()
+		r.discardPending()
+	}
+
 	if err != nil && err != io.EOF {
 		select {
 		case <-r.conn.ctx.Done():
@@ -182,6 +229,9 @@ func (r *readerFSM) read(session *session) {
 				types.BuildSysMsg(util.Fmt("You were idle for too long, disconnecting..."), r.conn.ipAddr),
 			)
 
+			// Participants disconnected by the session are not allowed to resume.
+			r.revokeResumeToken(session)
+
 			// Wait 2 seconds before disconnecting the participant.
 			util.Sleep(2000)
 
@@ -203,6 +253,56 @@ func (r *readerFSM) read(session *session) {
 	}
 }
 
+// Drops the rest of an oversized message, which arrives in the subsequent reads.
+func (r *readerFSM) discardPending() {
+	buffer := make([]byte, minReadSize)
+	for {
+		r.conn.netConn.SetReadDeadline(time.Now().Add(discardTimeout))
+		if _, err := r.conn.netConn.Read(buffer); err != nil {
+			break
+		}
+	}
+	r.conn.netConn.SetReadDeadline(time.Time{})
+}
+
+// Returns true if the buffer was dropped by the flood protection, or processed as a control frame or a command,
+// so it shouldn't be handled by the current state.
+func (r *readerFSM) consumeInput(session *session) bool {
+	return !r.admitMessage(session) || r.processFrame(session) || r.processCommand(session)
+}
+
+// Returns true if the buffer contained a control frame, so it shouldn't be treated as a command or a message.
+func (r *readerFSM) processFrame(session *session) bool {
+	name, args, isFrame := types.ParseControlFrame(r.buffer.String())
+	if !isFrame {
+		return false
+	}
+
+	switch name {
+	case types.FrameResume:
+		r.resumeSession(session, args)
+
+	case types.FrameLogin:
+		r.loginWithToken(session, args)
+
+	case types.FrameTyping:
+		r.broadcastTyping(session)
+
+	case types.FrameUploadStart:
+		r.startUpload(session, args)
+
+	case types.FrameUploadChunk:
+		r.receiveChunk(session, args)
+
+	case types.FrameUploadEnd:
+		r.finishUpload(session, args)
+
+	default:
+		log.Logger.Warn("Unknown control frame %s received from %s", name, r.conn.ipAddr)
+	}
+	return true
+}
+
 func (r *readerFSM) processCommand(session *session) bool {
 	// Don't process commands while in a joining or processing menu state
 	if matchState(r.state, stateJoining) || matchState(r.state, stateProcessingMenu) {
@@ -216,6 +316,10 @@ func (r *readerFSM) processCommand(session *session) bool {
 	}
 
 	if result.Matched {
+		if !r.commandAllowed(session, result) {
+			return true
+		}
+
 		switch result.CommandType {
 		case commands.CommandDisplayMenu:
 			r.updateState(stateProcessingMenu)
@@ -246,7 +350,7 @@ func (r *readerFSM) processCommand(session *session) bool {
 		case commands.CommandListChannels:
 			if r.conn.matchState(connectedState) {
 				if channels := session.storage.GetChannels(); len(channels) > 0 {
-					session.sendMsg(types.BuildSysMsg(buildChannelList(channels), r.conn.ipAddr))
+					session.sendMsg(types.BuildSysMsg(buildChannelList(session, r.conn.participant.Username, channels), r.conn.ipAddr))
 				} else {
 					session.sendMsg(types.BuildSysMsg(util.Fmtln("Empty channel list"), r.conn.ipAddr))
 				}
@@ -257,6 +361,181 @@ func (r *readerFSM) processCommand(session *session) bool {
 
 		case commands.CommandListCommands:
 			session.sendMsg(types.BuildSysMsg(commands.CommandsBuilder.String(), r.conn.ipAddr))
+
+		case commands.CommandEditMessage:
+			if r.conn.matchState(connectedState) {
+				r.editMessage(session, result.Args[0], result.Args[1])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandDeleteMessage:
+			if r.conn.matchState(connectedState) {
+				r.deleteMessage(session, result.Args[0])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandReplyMessage:
+			if r.conn.matchState(connectedState) {
+				if !r.restricted(session, session.config.Verification.Posting, "post messages") {
+					r.replyToMessage(session, result.Args[0], result.Args[1])
+				}
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandDisplayThread:
+			if r.conn.matchState(connectedState) {
+				r.displayThread(session, result.Args[0])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandReactMessage:
+			if r.conn.matchState(connectedState) {
+				r.reactToMessage(session, result.Args[0], result.Args[1])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandUnreactMessage:
+			if r.conn.matchState(connectedState) {
+				r.unreactToMessage(session, result.Args[0], result.Args[1])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandMarkRead:
+			if r.conn.matchState(connectedState) {
+				channelname := r.conn.channel.Name
+				if result.Channel != "" {
+					channelname = result.Channel
+				}
+				r.markRead(session, channelname)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandPinMessage, commands.CommandUnpinMessage:
+			if r.conn.matchState(connectedState) {
+				r.pinMessage(session, result.Args[0], result.CommandType == commands.CommandPinMessage)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandListPins:
+			if r.conn.matchState(connectedState) {
+				r.displayPins(session)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandSetTopic:
+			if r.conn.matchState(connectedState) {
+				r.setTopic(session, result.Args[0])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandRenameChannel:
+			if r.conn.matchState(connectedState) {
+				r.renameChannel(session, result.Args[0])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandSearchMessages:
+			if r.conn.matchState(connectedState) {
+				r.searchMessages(session, result)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandChangePassword:
+			if r.conn.matchState(connectedState) {
+				r.changePassword(session, result.Args)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandVerifyEmail:
+			if r.conn.matchState(connectedState) {
+				r.verifyEmail(session, result.Args[0])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandResendCode:
+			if r.conn.matchState(connectedState) {
+				if r.isVerified(session) {
+					session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Email address already verified", util.TimeNowStr()), r.conn.ipAddr))
+				} else {
+					r.sendVerificationCode(session)
+				}
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandDeleteAccount:
+			if r.conn.matchState(connectedState) {
+				r.deleteAccount(session, result.Args)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandExportData:
+			if r.conn.matchState(connectedState) {
+				r.exportData(session)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandSetProfile:
+			if r.conn.matchState(connectedState) {
+				r.setProfile(session, result.Args)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandWhois:
+			if r.conn.matchState(connectedState) {
+				r.whois(session, result.Args[0])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandAPIToken:
+			if r.conn.matchState(connectedState) {
+				r.manageAPITokens(session, result.Args)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandTwoFactor:
+			if r.conn.matchState(connectedState) {
+				r.manageTwoFactor(session, result.Args)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandSetStatus:
+			if r.conn.matchState(connectedState) {
+				r.setStatus(session, result.Args)
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
+
+		case commands.CommandUploadFile:
+			// Uploads are driven by control frames, the client intercepts the command.
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} File uploads require the chat client", util.TimeNowStr()), r.conn.ipAddr))
+
+		case commands.CommandDownloadFile:
+			if r.conn.matchState(connectedState) {
+				r.downloadFile(session, result.Args[0])
+			} else {
+				session.sendMsg(types.BuildSysMsg(util.Fmtln("Authentication required"), r.conn.ipAddr))
+			}
 		}
 		return true
 	}
@@ -297,7 +576,11 @@ func onJoiningState(reader *readerFSM, session *session) {
 
 	case opCreateChannel:
 		if !matchState(reader.state, stateJoining) {
+			if reader.restricted(session, session.config.Verification.CreatingChannels, "create channels") {
+				break
+			}
 			session.sendMsg(types.BuildSysMsg(util.Fmt("{server: %s} enter channel name: ", util.TimeNowStr()), reader.conn.ipAddr))
+			reader.newChannel = &types.Channel{}
 			reader.updateState(stateCreatingChannel, substateReadingName)
 		} else {
 			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Authentication required", util.TimeNowStr()), reader.conn.ipAddr))
@@ -323,7 +606,24 @@ func onJoiningState(reader *readerFSM, session *session) {
 			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Authentication required", util.TimeNowStr()), reader.conn.ipAddr))
 		}
 
+	case opRequestReset:
+		if matchState(reader.state, stateJoining) {
+			session.sendMsg(types.BuildSysMsg(util.Fmt("{server: %s} enter username: ", util.TimeNowStr()), reader.conn.ipAddr))
+			reader.updateState(stateRequestingReset, substateReadingName)
+		} else {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Already authenticated, use :passwd instead", util.TimeNowStr()), reader.conn.ipAddr))
+		}
+
+	case opRedeemResetToken:
+		if matchState(reader.state, stateJoining) {
+			session.sendMsg(types.BuildSysMsg(util.Fmt("{server: %s} enter reset token: ", util.TimeNowStr()), reader.conn.ipAddr))
+			reader.updateState(stateResettingPassword, substateReadingToken)
+		} else {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Already authenticated, use :passwd instead", util.TimeNowStr()), reader.conn.ipAddr))
+		}
+
 	case opExit:
+		reader.revokeResumeToken(session)
 		reader.updateState(stateDisconnecting)
 
 	default:
@@ -372,11 +672,19 @@ func onAuthParticipantState(reader *readerFSM, session *session) {
 		// }
 		// state := transitionTable[reader.state](reader, session)
 		// reader.state = state
-		reader.updateState(reader.state, substateReadingName)
+		reader.updateState(reader.state, substateReadingPassword)
 
 	case substateReadingPassword:
 		reader.conn.participant.Password = reader.buffer.String()
 		validate(reader, session)
+
+	case substateReadingOneTimeCode:
+		if !reader.verifySecondFactor(session, reader.buffer.String()) {
+			reader.updateState(stateJoining)
+			return
+		}
+		reader.completeLogin(session)
+		reader.updateState(stateAcceptingMessages)
 	}
 }
 
@@ -387,13 +695,14 @@ func onCreateChannelState(reader *readerFSM, session *session) {
 
 	switch reader.substate {
 	case substateReadingName:
-		reader.conn.channel.Name = reader.buffer.String()
+		reader.newChannel.Name = reader.buffer.String()
+		reader.newChannel.Creator = reader.conn.participant.Username
 		session.sendMsg(types.BuildSysMsg(util.Fmt("{server: %s} enter description: ", util.TimeNowStr()), reader.conn.ipAddr))
 		reader.updateState(reader.state, substateReadingChannnelDesc)
 
 	case substateReadingChannnelDesc:
 		// TODO: It doesn't really make sense to process channel's description and do the validation of channel's name only after, but let be for now.
-		reader.conn.channel.Desc = reader.buffer.String()
+		reader.newChannel.Desc = reader.buffer.String()
 		validate(reader, session)
 	}
 }
@@ -409,32 +718,32 @@ func validate(reader *readerFSM, session *session) {
 
 	if !matchState(reader.state, stateCreatingChannel) {
 
-		if !validation.ValidateName(reader.conn.participant.Username) {
-			session.sendMsg(
-				types.BuildSysMsg(util.Fmtln("{server: %s} Username %s not valid", util.TimeNowStr(), reader.conn.participant.Username), reader.conn.ipAddr),
-			)
-			reader.updateState(stateJoining)
-			return
-		}
-
-		if !validation.ValidatePassword(reader.conn.participant.Password) {
-			session.sendMsg(
-				types.BuildSysMsg(util.Fmtln("{server: %s} Password {%s} not valid", util.TimeNowStr(), reader.conn.participant.Password), reader.conn.ipAddr),
-			)
-			reader.updateState(stateJoining)
-			return
-		}
-
 		if matchState(reader.state, stateRegistration) {
-			// TODO: Check whether it's a valid email address by sending a message.
-			if !validation.ValidateEmail(reader.conn.participant.Email) {
+			if reasons := session.policy.CheckName(reader.conn.participant.Username); reasons != nil {
+				session.sendMsg(types.BuildSysMsg(
+					util.Fmtln("{server: %s} Username %s not valid: %s", util.TimeNowStr(), reader.conn.participant.Username, formatReasons(reasons)), reader.conn.ipAddr,
+				))
+				reader.updateState(stateJoining)
+				return
+			}
+
+			// The password is never echoed back.
+			if reasons := session.policy.CheckPassword(reader.conn.participant.Password); reasons != nil {
 				session.sendMsg(
-					types.BuildSysMsg(util.Fmtln("{server: %s} Email {%s} not valid", util.TimeNowStr(), reader.conn.participant.Email), reader.conn.ipAddr),
+					types.BuildSysMsg(util.Fmtln("{server: %s} Password not valid: %s", util.TimeNowStr(), formatReasons(reasons)), reader.conn.ipAddr),
 				)
 				reader.updateState(stateJoining)
 				return
 			}
 
+			if reasons := session.policy.CheckEmail(reader.conn.participant.Email); reasons != nil {
+				session.sendMsg(types.BuildSysMsg(
+					util.Fmtln("{server: %s} Email {%s} not valid: %s", util.TimeNowStr(), reader.conn.participant.Email, formatReasons(reasons)), reader.conn.ipAddr,
+				))
+				reader.updateState(stateJoining)
+				return
+			}
+
 			if session.storage.HasParticipant(reader.conn.participant.Username) {
 				session.sendMsg(
 					types.BuildSysMsg(util.Fmtln("{server: %s} Participant %s already exists", util.TimeNowStr(), reader.conn.participant.Username), reader.conn.ipAddr),
@@ -444,66 +753,84 @@ func validate(reader *readerFSM, session *session) {
 			}
 
 			reader.conn.participant.JoinTime = util.TimeNowStr()
+			reader.conn.participant.Registered = time.Now()
+			reader.conn.participant.LastSeen = reader.conn.participant.Registered
 
 			// TODO: Document this function in the architecture manual
 			go reader.conn.disconnectIfIdle()
 
+			// The email address is verified by sending a code to it.
+			reader.conn.participant.Unverified = session.config.Verification.Required
+
 			// Register the participant in a backend storage
 			session.storage.RegisterParticipant(reader.conn.participant)
+			if reader.conn.participant.Unverified {
+				reader.sendVerificationCode(session)
+			}
+
+			reader.issueResumeToken(session)
 
 			// Display chat history to the connected participant
-			reader.displayChatHistory(session)
+			reader.displayQueuedMessages(session, reader.displayChatHistory(session))
 
 			// TODO: Document this thoroughly in the architecture manual
 			session.connMap.markAsConnected(reader.conn.ipAddr)
 
 		} else {
-			if !session.storage.AuthParticipant(reader.conn.participant) {
-				session.sendMsg(
-					types.BuildSysMsg(util.Fmtln("{server: %s} Failed to authenticate participant %s. "+
-						"Username or password is incorrect.", util.TimeNowStr(), reader.conn.participant.Username), reader.conn.ipAddr),
-				)
+			if !reader.authenticate(session) {
 				reader.updateState(stateJoining)
 				return
 			}
 
-			reader.conn.participant.JoinTime = util.TimeNowStr()
-
-			// TODO: Document.
-			go reader.conn.disconnectIfIdle()
-
-			// Display chat history to the connected participant
-			reader.displayChatHistory(session)
+			// The login is completed once the one-time code has been accepted as well.
+			if session.hasSecondFactor(reader.conn.participant.Username) {
+				session.sendMsg(types.BuildSysMsg(util.Fmt("{server: %s} enter one-time code: ", util.TimeNowStr()), reader.conn.ipAddr))
+				reader.updateState(stateAuthentication, substateReadingOneTimeCode)
+				return
+			}
 
-			// TODO: Display chat history
-			session.connMap.markAsConnected(reader.conn.ipAddr)
+			reader.completeLogin(session)
 		}
 
 	} else {
 		// Channel validation
-		if !validation.ValidateName(reader.conn.channel.Name) {
-			session.sendMsg(
-				types.BuildSysMsg(util.Fmtln("{server: %s} Channel name {%s} is invalid", util.TimeNowStr(), reader.conn.channel.Name), reader.conn.ipAddr),
-			)
+		if reasons := session.policy.CheckName(reader.newChannel.Name); reasons != nil {
+			session.sendMsg(types.BuildSysMsg(
+				util.Fmtln("{server: %s} Channel name {%s} is invalid: %s", util.TimeNowStr(), reader.newChannel.Name, formatReasons(reasons)), reader.conn.ipAddr,
+			))
 			reader.updateState(stateProcessingMenu)
 			return
 		}
 
-		if session.storage.HasChannel(reader.conn.channel.Name) {
+		if session.storage.HasChannel(reader.newChannel.Name) {
 			session.sendMsg(
-				types.BuildSysMsg(util.Fmtln("{server: %s} Channel {%s} already exist", util.TimeNowStr(), reader.conn.channel.Name), reader.conn.ipAddr),
+				types.BuildSysMsg(util.Fmtln("{server: %s} Channel {%s} already exist", util.TimeNowStr(), reader.newChannel.Name), reader.conn.ipAddr),
 			)
 			reader.updateState(stateProcessingMenu)
 			return
 		}
 
-		session.storage.RegisterChannel(reader.conn.channel)
+		reader.newChannel.CreationDate = util.TimeNowStr()
+		session.storage.RegisterChannel(reader.newChannel)
+		session.storage.AddChannelMember(reader.newChannel.Name, reader.conn.participant.Username)
+
+		// The participant joins the channel it has created.
+		session.connMap.setChannel(reader.conn.ipAddr, reader.newChannel)
 	}
 
 	// Set the state to accepting messages if either registration/authentication/channel creation went successfully
 	reader.updateState(stateAcceptingMessages)
 }
 
+// The messages of the reasons a name, password or email address was rejected for, in a single line.
+func formatReasons(reasons []validation.Reason) string {
+	messages := make([]string, len(reasons))
+	for i, reason := range reasons {
+		messages[i] = reason.Message
+	}
+	return strings.Join(messages, ", ")
+}
+
 func onSelectChannelState(reader *readerFSM, session *session) {
 	if !matchState(reader.state, stateSelectingChannel) {
 		log.Logger.Panic("Invalid %s state, expected %s", stateTable[reader.state], stateTable[stateSelectingChannel])
@@ -517,17 +844,18 @@ func onSelectChannelState(reader *readerFSM, session *session) {
 	}
 
 	channels := session.storage.GetChannels()
-	if id > 0 && id < len(channels) {
-		// Fine as long as nobody uses the information about the channel rather than the reader itself.
-		// If we, for example, relied on channel's name in broadcastMessages procedure,
-		// the next line would cause race-conditions since we modifying the connection's internal data,
-		// which better be done through the connection map.
-		reader.conn.channel = channels[id]
-		if history := session.storage.GetChatHistory(reader.conn.channel.Name); len(history) > 0 {
-			session.sendMsg(types.BuildSysMsg(buildChatHistory(history), reader.conn.ipAddr))
-		} else {
-			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Empty channel history", util.TimeNowStr()), reader.conn.ipAddr))
+	if id >= 0 && id < len(channels) {
+		// Channel's name is used in broadcastMessages procedure,
+		// so modifying the connection's internal data has to be done through the connection map.
+		session.connMap.setChannel(reader.conn.ipAddr, channels[id])
+		session.storage.AddChannelMember(channels[id].Name, reader.conn.participant.Username)
+		if channels[id].Desc != "" {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("topic: %s", channels[id].Desc), reader.conn.ipAddr))
 		}
+		if pins := buildPinsList(session, channels[id]); pins != "" {
+			session.sendMsg(types.BuildSysMsg(pins, reader.conn.ipAddr))
+		}
+		reader.displayChatHistory(session)
 		reader.updateState(stateAcceptingMessages)
 	} else {
 		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Id %d is out of range", util.TimeNowStr(), id), reader.conn.ipAddr))
@@ -540,12 +868,6 @@ func onAcceptMessagesState(reader *readerFSM, session *session) {
 		log.Logger.Panic("Invalid %s state, expected %s", stateTable[reader.state], stateTable[stateAcceptingMessages])
 	}
 
-	// The session has received a message from the client, thus the timout process
-	// has to be aborted. We send a signal to the abortConnectionTimeout channel which resets.
-	// Since the timer will be reset, we cannot close the channel, because we won't be able to reopen it,
-	// so we have to send a message instead.
-	reader.conn.abortConnectionTimeout <- struct{}{}
-
 	var msg *types.ChatMessage
 	if reader._DEBUG_SkipUserdataProcessing {
 		index := rand.Intn(len(_DEBUG_FakeParticipantTable) - 1)
@@ -554,13 +876,35 @@ func onAcceptMessagesState(reader *readerFSM, session *session) {
 	} else {
 		// If the channel is an empty string, it won't pass the check inside the backend itself.
 		// So it's safe to pass it like this without haveing an if-statement.
+		if reader.restricted(session, session.config.Verification.Posting, "post messages") {
+			return
+		}
 		msg = types.BuildChatMsg(reader.buffer.Bytes(), reader.conn.participant.Username, reader.conn.channel.Name)
 	}
 
+	reader.postMessage(session, msg)
+}
+
+func (r *readerFSM) postMessage(session *session, msg *types.ChatMessage) {
+	if !r.conn.scopes.allows(scopePost, msg.Channel) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Not allowed with this API token", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	// The session has received a message from the client, thus the timout process
+	// has to be aborted. We send a signal to the abortConnectionTimeout channel which resets.
+	// Since the timer will be reset, we cannot close the channel, because we won't be able to reopen it,
+	// so we have to send a message instead.
+	r.conn.abortConnectionTimeout <- struct{}{}
+
 	// Storage the message in a backend storage.
 	session.storage.StoreMessage(msg)
 
-	session.sendMsg(msg)
+	// Delivered to all the connected participants, including sender's other devices.
+	session.sendMsg(&chatEnvelope{message: msg, origin: r.conn.ipAddr})
+
+	mentioned := r.notifyMentions(session, msg)
+	session.queueForOfflineParticipants(msg, mentioned)
 }
 
 func onDisconnectState(reader *readerFSM, session *session) {
@@ -577,14 +921,35 @@ func onDisconnectState(reader *readerFSM, session *session) {
 	// That prevents us from having go leaks.
 	reader.conn.cancel()
 
+	// Everything in the channel the participant was looking at has been delivered to them,
+	// unless the participant has deleted the account.
+	if reader.conn.matchState(connectedState) && session.storage.HasParticipant(disconnectedUsername) {
+		markAsRead(session, disconnectedUsername, reader.conn.channel.Name)
+		session.storage.SetLastSeen(disconnectedUsername, time.Now())
+	}
+
+	if reader.conn.resumeToken != "" {
+		// The connection dropped, give the client a chance to reconnect and resume the session.
+		session.resumeTokens.detach(
+			reader.conn.resumeToken, reader.conn.channel.Name, session.latestSequences(), session.config.ResumeTimeout,
+		)
+	} else {
+		// Let the client know that it shouldn't try to reconnect.
+		// Written directly, because the connection is closed right after.
+		util.WriteBytes(reader.conn.netConn, bytes.NewBufferString(types.BuildControlFrame(types.FrameClose)))
+	}
+
 	reader.conn.netConn.Close()
 
 	session.connMap.removeConn(reader.conn.ipAddr)
 
-	// Broadcast message to everyone containing a name of who was disconnected
-	session.sendMsg(
-		types.BuildSysMsg(util.Fmtln("{server: %s} Participant %s disconnected", util.TimeNowStr(), disconnectedUsername)),
-	)
+	// Broadcast message to everyone containing a name of who was disconnected,
+	// unless the participant is still connected from other devices.
+	if reader.conn.matchState(connectedState) && !session.connMap.hasConnectedParticipant(disconnectedUsername) {
+		session.sendMsg(
+			types.BuildSysMsg(util.Fmtln("{server: %s} Participant %s disconnected", util.TimeNowStr(), disconnectedUsername)),
+		)
+	}
 }
 
 func (r *readerFSM) updateState(newState readerState, newSubstate ...readerSubstate) {
@@ -596,18 +961,122 @@ func (r *readerFSM) updateState(newState readerState, newSubstate ...readerSubst
 	r.state = newState
 }
 
-func (r *readerFSM) displayChatHistory(session *session) {
-	if history := session.storage.GetChatHistory(); len(history) > 0 {
-		session.sendMsg(types.BuildSysMsg(buildChatHistory(history), r.conn.ipAddr))
+// Called once the participant has been authenticated.
+func (r *readerFSM) completeLogin(session *session) {
+	r.conn.participant.JoinTime = util.TimeNowStr()
+	session.storage.SetLastSeen(r.conn.participant.Username, time.Now())
+	r.loadVerificationState(session)
+
+	// TODO: Document.
+	go r.conn.disconnectIfIdle()
+
+	r.issueResumeToken(session)
+
+	// Display chat history to the connected participant
+	r.displayQueuedMessages(session, r.displayChatHistory(session))
+
+	session.connMap.markAsConnected(r.conn.ipAddr)
+}
+
+func (r *readerFSM) issueResumeToken(session *session) {
+	r.conn.resumeToken = session.resumeTokens.issue(r.conn.participant.Username, r.conn.apiToken)
+	session.sendMsg(types.BuildSysMsg(types.BuildControlFrame(types.FrameResumeToken, r.conn.resumeToken), r.conn.ipAddr))
+}
+
+func (r *readerFSM) revokeResumeToken(session *session) {
+	if r.conn.resumeToken != "" {
+		session.resumeTokens.revoke(r.conn.resumeToken)
+		r.conn.resumeToken = ""
+	}
+}
+
+// Restores the participant's session using a resume token, the first argument,
+// followed by the last sequence numbers received by the client in <channel>:<seq> form.
+// All the messages stored after those sequence numbers are replayed to the participant.
+func (r *readerFSM) resumeSession(session *session, args []string) {
+	rejectMsg := types.BuildControlFrame(types.FrameResumeRejected) +
+		util.Fmtln("{server: %s} Failed to resume the session", util.TimeNowStr())
+
+	if !matchState(r.state, stateJoining) || len(args) == 0 {
+		session.sendMsg(types.BuildSysMsg(rejectMsg, r.conn.ipAddr))
 		return
 	}
-	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Empty chat history", util.TimeNowStr()), r.conn.ipAddr))
+
+	entry, valid := session.resumeTokens.redeem(args[0])
+	var participant *types.Participant
+	if valid {
+		participant = session.storage.GetParticipant(entry.username)
+	}
+	if participant == nil {
+		session.sendMsg(types.BuildSysMsg(rejectMsg, r.conn.ipAddr))
+		return
+	}
+
+	// Sessions of the participants logged in with an API token keep its scopes, unless it was revoked meanwhile.
+	if entry.apiToken != "" {
+		token := session.storage.GetAPIToken(entry.apiToken)
+		if token == nil {
+			session.sendMsg(types.BuildSysMsg(rejectMsg, r.conn.ipAddr))
+			return
+		}
+		r.conn.apiToken = token.Hash
+		r.conn.scopes, _ = parseScopes(session.policy, strings.Fields(token.Scopes))
+	}
+
+	r.conn.participant.Username = entry.username
+	r.conn.participant.Unverified = participant.Unverified
+	if channel := session.findChannel(entry.channel); channel != nil {
+		session.connMap.setChannel(r.conn.ipAddr, channel)
+	}
+
+	// Sequence numbers reported by the client take precedence over the ones
+	// captured by the session, since some messages might have been lost on the wire.
+	sequences := entry.sequences
+	for channel, seq := range parseSequences(args[1:]) {
+		sequences[channel] = seq
+	}
+
+	go r.conn.disconnectIfIdle()
+
+	r.conn.resumeToken = session.resumeTokens.issue(entry.username, entry.apiToken)
+
+	var missed []*types.ChatMessage
+	channels := []string{""}
+	for _, channel := range session.storage.GetChannels() {
+		channels = append(channels, channel.Name)
+	}
+	for _, channel := range channels {
+		// Channels missing from the sequences are replayed entirely, so the token's scopes have to be checked first.
+		if !r.conn.scopes.allows(scopeRead, channel) {
+			continue
+		}
+		for _, msg := range session.storage.GetChatHistory(channel) {
+			if msg.Seq > sequences[channel] {
+				missed = append(missed, msg)
+			}
+		}
+	}
+
+	var builder strings.Builder
+	builder.WriteString(types.BuildControlFrame(types.FrameResumeToken, r.conn.resumeToken))
+	builder.WriteString(util.Fmtln("{server: %s} Session resumed, %d missed messages", util.TimeNowStr(), len(missed)))
+	for _, msg := range missed {
+		builder.WriteString(canonicalChatMessage(msg))
+	}
+	session.sendMsg(types.BuildSysMsg(builder.String(), r.conn.ipAddr))
+
+	// The replayed messages include everything queued while the participant was away.
+	session.storage.DeleteQueuedMessages(entry.username)
+
+	session.connMap.markAsConnected(r.conn.ipAddr)
+
+	r.updateState(stateAcceptingMessages)
 }
 
 // Returns true if the channel list is non-empty, false otherwise
 func (r *readerFSM) displayChannels(session *session) bool {
 	if channels := session.storage.GetChannels(); len(channels) > 0 {
-		session.sendMsg(types.BuildSysMsg(util.Fmtln(buildChannelList(channels)), r.conn.ipAddr))
+		session.sendMsg(types.BuildSysMsg(util.Fmtln(buildChannelList(session, r.conn.participant.Username, channels)), r.conn.ipAddr))
 		return true
 	}
 
@@ -626,19 +1095,23 @@ func (r *readerFSM) displayMembers(session *session) {
 func buildChatHistory(history []*types.ChatMessage) string {
 	var builder strings.Builder
 	for _, msg := range history {
-		message := util.Fmtln("{%s:%s} %s", msg.Sender, msg.SentTime, msg.Contents.String())
-		builder.WriteString(message)
+		builder.WriteString(formatChatMessage(msg))
 	}
 	return builder.String()
 }
 
-func buildChannelList(channels []*types.Channel) string {
+// Channels with messages the participant hasn't read yet are followed by the number of unread messages.
+func buildChannelList(session *session, username string, channels []*types.Channel) string {
 	var builder strings.Builder
 
+	markers := session.storage.GetReadMarkers(username)
 	builder.WriteString("channels:\n")
 	for index, channel := range channels {
-		message := util.Fmtln("\t{%d} :%s", index, channel.Name)
-		builder.WriteString(message)
+		message := util.Fmt("\t{%d} :%s", index, channel.Name)
+		if unread := countUnread(session.storage.GetChatHistory(channel.Name), markers[channel.Name], username); unread > 0 {
+			message += util.Fmt(" (%d unread)", unread)
+		}
+		builder.WriteString(util.Fmtln(message))
 	}
 	return builder.String()
 }
@@ -646,16 +1119,27 @@ func buildChannelList(channels []*types.Channel) string {
 func buildMembersList(session *session, members []*types.Participant) string {
 	var builder strings.Builder
 	// Iterate over all the participants in a storage,
-	// check whether they are in a connection map to verify which status to display
-	// `online` or `offline`. If a paticipant is present in a connection map
-	// and its status is not Pending, that is online, otherwise offline.
+	// check whether they are in a connection map to verify which status to display.
+	// If a paticipant is present in a connection map and its status is not Pending,
+	// its presence is displayed (online, away or dnd, with the status text), otherwise offline.
+	// A participant connected from multiple devices has the state of each device listed below its name.
+	// Display names follow the usernames, and offline participants are followed by the time they were last seen.
 	builder.WriteString("members:\n")
 	for _, member := range members {
-		if session.connMap.hasConnectedParticipant(member.Username) {
-			builder.WriteString(util.Fmtln("\t{%-64s} *%s", member.Username, connStateTable[connectedState]))
+		if devices := session.connMap.participantDevices(member.Username); len(devices) > 0 {
+			builder.WriteString(util.Fmtln("\t{%-64s} *%s", memberName(member), formatPresence(session.participantPresence(member.Username))))
+			if len(devices) > 1 {
+				for _, device := range devices {
+					builder.WriteString(util.Fmtln("\t\t{%s} *%s", device, session.connMap.deviceState(device)))
+				}
+			}
+			continue
+		}
+		if member.LastSeen.IsZero() {
+			builder.WriteString(util.Fmtln("\t{%-64s} *%s", memberName(member), connStateTable[pendingState]))
 			continue
 		}
-		builder.WriteString(util.Fmtln("\t{%-64s} *%s", member.Username, connStateTable[pendingState]))
+		builder.WriteString(util.Fmtln("\t{%-64s} *%s, last seen %s", memberName(member), connStateTable[pendingState], formatProfileTime(member.LastSeen)))
 	}
 	return builder.String()
 }
@@ -0,0 +1,157 @@
+package session
+
+import (
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// A resume token is issued to every participant after a successful authentication.
+// If the connection drops, the client can reconnect and present the token
+// instead of going through the menu again, and the session replays all the messages
+// which were stored while the participant was away.
+type resumeEntry struct {
+	username string
+	// Hash of the API token the participant logged in with, if any.
+	apiToken string
+	// The channel the participant was in when the connection dropped.
+	channel string
+	// Last sequence numbers in each channel at the moment of disconnecting.
+	// Used for channels the client didn't report itself.
+	sequences map[string]uint64
+	// Zero while the connection is alive.
+	expires time.Time
+}
+
+type resumeTable struct {
+	entries map[string]*resumeEntry
+	mu      sync.Mutex
+}
+
+func newResumeTable() *resumeTable {
+	return &resumeTable{
+		entries: make(map[string]*resumeEntry),
+	}
+}
+
+func (t *resumeTable) issue(username string, apiToken string) string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.purgeExpired()
+
+	token := util.RandomHex(16)
+	t.entries[token] = &resumeEntry{username: username, apiToken: apiToken}
+	return token
+}
+
+// Starts the countdown after which the token cannot be redeemed anymore.
+func (t *resumeTable) detach(token string, channel string, sequences map[string]uint64, timeout time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if entry, exists := t.entries[token]; exists {
+		entry.channel = channel
+		entry.sequences = sequences
+		entry.expires = time.Now().Add(timeout)
+	}
+}
+
+// Returns the entry associated with the token and removes it from the table,
+// thus every token can only be redeemed once.
+func (t *resumeTable) redeem(token string) (*resumeEntry, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	entry, exists := t.entries[token]
+	if !exists {
+		return nil, false
+	}
+	delete(t.entries, token)
+
+	// A token which is still attached to a live connection cannot be redeemed.
+	if entry.expires.IsZero() || time.Now().After(entry.expires) {
+		return nil, false
+	}
+	return entry, true
+}
+
+func (t *resumeTable) revoke(token string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.entries, token)
+}
+
+// Revokes the tokens of all the participant's devices, including the ones which have disconnected.
+func (t *resumeTable) revokeUser(username string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for token, entry := range t.entries {
+		if canonical.Equal(entry.username, username) {
+			delete(t.entries, token)
+		}
+	}
+}
+
+// Keeps the tokens of the participants who were in a renamed channel valid for that channel.
+func (t *resumeTable) renameChannel(channelname string, newName string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, entry := range t.entries {
+		if entry.channel == channelname {
+			entry.channel = newName
+		}
+		if seq, exists := entry.sequences[channelname]; exists {
+			entry.sequences[newName] = seq
+			delete(entry.sequences, channelname)
+		}
+	}
+}
+
+// Removes all the tokens which have expired, the caller has to hold the lock.
+func (t *resumeTable) purgeExpired() {
+	now := time.Now()
+	for token, entry := range t.entries {
+		if !entry.expires.IsZero() && now.After(entry.expires) {
+			delete(t.entries, token)
+		}
+	}
+}
+
+func encodeFrameChannel(channel string) string {
+	if channel == "" {
+		return types.FrameGeneralChannel
+	}
+	return channel
+}
+
+func decodeFrameChannel(channel string) string {
+	if channel == types.FrameGeneralChannel {
+		return ""
+	}
+	return channel
+}
+
+// Parses the <channel>:<seq> pairs sent by the client in a resume frame.
+func parseSequences(args []string) map[string]uint64 {
+	sequences := make(map[string]uint64, len(args))
+	for _, arg := range args {
+		channel, seq, found := strings.Cut(arg, ":")
+		if !found {
+			continue
+		}
+		number, err := strconv.ParseUint(seq, 10, 64)
+		if err != nil {
+			continue
+		}
+		sequences[decodeFrameChannel(channel)] = number
+	}
+	return sequences
+}
@@ -0,0 +1,77 @@
+package session
+
+import (
+	"time"
+
+	"github.com/isnastish/chat/pkg/canonical"
+	"github.com/isnastish/chat/pkg/logger"
+)
+
+// Messages older than MaxAge are removed, as well as the oldest messages
+// exceeding MaxCount. Zero values disable the corresponding limit.
+type RetentionPolicy struct {
+	MaxAge   time.Duration `yaml:"maxAge"`
+	MaxCount int           `yaml:"maxCount"`
+}
+
+// Limits which are not set in a channel's policy are taken from the global one.
+func (s *session) retentionPolicy(channelname string) RetentionPolicy {
+	policy := s.config.Retention
+	if channelname == "" {
+		return policy
+	}
+	for name, channelPolicy := range s.config.ChannelRetention {
+		if !canonical.Equal(name, channelname) {
+			continue
+		}
+		if channelPolicy.MaxAge != 0 {
+			policy.MaxAge = channelPolicy.MaxAge
+		}
+		if channelPolicy.MaxCount != 0 {
+			policy.MaxCount = channelPolicy.MaxCount
+		}
+	}
+	return policy
+}
+
+// Periodically removes the messages which exceed the retention policies, until the session shuts down.
+func (s *session) runJanitor() {
+	if s.config.RetentionInterval == 0 {
+		return
+	}
+
+	ticker := time.NewTicker(s.config.RetentionInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.enforceRetention()
+		case <-s.stopJanitor:
+			return
+		}
+	}
+}
+
+func (s *session) enforceRetention() {
+	channels := []string{""}
+	for _, channel := range s.storage.GetChannels() {
+		channels = append(channels, channel.Name)
+	}
+
+	for _, channelname := range channels {
+		policy := s.retentionPolicy(channelname)
+
+		var purged int
+		if policy.MaxAge != 0 {
+			purged += s.storage.DeleteMessagesBefore(channelname, time.Now().Add(-policy.MaxAge))
+		}
+		if policy.MaxCount != 0 {
+			purged += s.storage.TrimMessages(channelname, policy.MaxCount)
+		}
+
+		if purged != 0 {
+			log.Logger.Info("Purged %d messages in %s", purged, channelLabel(channelname))
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package session
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/backend"
+	"github.com/isnastish/chat/pkg/types"
+)
+
+func TestRetentionPolicy(t *testing.T) {
+	s := &session{config: Config{
+		Retention: RetentionPolicy{MaxAge: time.Hour, MaxCount: 1000},
+		ChannelRetention: map[string]RetentionPolicy{
+			"announcements": {MaxCount: 10},
+		},
+	}}
+
+	assert.Equal(t, RetentionPolicy{MaxAge: time.Hour, MaxCount: 1000}, s.retentionPolicy(""))
+	assert.Equal(t, RetentionPolicy{MaxAge: time.Hour, MaxCount: 10}, s.retentionPolicy("announcements"))
+	assert.Equal(t, RetentionPolicy{MaxAge: time.Hour, MaxCount: 10}, s.retentionPolicy("Announcements"))
+	assert.Equal(t, RetentionPolicy{MaxAge: time.Hour, MaxCount: 1000}, s.retentionPolicy("books"))
+}
+
+// Hides the messages which started threads, as if they were removed without their replies.
+type orphanedRepliesBackend struct {
+	backend.Backend
+}
+
+func (b orphanedRepliesBackend) GetMessage(id string) *types.ChatMessage {
+	msg := b.Backend.GetMessage(id)
+	if msg == nil || msg.ParentId == "" {
+		return nil
+	}
+	return msg
+}
+
+func TestEnforceRetentionRemovesThreads(t *testing.T) {
+	s := newTestSession(Config{Retention: RetentionPolicy{MaxCount: 1}})
+	reader, _ := newTestReader(t, "AliceCooper")
+
+	root := types.BuildChatMsg([]byte("root"), "AliceCooper")
+	s.storage.StoreMessage(root)
+	reply := types.BuildChatMsg([]byte("reply"), "AliceCooper")
+	reply.ParentId = root.Id
+	s.storage.StoreMessage(reply)
+
+	// The reply is within the limit, but it's removed together with its thread
+	s.enforceRetention()
+	assert.Nil(t, s.storage.GetMessage(reply.Id))
+
+	reader.displayThread(s, reply.Id)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Message "+reply.Id+" not found")
+}
+
+func TestOrphanedReplies(t *testing.T) {
+	s := newTestSession(Config{})
+	reader, _ := newTestReader(t, "AliceCooper")
+
+	root := types.BuildChatMsg([]byte("root"), "AliceCooper")
+	s.storage.StoreMessage(root)
+	reply := types.BuildChatMsg([]byte("reply"), "AliceCooper")
+	reply.ParentId = root.Id
+	s.storage.StoreMessage(reply)
+	s.storage = orphanedRepliesBackend{s.storage}
+
+	reader.replyToMessage(s, reply.Id, "another reply")
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Thread of message "+reply.Id+" not found")
+
+	reader.displayThread(s, reply.Id)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Thread of message "+reply.Id+" not found")
+}
+
+// Backend whose channels are all renamed right after they're listed, as if it happened while the janitor was running.
+type renamingBackend struct {
+	backend.Backend
+}
+
+func (b renamingBackend) GetChannels() []*types.Channel {
+	channels := b.Backend.GetChannels()
+	for _, channel := range channels {
+		b.Backend.UpdateChannel(channel.Name, &types.Channel{Name: channel.Name + "-renamed", Desc: channel.Desc})
+	}
+	return channels
+}
+
+func TestEnforceRetentionRenamedChannel(t *testing.T) {
+	s := newTestSession(Config{Retention: RetentionPolicy{MaxCount: 1}})
+	s.storage.RegisterChannel(&types.Channel{Name: "books", Creator: "AliceCooper"})
+	for _, contents := range []string{"first", "second"} {
+		s.storage.StoreMessage(types.BuildChatMsg([]byte(contents), "AliceCooper", "books"))
+	}
+	s.storage = renamingBackend{s.storage}
+
+	assert.NotPanics(t, s.enforceRetention)
+	assert.Equal(t, 2, len(s.storage.GetChatHistory("books-renamed")))
+}
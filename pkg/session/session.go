@@ -2,24 +2,75 @@
 package session
 
 import (
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"net"
-	"os"
 	"time"
 
 	"github.com/isnastish/chat/pkg/backend"
 	"github.com/isnastish/chat/pkg/backend/dynamodb"
 	"github.com/isnastish/chat/pkg/backend/memory"
 	"github.com/isnastish/chat/pkg/backend/redis"
+	"github.com/isnastish/chat/pkg/blobstore"
 	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/mailer"
 	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/validation"
 )
 
 type Config struct {
 	Network string
 	Addr    string
+	// Connections are accepted over TLS if both the certificate and the key are set.
+	TLSCertFile string
+	TLSKeyFile  string
 
 	SessionTimeout     time.Duration
 	ParticipantTimeout time.Duration
+	// How long a resume token stays valid after the connection has dropped.
+	ResumeTimeout time.Duration
+	// Time after which an idle participant is displayed as away, zero disables it.
+	AwayTimeout time.Duration
+
+	// Maximum number of messages queued for an offline participant, the oldest ones are dropped.
+	OfflineQueueSize int
+	// How long a queued message is kept for an offline participant.
+	OfflineQueueTimeout time.Duration
+
+	// Retention policy of the general chat and all the channels, can be overridden per channel.
+	Retention        RetentionPolicy
+	ChannelRetention map[string]RetentionPolicy
+	// How often the retention policies are enforced, zero disables the janitor.
+	RetentionInterval time.Duration
+
+	// Participants allowed to moderate the general chat and all the channels.
+	Moderators []string
+
+	// Maximum size of a message (or a command) in bytes, zero disables the limit.
+	MaxMessageSize int
+	// Brute-force protection of the authentication.
+	Login LoginPolicy
+	// How long a password reset token stays valid.
+	ResetTokenTimeout time.Duration
+	// Minimum time between two password reset tokens sent to the same participant.
+	ResetRequestInterval time.Duration
+	// Email address verification of newly registered participants.
+	Verification VerificationPolicy
+	// Issuer displayed by authenticator apps for the two-factor authentication.
+	TOTPIssuer string
+	// Rules of the passwords, names and email addresses.
+	Validation validation.Config
+	// Used for sending password reset tokens and verification codes.
+	Mailer mailer.Config
+
+	// Rate limits applied to messages and commands.
+	Flood FloodProtection
+
+	// Maximum size of an uploaded file in bytes.
+	MaxUploadSize int
+	// Directory where uploaded files are stored, files are kept in memory if empty.
+	BlobDir string
 
 	backend.Config
 }
@@ -32,6 +83,12 @@ type metrics struct {
 	sentSysMessages         int
 }
 
+// A chat message together with the address of the connection it was received from.
+type chatEnvelope struct {
+	message *types.ChatMessage
+	origin  string
+}
+
 type session struct {
 	config                 Config
 	listener               net.Listener
@@ -39,63 +96,110 @@ type session struct {
 	shutdownTimer          *time.Timer
 	shutdownSignal         chan struct{}
 	triggerShutdownProcess chan struct{}
-	chatMessages           chan *types.ChatMessage
+	chatMessages           chan *chatEnvelope
 	sysMessages            chan *types.SysMessage
 	storage                backend.Backend
+	blobs                  blobstore.BlobStore
+	mailer                 mailer.Mailer
+	resumeTokens           *resumeTable
+	presences              *presenceTable
+	flood                  *floodGuard
+	policy                 *validation.Policy
+	stopJanitor            chan struct{}
 	metrics                metrics
 }
 
-func CreateSession(config Config) *session {
-	listener, err := net.Listen(config.Network, config.Addr)
+func listen(config *Config) (net.Listener, error) {
+	if config.TLSCertFile == "" || config.TLSKeyFile == "" {
+		return net.Listen(config.Network, config.Addr)
+	}
+	certificate, err := tls.LoadX509KeyPair(config.TLSCertFile, config.TLSKeyFile)
 	if err != nil {
-		log.Logger.Error("Listener creation failed: %v", err)
-		os.Exit(1)
+		return nil, err
 	}
+	return tls.Listen(config.Network, config.Addr, &tls.Config{Certificates: []tls.Certificate{certificate}, MinVersion: tls.VersionTLS12})
+}
 
+// The listener is created last, so nothing is listening if any of the other parts fails.
+func CreateSession(config Config) (*session, error) {
 	var storage backend.Backend
+	var err error
 
 	switch config.BackendType {
 	case backend.BackendTypeRedis:
 		if config.RedisConfig == nil {
-			log.Logger.Panic("Redis config is invalid")
+			return nil, errors.New("redis config is missing")
 		}
 
 		storage, err = redis.NewRedisBackend(config.RedisConfig)
 		if err != nil {
-			log.Logger.Panic("Redis backend initialization failed %s", err)
+			return nil, fmt.Errorf("redis backend initialization failed: %w", err)
 		}
 
 	case backend.BackendTypeDynamodb:
 		if config.DynamodbConfig == nil {
-			log.Logger.Panic("Dynamodb config is invalid")
+			return nil, errors.New("dynamodb config is missing")
 		}
 
 		storage, err = dynamodb.NewDynamodbBackend(config.DynamodbConfig)
 		if err != nil {
-			log.Logger.Panic("Dynamodb backend initialization failed %s", err)
+			return nil, fmt.Errorf("dynamodb backend initialization failed: %w", err)
 		}
 
 	case backend.BackendTypeMemory:
 		storage = memory.NewMemoryBackend()
+
+	default:
+		return nil, fmt.Errorf("unknown backend type %v", config.BackendType)
+	}
+
+	var blobs blobstore.BlobStore
+	if config.BlobDir != "" {
+		blobs, err = blobstore.NewLocalStore(config.BlobDir)
+		if err != nil {
+			return nil, fmt.Errorf("blob store initialization failed: %w", err)
+		}
+	} else {
+		blobs = blobstore.NewMemoryStore()
+	}
+
+	mailSender, err := mailer.NewMailer(&config.Mailer)
+	if err != nil {
+		return nil, fmt.Errorf("mailer initialization failed: %w", err)
+	}
+
+	listener, err := listen(&config)
+	if err != nil {
+		return nil, fmt.Errorf("listener creation failed: %w", err)
 	}
 
 	session := &session{
 		connMap:                newConnectionMap(),
-		shutdownTimer:          time.NewTimer(config.SessionTimeout * time.Second),
+		shutdownTimer:          time.NewTimer(config.SessionTimeout),
 		shutdownSignal:         make(chan struct{}),
 		triggerShutdownProcess: make(chan struct{}),
 		listener:               listener,
-		chatMessages:           make(chan *types.ChatMessage),
+		chatMessages:           make(chan *chatEnvelope),
 		sysMessages:            make(chan *types.SysMessage),
 		config:                 config,
 		storage:                storage,
+		blobs:                  blobs,
+		mailer:                 mailSender,
+		resumeTokens:           newResumeTable(),
+		presences:              newPresenceTable(),
+		flood:                  newFloodGuard(config.Flood),
+		policy:                 validation.NewPolicy(&config.Validation),
+		stopJanitor:            make(chan struct{}),
 	}
 
-	return session
+	return session, nil
 }
 
 func (s *session) Run() {
+	defer close(s.stopJanitor)
+
 	go s.processMessages()
+	go s.runJanitor()
 	go func() {
 		// Block the shutdown process until a signal is received on a triggerShutdownSignal channle.
 		<-s.triggerShutdownProcess
@@ -127,7 +231,7 @@ func (s *session) Run() {
 
 		log.Logger.Info("Connected: %s", conn.RemoteAddr().String())
 
-		connection := newConn(conn, s.config.ParticipantTimeout*time.Second)
+		connection := newConn(conn, s.config.ParticipantTimeout, s.config.AwayTimeout)
 		s.connMap.addConn(connection)
 		go s.handleConnection(connection)
 
@@ -141,6 +245,9 @@ func (s *session) sendMsg(msg interface{}) {
 		s.sysMessages <- msg
 
 	case *types.ChatMessage:
+		s.chatMessages <- &chatEnvelope{message: msg}
+
+	case *chatEnvelope:
 		s.chatMessages <- msg
 
 	default:
@@ -164,7 +271,7 @@ func (s *session) handleConnection(conn *connection) {
 
 		reader.read(s)
 
-		if !reader.processCommand(s) {
+		if !reader.consumeInput(s) {
 
 			if !reader._DEBUG_SkipUserdataProcessing {
 				// transitionTable[reader.state](reader, s)
@@ -191,6 +298,12 @@ func (s *session) handleConnection(conn *connection) {
 
 				case matchState(reader.state, stateAcceptingMessages):
 					transitionTable[stateAcceptingMessages](reader, s)
+
+				case matchState(reader.state, stateRequestingReset):
+					transitionTable[stateRequestingReset](reader, s)
+
+				case matchState(reader.state, stateResettingPassword):
+					transitionTable[stateResettingPassword](reader, s)
 				}
 			} else {
 				reader.updateState(stateAcceptingMessages)
@@ -205,8 +318,22 @@ func (s *session) handleConnection(conn *connection) {
 	}
 
 	if s.connMap.empty() {
-		s.shutdownTimer.Reset(s.config.SessionTimeout * time.Second)
+		s.shutdownTimer.Reset(s.config.SessionTimeout)
+	}
+}
+
+// Returns the last sequence number in the general chat and in every channel.
+func (s *session) latestSequences() map[string]uint64 {
+	sequences := make(map[string]uint64)
+	if history := s.storage.GetChatHistory(); len(history) > 0 {
+		sequences[""] = history[len(history)-1].Seq
+	}
+	for _, channel := range s.storage.GetChannels() {
+		if history := s.storage.GetChatHistory(channel.Name); len(history) > 0 {
+			sequences[channel.Name] = history[len(history)-1].Seq
+		}
 	}
+	return sequences
 }
 
 func (s *session) processMessages() {
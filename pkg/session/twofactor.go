@@ -0,0 +1,168 @@
+package session
+
+import (
+	"strings"
+	"time"
+
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/totp"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+const (
+	// Number of recovery codes issued at once, every code can be used instead of a one-time code only once.
+	recoveryCodeCount = 10
+	// Length of a recovery code in bytes, it's displayed hex encoded in two groups.
+	recoveryCodeLength = 5
+	// Number of time steps a one-time code is accepted before and after the current one.
+	totpSkew = 1
+)
+
+// Recovery codes are stored hashed, the dash and the case don't matter.
+func recoveryCodeKey(code string) string {
+	return util.Sha256Checksum([]byte(strings.ToLower(strings.ReplaceAll(code, "-", ""))))
+}
+
+func (s *session) hasSecondFactor(username string) bool {
+	twoFactor := s.storage.GetTwoFactor(username)
+	return twoFactor != nil && twoFactor.Enabled
+}
+
+// Arguments: the action and an optional value, a one-time code or the current password.
+func (r *readerFSM) manageTwoFactor(session *session, args []string) {
+	value := ""
+	if len(args) > 1 {
+		value = args[1]
+	}
+
+	switch strings.ToLower(args[0]) {
+	case "enable":
+		r.enrollTwoFactor(session)
+	case "confirm":
+		r.confirmTwoFactor(session, value)
+	case "disable":
+		if r.checkPassword(session, value, "two-factor-change-failed") {
+			session.storage.DeleteTwoFactor(r.conn.participant.Username)
+			log.Audit.Event("two-factor-disabled", map[string]string{"username": r.conn.participant.Username, "address": r.conn.ipAddr})
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Two-factor authentication disabled", util.TimeNowStr()), r.conn.ipAddr))
+		}
+	case "codes":
+		if !session.hasSecondFactor(r.conn.participant.Username) {
+			session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Two-factor authentication is not enabled", util.TimeNowStr()), r.conn.ipAddr))
+		} else if r.checkPassword(session, value, "two-factor-change-failed") {
+			r.issueRecoveryCodes(session)
+		}
+	default:
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Unknown action %s, expected enable, confirm, disable or codes", util.TimeNowStr(), args[0]), r.conn.ipAddr,
+		))
+	}
+}
+
+// The second factor is enabled only after the participant has confirmed it with a valid code,
+// so a mistyped secret doesn't lock the participant out.
+func (r *readerFSM) enrollTwoFactor(session *session) {
+	username := r.conn.participant.Username
+	if session.hasSecondFactor(username) {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Two-factor authentication is already enabled, disable it first", util.TimeNowStr()), r.conn.ipAddr,
+		))
+		return
+	}
+
+	secret := totp.GenerateSecret()
+	session.storage.SetTwoFactor(username, &types.TwoFactor{Secret: secret})
+
+	session.sendMsg(types.BuildSysMsg(util.Fmt(
+		"{server: %s} Add the account to an authenticator app:\n\t%s\n\tsecret: %s\n"+
+			"Then confirm it with :2fa confirm <code>\n", util.TimeNowStr(), totp.URI(session.config.TOTPIssuer, username, secret), secret,
+	), r.conn.ipAddr))
+}
+
+func (r *readerFSM) confirmTwoFactor(session *session, code string) {
+	username := r.conn.participant.Username
+	twoFactor := session.storage.GetTwoFactor(username)
+	if twoFactor == nil || twoFactor.Enabled {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Nothing to confirm, use :2fa enable first", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	step, valid := totp.Validate(twoFactor.Secret, code, time.Now(), totpSkew)
+	if !valid {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Code is invalid", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	twoFactor.Enabled = true
+	twoFactor.LastStep = step
+	session.storage.SetTwoFactor(username, twoFactor)
+	log.Audit.Event("two-factor-enabled", map[string]string{"username": username, "address": r.conn.ipAddr})
+
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Two-factor authentication enabled", util.TimeNowStr()), r.conn.ipAddr))
+	r.issueRecoveryCodes(session)
+}
+
+// Replaces the recovery codes of the participant, the codes are displayed only once.
+func (r *readerFSM) issueRecoveryCodes(session *session) {
+	codes := make([]string, 0, recoveryCodeCount)
+	keys := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code := util.RandomHex(recoveryCodeLength)
+		code = code[:len(code)/2] + "-" + code[len(code)/2:]
+		codes = append(codes, code)
+		keys = append(keys, recoveryCodeKey(code))
+	}
+	session.storage.SetRecoveryCodes(r.conn.participant.Username, keys)
+
+	session.sendMsg(types.BuildSysMsg(util.Fmt(
+		"{server: %s} Recovery codes, each can be used once instead of a one-time code. Keep them safe:\n\t%s\n",
+		util.TimeNowStr(), strings.Join(codes, "\n\t"),
+	), r.conn.ipAddr))
+}
+
+// Accepts either a one-time code or a recovery code. Failures count as failed login attempts,
+// and the attempts are cleared only once the second factor has been accepted.
+func (r *readerFSM) verifySecondFactor(session *session, code string) bool {
+	username := r.conn.participant.Username
+	keys := []string{usernameAttemptsKey(username), addressAttemptsKey(r.conn.ipAddr)}
+
+	now := time.Now()
+	var delay time.Duration
+	for _, key := range keys {
+		delay = max(delay, session.loginDelay(key, now))
+	}
+	if delay > 0 {
+		log.Audit.Event("login-rejected", map[string]string{"username": username, "address": r.conn.ipAddr, "retryAfter": delay.String()})
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many failed attempts, try again in %v", util.TimeNowStr(), delay.Round(time.Second)), r.conn.ipAddr,
+		))
+		return false
+	}
+
+	if twoFactor := session.storage.GetTwoFactor(username); twoFactor != nil {
+		if step, valid := totp.Validate(twoFactor.Secret, code, now, totpSkew); valid && step > twoFactor.LastStep {
+			twoFactor.LastStep = step
+			session.storage.SetTwoFactor(username, twoFactor)
+			session.storage.DeleteLoginAttempts(keys[0])
+			return true
+		}
+		if session.storage.RedeemRecoveryCode(username, recoveryCodeKey(code)) {
+			log.Audit.Event("recovery-code-used", map[string]string{"username": username, "address": r.conn.ipAddr})
+			session.storage.DeleteLoginAttempts(keys[0])
+			return true
+		}
+	}
+
+	log.Audit.Event("second-factor-failed", map[string]string{"username": username, "address": r.conn.ipAddr})
+	for _, key := range keys {
+		if session.recordLoginFailure(key, now) {
+			log.Audit.Event("lockout", map[string]string{
+				"key": key, "username": username, "address": r.conn.ipAddr, "duration": session.config.Login.LockoutDuration.String(),
+			})
+		}
+	}
+
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Failed to authenticate. Code is incorrect.", util.TimeNowStr()), r.conn.ipAddr))
+	return false
+}
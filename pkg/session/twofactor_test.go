@@ -0,0 +1,55 @@
+package session
+
+import (
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/totp"
+)
+
+func TestTwoFactorAuthentication(t *testing.T) {
+	s := newTestSession(Config{TOTPIssuer: "chat"})
+	reader, _ := newTestReader(t, "AliceCooper")
+
+	reader.manageTwoFactor(s, []string{"enable"})
+	enrollment := (<-s.sysMessages).Contents.String()
+	assert.Contains(t, enrollment, "otpauth://totp/chat:AliceCooper?")
+	secret := s.storage.GetTwoFactor("AliceCooper").Secret
+	assert.Contains(t, enrollment, secret)
+
+	// Not enabled until confirmed
+	assert.False(t, s.hasSecondFactor("AliceCooper"))
+	reader.manageTwoFactor(s, []string{"confirm", "000000"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Code is invalid")
+
+	now := time.Now()
+	code, _ := totp.Code(secret, now)
+	reader.manageTwoFactor(s, []string{"confirm", code})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Two-factor authentication enabled")
+	recoveryCodes := regexp.MustCompile(`[0-9a-f]{5}-[0-9a-f]{5}`).FindAllString((<-s.sysMessages).Contents.String(), -1)
+	assert.Equal(t, recoveryCodeCount, len(recoveryCodes))
+	assert.True(t, s.hasSecondFactor("AliceCooper"))
+
+	// The code used for confirming can't be used again
+	assert.False(t, reader.verifySecondFactor(s, code))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Code is incorrect")
+
+	next, _ := totp.Code(secret, now.Add(totp.Period))
+	assert.True(t, reader.verifySecondFactor(s, next))
+
+	// Recovery codes can be used once
+	assert.True(t, reader.verifySecondFactor(s, recoveryCodes[0]))
+	assert.False(t, reader.verifySecondFactor(s, recoveryCodes[0]))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Code is incorrect")
+
+	reader.manageTwoFactor(s, []string{"disable", "Wrong#123456"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Current password is incorrect")
+	assert.True(t, s.hasSecondFactor("AliceCooper"))
+
+	reader.manageTwoFactor(s, []string{"disable", "Secret#12345"})
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Two-factor authentication disabled")
+	assert.False(t, s.hasSecondFactor("AliceCooper"))
+}
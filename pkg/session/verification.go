@@ -0,0 +1,145 @@
+package session
+
+import (
+	"time"
+
+	"github.com/isnastish/chat/pkg/logger"
+	"github.com/isnastish/chat/pkg/types"
+	"github.com/isnastish/chat/pkg/utilities"
+)
+
+// Length of an email verification code in bytes, it's sent hex encoded.
+const verificationCodeLength = 4
+
+// Actions unverified participants aren't allowed to do.
+type Restrictions struct {
+	Posting          bool `yaml:"posting"`
+	CreatingChannels bool `yaml:"creatingChannels"`
+	Uploading        bool `yaml:"uploading"`
+}
+
+type VerificationPolicy struct {
+	// Newly registered participants have to verify their email address.
+	Required bool `yaml:"required"`
+	// How long a verification code stays valid.
+	CodeTimeout time.Duration `yaml:"codeTimeout"`
+	// Minimum time between two codes sent to the same participant.
+	ResendInterval time.Duration `yaml:"resendInterval"`
+
+	Restrictions `yaml:"restrictions"`
+}
+
+// Purpose of the verification codes sent to participants, see Backend.GetSentCode.
+const verificationSentPurpose = "verify"
+
+func verificationPurpose(username string) string {
+	return "verify:" + username
+}
+
+// Sends a new verification code to participant's email address.
+// Codes sent before stay valid until they expire.
+// The time of the last code is kept in the backend, so reconnecting doesn't allow sending codes more often.
+func (r *readerFSM) sendVerificationCode(session *session) {
+	username := r.conn.participant.Username
+	now := time.Now()
+	policy := session.config.Verification
+	if sent := session.storage.GetSentCode(username, verificationSentPurpose); sent != nil {
+		if wait := sent.SentTime.Add(policy.ResendInterval).Sub(now); wait > 0 {
+			session.sendMsg(types.BuildSysMsg(
+				util.Fmtln("{server: %s} A code was sent recently, try again in %v", util.TimeNowStr(), wait.Round(time.Second)), r.conn.ipAddr,
+			))
+			return
+		}
+	}
+
+	participant := session.storage.GetParticipant(username)
+	if participant == nil || participant.Email == "" {
+		return
+	}
+
+	code := util.RandomHex(verificationCodeLength)
+	timeout := policy.CodeTimeout
+	key := oneTimeCodeKey(verificationPurpose(username), code)
+	session.storage.StoreOneTimeCode(key, username, timeout)
+	session.storage.SetSentCode(username, verificationSentPurpose, &types.SentCode{Code: key, SentTime: now}, policy.ResendInterval)
+
+	body := util.Fmt("Welcome to the chat, %s.\n"+
+		"Verify your email address by sending the command below, the code expires in %v.\n\n:verify %s\n\n"+
+		"If you didn't register, ignore this email.", username, timeout, code)
+	if err := session.mailer.Send(participant.Email, "Verify your email address", body); err != nil {
+		log.Logger.Error("Failed to send a verification email to %s: %v", username, err)
+	}
+
+	log.Audit.Event("verification-sent", map[string]string{"username": username, "address": r.conn.ipAddr})
+	session.sendMsg(types.BuildSysMsg(
+		util.Fmtln("{server: %s} A verification code was sent to %s, use :verify <code> to submit it", util.TimeNowStr(), participant.Email), r.conn.ipAddr,
+	))
+}
+
+// Wrong codes count as failed login attempts, so they can't be guessed.
+func (r *readerFSM) verifyEmail(session *session, code string) {
+	username := r.conn.participant.Username
+	if r.isVerified(session) {
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Email address already verified", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	key := usernameAttemptsKey(username)
+	now := time.Now()
+	if delay := session.loginDelay(key, now); delay > 0 {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Too many failed attempts, try again in %v", util.TimeNowStr(), delay.Round(time.Second)), r.conn.ipAddr,
+		))
+		return
+	}
+
+	owner, redeemed := session.storage.RedeemOneTimeCode(oneTimeCodeKey(verificationPurpose(username), code))
+	if !redeemed || owner != username {
+		log.Audit.Event("verification-failed", map[string]string{"username": username, "address": r.conn.ipAddr})
+		if session.recordLoginFailure(key, now) {
+			log.Audit.Event("lockout", map[string]string{"key": key, "username": username, "address": r.conn.ipAddr})
+		}
+		session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Code is invalid or expired", util.TimeNowStr()), r.conn.ipAddr))
+		return
+	}
+
+	session.storage.MarkEmailVerified(username)
+	r.conn.participant.Unverified = false
+	log.Audit.Event("email-verified", map[string]string{"username": username, "address": r.conn.ipAddr})
+	session.sendMsg(types.BuildSysMsg(util.Fmtln("{server: %s} Email address verified", util.TimeNowStr()), r.conn.ipAddr))
+}
+
+// The flag is re-read from the backend, so verifying on another device lifts the restrictions.
+func (r *readerFSM) isVerified(session *session) bool {
+	if !r.conn.participant.Unverified {
+		return true
+	}
+	if participant := session.storage.GetParticipant(r.conn.participant.Username); participant != nil && !participant.Unverified {
+		r.conn.participant.Unverified = false
+		return true
+	}
+	return false
+}
+
+// Returns true and notifies the participant if the action isn't allowed until the email address is verified.
+func (r *readerFSM) restricted(session *session, restricted bool, action string) bool {
+	if !restricted || r.isVerified(session) {
+		return false
+	}
+	session.sendMsg(types.BuildSysMsg(
+		util.Fmtln("{server: %s} Verify your email address to %s, use :verify <code> or :resendcode", util.TimeNowStr(), action), r.conn.ipAddr,
+	))
+	return true
+}
+
+// Called once the participant has logged in.
+func (r *readerFSM) loadVerificationState(session *session) {
+	if participant := session.storage.GetParticipant(r.conn.participant.Username); participant != nil {
+		r.conn.participant.Unverified = participant.Unverified
+	}
+	if r.conn.participant.Unverified {
+		session.sendMsg(types.BuildSysMsg(
+			util.Fmtln("{server: %s} Email address not verified, use :verify <code> or :resendcode", util.TimeNowStr()), r.conn.ipAddr,
+		))
+	}
+}
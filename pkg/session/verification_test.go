@@ -0,0 +1,48 @@
+package session
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/isnastish/chat/pkg/mailer"
+)
+
+func TestEmailVerification(t *testing.T) {
+	var mail bytes.Buffer
+	unverified := testParticipant
+	unverified.Unverified = true
+	s := newTestSession(Config{
+		Verification: VerificationPolicy{Required: true, CodeTimeout: time.Minute, ResendInterval: time.Minute, Restrictions: Restrictions{Posting: true}},
+	}, &unverified)
+	s.mailer = mailer.NewWriterMailer(&mail, "chat@example.com")
+	reader, _ := newTestReader(t, "AliceCooper")
+	reader.loadVerificationState(s)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Email address not verified")
+
+	assert.True(t, reader.restricted(s, s.config.Verification.Posting, "post messages"))
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Verify your email address to post messages")
+	assert.False(t, reader.restricted(s, s.config.Verification.Uploading, "upload files"))
+
+	reader.sendVerificationCode(s)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "verification code was sent to alice@gmail.com")
+	code := regexp.MustCompile(`:verify ([0-9a-f]{8})`).FindStringSubmatch(mail.String())[1]
+
+	// Codes can't be resent right away, not even over another connection
+	reader.sendVerificationCode(s)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "sent recently")
+	reconnected, _ := newTestReader(t, "AliceCooper")
+	reconnected.sendVerificationCode(s)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "sent recently")
+
+	reader.verifyEmail(s, "00000000")
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "invalid or expired")
+
+	reader.verifyEmail(s, code)
+	assert.Contains(t, (<-s.sysMessages).Contents.String(), "Email address verified")
+	assert.False(t, s.storage.GetParticipant("AliceCooper").Unverified)
+	assert.False(t, reader.restricted(s, s.config.Verification.Posting, "post messages"))
+}
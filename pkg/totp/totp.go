@@ -0,0 +1,109 @@
+// Time-based one-time passwords (RFC 6238), used as a second authentication factor.
+// Codes are HOTP values (RFC 4226) of the number of 30 second steps since the unix epoch,
+// computed with HMAC-SHA1 and truncated to 6 digits, which is what authenticator apps expect.
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	Digits = 6
+	Period = 30 * time.Second
+	// Size of a generated secret in bytes, the length of the HMAC-SHA1 output as recommended by RFC 4226.
+	secretSize = 20
+)
+
+// Secrets are exchanged base32 encoded without padding.
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+var ErrInvalidSecret = errors.New("totp: invalid secret")
+
+// Returns a new random base32 encoded secret.
+func GenerateSecret() string {
+	secret := make([]byte, secretSize)
+	if _, err := rand.Read(secret); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return encoding.EncodeToString(secret)
+}
+
+// Time step a code generated at t belongs to.
+func Step(t time.Time) int64 {
+	return t.Unix() / int64(Period/time.Second)
+}
+
+// Returns the code for the time step t belongs to.
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return hotp(key, uint64(Step(t)), Digits), nil
+}
+
+// Checks the code against the time step t belongs to and skew steps around it,
+// to tolerate clock drift. Returns the matching step, so the caller can reject codes
+// for the steps which were already used.
+func Validate(secret string, code string, t time.Time, skew int) (int64, bool) {
+	key, err := decodeSecret(secret)
+	if err != nil || len(code) != Digits {
+		return 0, false
+	}
+	step := Step(t)
+	for i := -skew; i <= skew; i++ {
+		if hmac.Equal([]byte(hotp(key, uint64(step+int64(i)), Digits)), []byte(code)) {
+			return step + int64(i), true
+		}
+	}
+	return 0, false
+}
+
+// Builds the otpauth URI understood by authenticator apps, usually displayed as a QR code.
+// See https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func URI(issuer string, account string, secret string) string {
+	params := url.Values{}
+	params.Set("secret", secret)
+	params.Set("issuer", issuer)
+	params.Set("algorithm", "SHA1")
+	params.Set("digits", fmt.Sprint(Digits))
+	params.Set("period", fmt.Sprint(int(Period/time.Second)))
+
+	label := url.PathEscape(issuer) + ":" + url.PathEscape(account)
+	return "otpauth://totp/" + label + "?" + params.Encode()
+}
+
+func decodeSecret(secret string) ([]byte, error) {
+	// Authenticator apps display the secrets in groups and in lower case sometimes.
+	key, err := encoding.DecodeString(strings.ToUpper(strings.ReplaceAll(secret, " ", "")))
+	if err != nil || len(key) == 0 {
+		return nil, ErrInvalidSecret
+	}
+	return key, nil
+}
+
+// HOTP value of the counter as defined in RFC 4226, section 5.3.
+func hotp(key []byte, counter uint64, digits int) string {
+	mac := hmac.New(sha1.New, key)
+	binary.Write(mac, binary.BigEndian, counter)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	modulo := uint32(1)
+	for i := 0; i < digits; i++ {
+		modulo *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%modulo)
+}
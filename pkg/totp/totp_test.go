@@ -0,0 +1,72 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Test vectors from RFC 6238, appendix B (SHA1).
+func TestHotpRFC6238(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for unix, expected := range vectors {
+		assert.Equal(t, expected, hotp(key, uint64(Step(time.Unix(unix, 0))), 8))
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(59, 0)
+
+	code, err := Code(secret, now)
+	assert.True(t, err == nil)
+	assert.Equal(t, "287082", code)
+
+	step, valid := Validate(secret, code, now, 1)
+	assert.True(t, valid)
+	assert.Equal(t, Step(now), step)
+
+	// One step of clock drift is tolerated
+	_, valid = Validate(secret, code, now.Add(Period), 1)
+	assert.True(t, valid)
+	_, valid = Validate(secret, code, now.Add(2*Period), 1)
+	assert.False(t, valid)
+
+	_, valid = Validate(secret, "000000", now, 1)
+	assert.False(t, valid)
+	_, valid = Validate("not base32!", code, now, 1)
+	assert.False(t, valid)
+
+	// Secrets are accepted in lower case and in groups
+	_, valid = Validate(strings.ToLower(secret[:4]+" "+secret[4:]), code, now, 0)
+	assert.True(t, valid)
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret := GenerateSecret()
+	assert.Equal(t, 32, len(secret))
+	assert.NotEqual(t, secret, GenerateSecret())
+
+	_, err := Code(secret, time.Now())
+	assert.True(t, err == nil)
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("chat", "Alice Cooper", "JBSWY3DPEHPK3PXP")
+	assert.True(t, strings.HasPrefix(uri, "otpauth://totp/chat:Alice%20Cooper?"))
+	assert.Contains(t, uri, "secret=JBSWY3DPEHPK3PXP")
+	assert.Contains(t, uri, "issuer=chat")
+	assert.Contains(t, uri, "digits=6")
+	assert.Contains(t, uri, "period=30")
+}
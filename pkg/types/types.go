@@ -3,6 +3,8 @@ package types
 
 import (
 	"bytes"
+	"strings"
+	"time"
 
 	"github.com/isnastish/chat/pkg/utilities"
 )
@@ -16,6 +18,18 @@ type Participant struct {
 	Password string
 	Email    string
 	JoinTime string
+	// Set until the participant verifies the email address. Participants registered
+	// before the verification was introduced don't have it set, so they're considered verified.
+	Unverified bool
+	// Zero for participants registered before the registration time was recorded.
+	Registered time.Time
+	// Updated when the participant logs in and disconnects.
+	LastSeen time.Time
+	// Profile fields set by the participant, all of them optional.
+	DisplayName string
+	Bio         string
+	// IANA time zone name, for example Europe/Berlin.
+	Timezone string
 }
 
 type ChatMessage struct {
@@ -23,12 +37,101 @@ type ChatMessage struct {
 	Sender   string
 	Channel  string
 	SentTime string
+	// SentTime only holds the time of the day, while the timestamp is used to filter messages by date.
+	Timestamp time.Time
+	// Assigned by the backend when the message is stored.
+	// Sequence numbers grow monotonically within a channel (the general chat included).
+	Seq uint64
+	// Unique identifier assigned by the backend when the message is stored.
+	Id string
+	// Empty if the message was never edited.
+	EditTime string
+	// Deleted messages are kept in the history as tombstones with empty contents.
+	Deleted bool
+	// Id of the message which starts the thread if the message is a reply, empty otherwise.
+	ParentId string
+	// Maintained by the backend when replies are stored.
+	ReplyCount uint64
+	// Number of participants who reacted with each emoji, maintained by the backend.
+	Reactions map[string]uint64
+	// Id of the file shared with the message, empty if there is none.
+	AttachmentId string
+}
+
+// Size of the chunks files are uploaded in. A chunk frame has to fit into a single read of the session
+// (1024 bytes) after the chunk is base64 encoded.
+const UploadChunkSize = 512
+
+// Metadata of a shared file, the contents are kept in a blob store under the same id.
+type Attachment struct {
+	Id       string
+	Name     string
+	Size     uint64
+	Checksum string
+	Uploader string
+}
+
+// A token scripts and bots log in with instead of a username and a password.
+// Only the hash of the token is stored, the token itself is displayed once when created.
+type APIToken struct {
+	// Short id used for listing and revoking the token.
+	Id       string
+	Hash     string
+	Username string
+	Name     string
+	// Space separated scopes limiting what the token can do.
+	Scopes  string
+	Created time.Time
+}
+
+// Second authentication factor of a participant.
+type TwoFactor struct {
+	// Base32 encoded TOTP secret.
+	Secret string
+	// Set once the participant has confirmed the enrollment with a valid code.
+	Enabled bool
+	// The last time step a code was accepted for, so codes can't be used twice.
+	LastStep int64
+}
+
+// Failed login attempts of a participant or an ip address.
+type LoginAttempts struct {
+	// Number of failures since the last successful login or lockout.
+	Failures    int
+	LastFailure time.Time
+	// Zero if not locked out.
+	LockedUntil time.Time
+}
+
+// The last one-time code sent to a participant for some purpose, for example a password reset token.
+type SentCode struct {
+	// Hashed code, as it was stored in the backend.
+	Code     string
+	SentTime time.Time
+}
+
+// Messages have to contain all the terms in order to match the query.
+// Channel, Sender and Since are ignored if not set.
+type SearchQuery struct {
+	Terms   []string
+	Channel string
+	Sender  string
+	Since   time.Time
+	// When not nil, only the messages sent to these channels are matched, the general chat is "".
+	Channels []string
+	Offset   int
+	Limit    int
 }
 
 type SysMessage struct {
 	Contents  *bytes.Buffer
 	Recipient string
-	SentTime  string
+	// When set, a message without a recipient is only delivered to participants in the channel.
+	Channel string
+	// Set for messages which reveal contents of the channel, or the general chat if the channel is empty.
+	// They're only delivered to connections allowed to read it.
+	Scoped   bool
+	SentTime string
 }
 
 type Channel struct {
@@ -38,8 +141,97 @@ type Channel struct {
 	CreationDate string
 	ChatHistory  []*ChatMessage
 	Members      []string
+	// Ids of the pinned messages in the order they were pinned.
+	Pins []string
 }
 
+// Control frames are single lines exchanged between the session and the client
+// which carry protocol data rather than the text to be displayed.
+// A frame starts with ControlFramePrefix, followed by the frame's name and its arguments
+// separated by spaces, and is terminated with "\r\n".
+const ControlFramePrefix = "\x1f"
+
+const (
+	// session -> client, a token which can be used to resume the session after reconnecting.
+	FrameResumeToken = "resume-token"
+	// session -> client, sent when the resume token was rejected.
+	FrameResumeRejected = "resume-rejected"
+	// session -> client, a sequence number of the chat message which follows the frame.
+	FrameSequence = "seq"
+	// session -> client, the session closed the connection on purpose, the client shouldn't reconnect.
+	FrameClose = "close"
+	// session -> client, a message was edited, followed by message's id.
+	FrameMessageEdited = "edited"
+	// session -> client, a message was deleted, followed by message's id.
+	FrameMessageDeleted = "deleted"
+	// session -> client, reactions to a message changed, followed by message's id, the emoji and its count.
+	FrameReaction = "reaction"
+	// session -> client, a channel was renamed, followed by its old and new names.
+	FrameChannelRenamed = "channel-renamed"
+	// client -> session, start uploading a file, followed by its name (query escaped), size and sha256 checksum.
+	FrameUploadStart = "upload-start"
+	// client -> session, a chunk of the file, followed by upload's id and base64 encoded data.
+	FrameUploadChunk = "upload-chunk"
+	// client -> session, all the chunks were sent, followed by upload's id.
+	FrameUploadEnd = "upload-end"
+	// session -> client, the upload was accepted, followed by upload's id.
+	FrameUploadReady = "upload-ready"
+	// session -> client, a chunk was received, followed by upload's id and the number of bytes received so far.
+	// The client sends the next chunk only after receiving an acknowledgement.
+	FrameUploadAck = "upload-ack"
+	// session -> client, the file was stored, followed by upload's id and attachment's id.
+	FrameUploadDone = "upload-done"
+	// session -> client, the upload failed and was discarded.
+	FrameUploadRejected = "upload-rejected"
+	// session -> client, start of a file, followed by attachment's id, its name (query escaped), size and sha256 checksum.
+	FrameDownloadStart = "download-start"
+	// session -> client, a chunk of the file, followed by attachment's id and base64 encoded data.
+	FrameDownloadChunk = "download-chunk"
+	// session -> client, all the chunks were sent, followed by attachment's id.
+	FrameDownloadEnd = "download-end"
+	// client -> session, the participant is typing a message, no arguments.
+	// session -> client, followed by the username of the participant who is typing.
+	FrameTyping = "typing"
+	// client -> session, resume the session using a token and the last received sequence numbers.
+	FrameResume = "resume"
+	// client -> session, log in with an API token instead of going through the menu, followed by the token.
+	FrameLogin = "login"
+)
+
+// Name used to refer to the general chat inside control frames,
+// since its channel name is an empty string.
+const FrameGeneralChannel = "-"
+
+// Helper function for building control frames.
+func BuildControlFrame(name string, args ...string) string {
+	var builder strings.Builder
+	builder.WriteString(ControlFramePrefix)
+	builder.WriteString(name)
+	for _, arg := range args {
+		builder.WriteString(" ")
+		builder.WriteString(arg)
+	}
+	builder.WriteString("\r\n")
+	return builder.String()
+}
+
+// Returns frame's name and its arguments, or false if src is not a control frame.
+func ParseControlFrame(src string) (string, []string, bool) {
+	if !strings.HasPrefix(src, ControlFramePrefix) {
+		return "", nil, false
+	}
+
+	fields := strings.Fields(strings.TrimPrefix(src, ControlFramePrefix))
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+
+	return fields[0], fields[1:], true
+}
+
+// Length of message identifiers in bytes, before they are hex encoded.
+const MessageIdLength = 4
+
 // Helper function for building system messages.
 func BuildSysMsg(msg string, recipients ...string) *SysMessage {
 	var recipient string
@@ -57,14 +249,15 @@ func BuildSysMsg(msg string, recipients ...string) *SysMessage {
 // Helper function for building chat messages.
 func BuildChatMsg(msg []byte, sender string, channels ...string) *ChatMessage {
 	var channel string
-	if len(channel) > 0 {
+	if len(channels) > 0 {
 		channel = channels[0]
 	}
 
 	return &ChatMessage{
-		Contents: bytes.NewBuffer(bytes.Clone(msg)),
-		Sender:   sender,
-		Channel:  channel,
-		SentTime: util.TimeNowStr(),
+		Contents:  bytes.NewBuffer(bytes.Clone(msg)),
+		Sender:    sender,
+		Channel:   channel,
+		SentTime:  util.TimeNowStr(),
+		Timestamp: time.Now(),
 	}
 }
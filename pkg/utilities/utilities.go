@@ -2,7 +2,9 @@ package util
 
 import (
 	"bytes"
+	"crypto/rand"
 	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"net"
 	"strings"
@@ -14,6 +16,15 @@ func Sha256Checksum(bytes []byte) string {
 	return fmt.Sprintf("%X", sum)
 }
 
+// Returns a hex encoded string of n cryptographically secure random bytes.
+func RandomHex(n int) string {
+	bytes := make([]byte, n)
+	if _, err := rand.Read(bytes); err != nil {
+		panic(fmt.Sprintf("failed to read random bytes: %v", err))
+	}
+	return hex.EncodeToString(bytes)
+}
+
 func endOfLine(src string) string {
 	return src + "\r\n"
 }
@@ -3,40 +3,225 @@
 package validation
 
 import (
+	"fmt"
 	"regexp"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/isnastish/chat/pkg/canonical"
+)
+
+// The expressions are compiled once, rather than on every call.
+var (
+	// \p{L} - letters, \p{M} - combining marks, \p{Nd} - digits
+	nameRe           = regexp.MustCompile(`^[\p{L}\p{M}\p{Nd}_]*$`)
+	emailLocalPartRe = regexp.MustCompile("^[0-9A-Za-z_.!#$\\%&'\\*\\+\\-/=\\?^`{\\|}~]{1,64}$")
+	emailDomainRe    = regexp.MustCompile(`^[0-9A-Za-z\-\[\]:.]+$`)
+	sha256Re         = regexp.MustCompile("^[0-9A-F]+$")
+)
+
+// Limits and rules of the passwords, usernames, channel names and email addresses.
+type Config struct {
+	// Passwords can contain latin letters, digits and the symbols listed in PasswordSymbols.
+	PasswordMinLength int    `yaml:"passwordMinLength"`
+	PasswordMaxLength int    `yaml:"passwordMaxLength"`
+	PasswordSymbols   string `yaml:"passwordSymbols"`
+	// Character classes a password has to contain at least one character of.
+	RequireDigit  bool `yaml:"requireDigit"`
+	RequireLower  bool `yaml:"requireLower"`
+	RequireUpper  bool `yaml:"requireUpper"`
+	RequireSymbol bool `yaml:"requireSymbol"`
+	// Passwords which are rejected regardless of the rules above, compared case-insensitively.
+	BannedPasswords []string `yaml:"bannedPasswords"`
+
+	// Limits of the usernames and channel names, in characters.
+	NameMinLength int `yaml:"nameMinLength"`
+	NameMaxLength int `yaml:"nameMaxLength"`
+
+	// Domains email addresses are allowed to belong to, any domain is allowed if empty.
+	EmailDomains []string `yaml:"emailDomains"`
+}
+
+// The rules the validation used to hard-code.
+func DefaultConfig() Config {
+	return Config{
+		PasswordMinLength: 12,
+		PasswordMaxLength: 32,
+		PasswordSymbols:   "_@$#:&%",
+		RequireDigit:      true,
+		RequireLower:      true,
+		RequireUpper:      true,
+		RequireSymbol:     true,
+		NameMinLength:     8,
+		NameMaxLength:     32,
+	}
+}
+
+// Codes of the reasons, they're stable and can be relied upon by clients.
+const (
+	ReasonTooShort          = "too-short"
+	ReasonTooLong           = "too-long"
+	ReasonInvalidCharacters = "invalid-characters"
+	ReasonMissingDigit      = "missing-digit"
+	ReasonMissingLower      = "missing-lower"
+	ReasonMissingUpper      = "missing-upper"
+	ReasonMissingSymbol     = "missing-symbol"
+	ReasonBanned            = "banned"
+	ReasonInvalidStart      = "invalid-start"
+	ReasonMixedScripts      = "mixed-scripts"
+	ReasonInvalidFormat     = "invalid-format"
+	ReasonDomainNotAllowed  = "domain-not-allowed"
 )
 
-// Password should contain at least 12 characters, but not exceed 32,
-// at least one digit [0-9], one lower case letter [a-z], one upper case letter [A-Z],
-// and one special character from a list: (@&$#%_)
-func ValidatePassword(password string) bool {
-	re := regexp.MustCompile(`^[a-zA-Z0-9_@$#:&%]{12,32}$`)
-	hasDigitsRe := regexp.MustCompile("[!^0-9]")
-	hasLowerRe := regexp.MustCompile("[!^a-z]")
-	hasUpperRe := regexp.MustCompile("[!^A-Z]")
-	hasSymbolsRe := regexp.MustCompile(`[!^@|%|$|#|&]`)
-
-	return re.MatchString(password) &&
-		hasDigitsRe.MatchString(password) &&
-		hasLowerRe.MatchString(password) &&
-		hasUpperRe.MatchString(password) &&
-		hasSymbolsRe.MatchString(password)
+// Why a value was rejected, the message is meant to be displayed to the participant.
+type Reason struct {
+	Code    string
+	Message string
+}
+
+type Policy struct {
+	config  Config
+	banned  map[string]bool
+	domains map[string]bool
+}
+
+func NewPolicy(config *Config) *Policy {
+	policy := &Policy{
+		config:  *config,
+		banned:  make(map[string]bool, len(config.BannedPasswords)),
+		domains: make(map[string]bool, len(config.EmailDomains)),
+	}
+	for _, password := range config.BannedPasswords {
+		policy.banned[strings.ToLower(password)] = true
+	}
+	for _, domain := range config.EmailDomains {
+		policy.domains[strings.ToLower(domain)] = true
+	}
+	return policy
+}
+
+// Returns the reasons the password was rejected for, or nil if it's valid.
+func (p *Policy) CheckPassword(password string) []Reason {
+	var reasons []Reason
+	length := utf8.RuneCountInString(password)
+	if length < p.config.PasswordMinLength {
+		reasons = append(reasons, Reason{ReasonTooShort, fmt.Sprintf("must be at least %d characters long", p.config.PasswordMinLength)})
+	}
+	if length > p.config.PasswordMaxLength {
+		reasons = append(reasons, Reason{ReasonTooLong, fmt.Sprintf("must be at most %d characters long", p.config.PasswordMaxLength)})
+	}
+
+	var hasDigit, hasLower, hasUpper, hasSymbol, hasInvalid bool
+	for _, c := range password {
+		switch {
+		case c >= '0' && c <= '9':
+			hasDigit = true
+		case c >= 'a' && c <= 'z':
+			hasLower = true
+		case c >= 'A' && c <= 'Z':
+			hasUpper = true
+		case strings.ContainsRune(p.config.PasswordSymbols, c):
+			hasSymbol = true
+		default:
+			hasInvalid = true
+		}
+	}
+
+	if hasInvalid {
+		reasons = append(reasons, Reason{ReasonInvalidCharacters,
+			fmt.Sprintf("can only contain latin letters, digits and the symbols %s", p.config.PasswordSymbols)})
+	}
+	if p.config.RequireDigit && !hasDigit {
+		reasons = append(reasons, Reason{ReasonMissingDigit, "must contain a digit"})
+	}
+	if p.config.RequireLower && !hasLower {
+		reasons = append(reasons, Reason{ReasonMissingLower, "must contain a lower case letter"})
+	}
+	if p.config.RequireUpper && !hasUpper {
+		reasons = append(reasons, Reason{ReasonMissingUpper, "must contain an upper case letter"})
+	}
+	if p.config.RequireSymbol && !hasSymbol {
+		reasons = append(reasons, Reason{ReasonMissingSymbol, fmt.Sprintf("must contain one of the symbols %s", p.config.PasswordSymbols)})
+	}
+	if p.banned[strings.ToLower(password)] {
+		reasons = append(reasons, Reason{ReasonBanned, "is too common"})
+	}
+	return reasons
 }
 
 // Is used to validate participant's and channel's names.
-// A name should be at least 8 characters long, but not exceed 32.
-// And cannot start with a digit or an underscore  [0-9]|_.
-// \w - [0-9A-Za-z_]
-func ValidateName(name string) bool {
-	re := regexp.MustCompile(`^[\w]{8,32}$`)
-	beginWithRe := regexp.MustCompile(`^[a-zA-Z]`)
-
-	return re.MatchString(name) &&
-		beginWithRe.MatchString(name)
+// A name cannot start with a digit or an underscore  [0-9]|_.
+// Letters of any script are allowed, but they cannot be mixed from different scripts,
+// since that's a common way to imitate someone else's name.
+func (p *Policy) CheckName(name string) []Reason {
+	var reasons []Reason
+	length := utf8.RuneCountInString(name)
+	if length < p.config.NameMinLength {
+		reasons = append(reasons, Reason{ReasonTooShort, fmt.Sprintf("must be at least %d characters long", p.config.NameMinLength)})
+	}
+	if length > p.config.NameMaxLength {
+		reasons = append(reasons, Reason{ReasonTooLong, fmt.Sprintf("must be at most %d characters long", p.config.NameMaxLength)})
+	}
+	if !nameRe.MatchString(name) {
+		reasons = append(reasons, Reason{ReasonInvalidCharacters, "can only contain letters, digits and underscores"})
+	}
+	if first, _ := utf8.DecodeRuneInString(name); length > 0 && !unicode.IsLetter(first) {
+		reasons = append(reasons, Reason{ReasonInvalidStart, "must start with a letter"})
+	}
+	if !canonical.SingleScript(name) {
+		reasons = append(reasons, Reason{ReasonMixedScripts, "cannot mix letters of different scripts"})
+	}
+	return reasons
+}
+
+// Returns the reasons the email address was rejected for, or nil if it's valid.
+func (p *Policy) CheckEmail(email string) []Reason {
+	if !validEmail(email) {
+		return []Reason{{ReasonInvalidFormat, "is not a valid email address"}}
+	}
+	_, domain, _ := strings.Cut(email, "@")
+	if len(p.domains) != 0 && !p.domains[strings.ToLower(domain)] {
+		return []Reason{{ReasonDomainNotAllowed, fmt.Sprintf("domain %s is not allowed", domain)}}
+	}
+	return nil
+}
+
+// A display name should contain at least one character which isn't a space, but not exceed 32 characters.
+// Unlike usernames, any printable characters are allowed.
+func ValidateDisplayName(name string) bool {
+	return strings.TrimSpace(name) != "" &&
+		utf8.RuneCountInString(name) <= 32 &&
+		isPrintable(name)
+}
+
+// A bio can be empty, but shouldn't exceed 160 characters.
+func ValidateBio(bio string) bool {
+	return utf8.RuneCountInString(bio) <= 160 &&
+		isPrintable(bio)
+}
+
+// A time zone should be a name from the IANA database, for example Europe/Berlin.
+func ValidateTimezone(timezone string) bool {
+	// LoadLocation treats an empty name and "Local" as the server's time zone.
+	if timezone == "" || timezone == "Local" {
+		return false
+	}
+	_, err := time.LoadLocation(timezone)
+	return err == nil
+}
+
+func isPrintable(s string) bool {
+	for _, c := range s {
+		if !unicode.IsPrint(c) {
+			return false
+		}
+	}
+	return utf8.ValidString(s)
 }
 
-func ValidateEmail(email string) bool {
+func validEmail(email string) bool {
 	// TODO(alx): Handle quoted email addresses?
 
 	// Reference: https: //en.wikipedia.org/wiki/Email_address
@@ -77,8 +262,7 @@ func ValidateEmail(email string) bool {
 	// 4. This rule is known as the LDH rule (letters, digits, hyphen). In addition, the domain may be an IP address literal, surrounded by square brackets [], such as jsmith@[192.168.2.1] or jsmith@[IPv6:2001:db8::1], although this is rarely seen except in email spam. Internationalized domain names (which are encoded to comply with the requirements for a hostname) allow for presentation of non-ASCII domains. In mail systems compliant with RFC 6531 and RFC 6532 an email address may be encoded as UTF-8, both a local-part as well as a domain name.
 	// Comments are allowed in the domain as well as in the local-part; for example, john.smith@(comment)example.com and john.smith@example.com(comment) are equivalent to john.smith@example.com.
 
-	localPartRe := regexp.MustCompile("^[0-9A-Za-z_.!#$\\%&'\\*\\+\\-/=\\?^`{\\|}~]{1,64}$")
-	if !localPartRe.MatchString(localPart) {
+	if !emailLocalPartRe.MatchString(localPart) {
 		return false
 	}
 
@@ -91,8 +275,7 @@ func ValidateEmail(email string) bool {
 	// Then the regular expression would look like this:
 	// `^[\w\-]+$`
 	// And if we encounter '[]' symbols, we assume that it's an ip address.
-	domainRe := regexp.MustCompile(`^[0-9A-Za-z\-\[\]:.]+$`)
-	if !domainRe.MatchString(domainPart) {
+	if !emailDomainRe.MatchString(domainPart) {
 		return false
 	}
 
@@ -107,7 +290,6 @@ func ValidateEmail(email string) bool {
 // A password hashed with sha256 algorithm should only contain hexdigits uppercase characters.
 // [0-9A-F] with the length of 64
 func ValidatePasswordSha256(passwordSha256 string) bool {
-	re := regexp.MustCompile("^[0-9A-F]+$")
 	return len(passwordSha256) == 64 &&
-		re.MatchString(passwordSha256)
+		sha256Re.MatchString(passwordSha256)
 }
@@ -8,71 +8,83 @@ import (
 	"github.com/isnastish/chat/pkg/utilities"
 )
 
+var defaultConfig = DefaultConfig()
+
 func TestValidatePassword(t *testing.T) {
+	policy := NewPolicy(&defaultConfig)
+
 	// invalid passwords
-	assert.False(t, ValidatePassword("onlylowercaseletters"))
-	assert.False(t, ValidatePassword("ONLYUPPERCASELETTERS"))
-	assert.False(t, ValidatePassword("tooShort"))
-	assert.False(t, ValidatePassword("short3A@"))
-	assert.False(t, ValidatePassword("244"))
-	assert.False(t, ValidatePassword("23349999934443444"))
-	assert.False(t, ValidatePassword("****-adff==#sdf989778A"))
-	assert.False(t, ValidatePassword("/.well-known/acme-challenge"))
-	assert.False(t, ValidatePassword("password2348"))
-	assert.False(t, ValidatePassword("ThisPa2swordExceeeeeedsTheAllowedAmountOfCharacters"))
-	assert.False(t, ValidatePassword(".a"))
+	assert.NotEmpty(t, policy.CheckPassword("onlylowercaseletters"))
+	assert.NotEmpty(t, policy.CheckPassword("ONLYUPPERCASELETTERS"))
+	assert.NotEmpty(t, policy.CheckPassword("tooShort"))
+	assert.NotEmpty(t, policy.CheckPassword("short3A@"))
+	assert.NotEmpty(t, policy.CheckPassword("244"))
+	assert.NotEmpty(t, policy.CheckPassword("23349999934443444"))
+	assert.NotEmpty(t, policy.CheckPassword("****-adff==#sdf989778A"))
+	assert.NotEmpty(t, policy.CheckPassword("/.well-known/acme-challenge"))
+	assert.NotEmpty(t, policy.CheckPassword("password2348"))
+	assert.NotEmpty(t, policy.CheckPassword("ThisPa2swordExceeeeeedsTheAllowedAmountOfCharacters"))
+	assert.NotEmpty(t, policy.CheckPassword(".a"))
 
 	// valid passwords
-	assert.True(t, ValidatePassword("Afdsf988#@Nasayer"))
-	assert.True(t, ValidatePassword("2344NewYear@lone"))
-	assert.True(t, ValidatePassword("NeverAgain1999#"))
+	assert.Empty(t, policy.CheckPassword("Afdsf988#@Nasayer"))
+	assert.Empty(t, policy.CheckPassword("2344NewYear@lone"))
+	assert.Empty(t, policy.CheckPassword("NeverAgain1999#"))
 }
 
 func TestValidateName(t *testing.T) {
+	policy := NewPolicy(&defaultConfig)
+
 	// invalid names
-	assert.False(t, ValidateName("Short"))
-	assert.False(t, ValidateName("234StartsWithDigits"))
-	assert.False(t, ValidateName("_StartWithUnderscore"))
-	assert.False(t, ValidateName("Contains-***InvalidSymbols@"))
-	assert.False(t, ValidateName("NameIsTooLong23449988AndExceeeedsTheDesiredSizeOf32Symbols"))
-	assert.False(t, ValidateName("A.1"))
-	assert.False(t, ValidateName("invalid_username#"))
+	assert.NotEmpty(t, policy.CheckName("Short"))
+	assert.NotEmpty(t, policy.CheckName("234StartsWithDigits"))
+	assert.NotEmpty(t, policy.CheckName("_StartWithUnderscore"))
+	assert.NotEmpty(t, policy.CheckName("Contains-***InvalidSymbols@"))
+	assert.NotEmpty(t, policy.CheckName("NameIsTooLong23449988AndExceeeedsTheDesiredSizeOf32Symbols"))
+	assert.NotEmpty(t, policy.CheckName("A.1"))
+	assert.NotEmpty(t, policy.CheckName("invalid_username#"))
+	assert.NotEmpty(t, policy.CheckName("Mаrk_Lutz")) // cyrillic а
+	assert.NotEmpty(t, policy.CheckName("Names with spaces"))
 
 	// valid names
-	assert.True(t, ValidateName("Hadson24499"))
-	assert.True(t, ValidateName("nasayer_777"))
-	assert.True(t, ValidateName("Humanoid4You_"))
+	assert.Empty(t, policy.CheckName("Hadson24499"))
+	assert.Empty(t, policy.CheckName("nasayer_777"))
+	assert.Empty(t, policy.CheckName("Humanoid4You_"))
+	assert.Empty(t, policy.CheckName("Владимир_1990"))
+	assert.Empty(t, policy.CheckName("Rene\u0301e_Smith"))
 }
 
 func TestValidateEmailAddress(t *testing.T) {
+	policy := NewPolicy(&defaultConfig)
+
 	// invalid names
-	assert.False(t, ValidateEmail("John..Doe@example.com"))
-	assert.False(t, ValidateEmail("abc.example.com"))
-	assert.False(t, ValidateEmail("i.like.underscores@but_they_are_not_allowed_in_this_part"))                       // underscore is not allowed in domain part
-	assert.False(t, ValidateEmail("a@b@c@example.com"))                                                              // only one @ is allowed outside quotation marks
-	assert.False(t, ValidateEmail(`a"b(c)d,e:f;g<h>i[j\k]l@example.com`))                                            // none of the special characters in this local-part are allowed outside quotation marks
-	assert.False(t, ValidateEmail(`just"not"right@example.com`))                                                     // quoted strings must be dot separated or be the only element making up the local-part
-	assert.False(t, ValidateEmail(`this is"not\allowed@example.com`))                                                // spaces, quotes, and backslashes may only exist when within quoted strings and preceded by a backslash
-	assert.False(t, ValidateEmail(`this\ still\"not\\allowed@example.com`))                                          // even if escaped (preceded by a backslash), spaces, quotes, and backslashes must still be contained by quotes
-	assert.False(t, ValidateEmail(`1234567890123456789012345678901234567890123456789012345678901234+x@example.com`)) // local-part is longer than 64 characters
+	assert.NotEmpty(t, policy.CheckEmail("John..Doe@example.com"))
+	assert.NotEmpty(t, policy.CheckEmail("abc.example.com"))
+	assert.NotEmpty(t, policy.CheckEmail("i.like.underscores@but_they_are_not_allowed_in_this_part"))                       // underscore is not allowed in domain part
+	assert.NotEmpty(t, policy.CheckEmail("a@b@c@example.com"))                                                              // only one @ is allowed outside quotation marks
+	assert.NotEmpty(t, policy.CheckEmail(`a"b(c)d,e:f;g<h>i[j\k]l@example.com`))                                            // none of the special characters in this local-part are allowed outside quotation marks
+	assert.NotEmpty(t, policy.CheckEmail(`just"not"right@example.com`))                                                     // quoted strings must be dot separated or be the only element making up the local-part
+	assert.NotEmpty(t, policy.CheckEmail(`this is"not\allowed@example.com`))                                                // spaces, quotes, and backslashes may only exist when within quoted strings and preceded by a backslash
+	assert.NotEmpty(t, policy.CheckEmail(`this\ still\"not\\allowed@example.com`))                                          // even if escaped (preceded by a backslash), spaces, quotes, and backslashes must still be contained by quotes
+	assert.NotEmpty(t, policy.CheckEmail(`1234567890123456789012345678901234567890123456789012345678901234+x@example.com`)) // local-part is longer than 64 characters
 
 	// valid names
-	assert.True(t, ValidateEmail("John.Doe@example.com"))
-	assert.True(t, ValidateEmail("simple@example.com"))
-	assert.True(t, ValidateEmail("very.common@example.com"))
-	assert.True(t, ValidateEmail("FirstName.LastName@EasierReading.org")) // case is always ignored after the @ and usually before
-	assert.True(t, ValidateEmail("x@example.com"))                        // one-letter local-part
-	assert.True(t, ValidateEmail("long.email-address-with-hyphens@and.subdomains.example.com"))
-	assert.True(t, ValidateEmail("user.name+tag+sorting@example.com"))                         // may be routed to user.name@example.com inbox depending on mail server
-	assert.True(t, ValidateEmail("name/surname@example.com"))                                  // slashes are a printable character, and allowed
-	assert.True(t, ValidateEmail("admin@example"))                                             // local domain name with no TLD, although ICANN highly discourages dotless email addresses[29]
-	assert.True(t, ValidateEmail("example@s.example"))                                         // see the List of Internet top-level domains
-	assert.True(t, ValidateEmail("mailhost!username@example.org"))                             // bangified host route used for uucp mailers
-	assert.True(t, ValidateEmail("user%example.com@example.org"))                              // % escaped mail r"oute to user@example.com via example.org)
-	assert.True(t, ValidateEmail("user-@example.org"))                                         // local-part ending with non-alphanumeric character from the list of allowed printable characters)
-	assert.True(t, ValidateEmail("postmaster@[123.123.123.123]"))                              // IP addresses are allowed instead of domains when in square brackets, but strongly discouraged)
-	assert.True(t, ValidateEmail("postmaster@[IPv6:2001:0db8:85a3:0000:0000:8a2e:0370:7334]")) // IPv6 uses a different syntax
-	assert.True(t, ValidateEmail("_test@[IPv6:2001:0db8:85a3:0000:0000:8a2e:0370:7334]"))      // begin with underscore different syntax
+	assert.Empty(t, policy.CheckEmail("John.Doe@example.com"))
+	assert.Empty(t, policy.CheckEmail("simple@example.com"))
+	assert.Empty(t, policy.CheckEmail("very.common@example.com"))
+	assert.Empty(t, policy.CheckEmail("FirstName.LastName@EasierReading.org")) // case is always ignored after the @ and usually before
+	assert.Empty(t, policy.CheckEmail("x@example.com"))                        // one-letter local-part
+	assert.Empty(t, policy.CheckEmail("long.email-address-with-hyphens@and.subdomains.example.com"))
+	assert.Empty(t, policy.CheckEmail("user.name+tag+sorting@example.com"))                         // may be routed to user.name@example.com inbox depending on mail server
+	assert.Empty(t, policy.CheckEmail("name/surname@example.com"))                                  // slashes are a printable character, and allowed
+	assert.Empty(t, policy.CheckEmail("admin@example"))                                             // local domain name with no TLD, although ICANN highly discourages dotless email addresses[29]
+	assert.Empty(t, policy.CheckEmail("example@s.example"))                                         // see the List of Internet top-level domains
+	assert.Empty(t, policy.CheckEmail("mailhost!username@example.org"))                             // bangified host route used for uucp mailers
+	assert.Empty(t, policy.CheckEmail("user%example.com@example.org"))                              // % escaped mail r"oute to user@example.com via example.org)
+	assert.Empty(t, policy.CheckEmail("user-@example.org"))                                         // local-part ending with non-alphanumeric character from the list of allowed printable characters)
+	assert.Empty(t, policy.CheckEmail("postmaster@[123.123.123.123]"))                              // IP addresses are allowed instead of domains when in square brackets, but strongly discouraged)
+	assert.Empty(t, policy.CheckEmail("postmaster@[IPv6:2001:0db8:85a3:0000:0000:8a2e:0370:7334]")) // IPv6 uses a different syntax
+	assert.Empty(t, policy.CheckEmail("_test@[IPv6:2001:0db8:85a3:0000:0000:8a2e:0370:7334]"))      // begin with underscore different syntax
 
 	// TODO(alx): Add tests for quoted email addresses once the parsing is supported.
 	// "@example.org (space between the quotes)
@@ -80,6 +92,57 @@ func TestValidateEmailAddress(t *testing.T) {
 	// "very.(),:;<>[]\".VERY.\"very@\\ \"very\".unusual"@strange.example.com (include non-letters character AND multiple at sign, the first one being double quoted)
 }
 
+func TestPolicy(t *testing.T) {
+	config := DefaultConfig()
+	config.PasswordMinLength = 8
+	config.RequireSymbol = false
+	config.BannedPasswords = []string{"Password123"}
+	config.NameMinLength = 4
+	config.EmailDomains = []string{"example.com"}
+	policy := NewPolicy(&config)
+
+	assert.Empty(t, policy.CheckPassword("Secret123"))
+	assert.Equal(t, []Reason{{ReasonBanned, "is too common"}}, policy.CheckPassword("passWORD123"))
+	assert.Equal(t, []Reason{{ReasonMissingUpper, "must contain an upper case letter"}}, policy.CheckPassword("secret123"))
+
+	// ! and ^ used to satisfy the character class checks
+	codes := func(reasons []Reason) []string {
+		var result []string
+		for _, reason := range reasons {
+			result = append(result, reason.Code)
+		}
+		return result
+	}
+	assert.Equal(t, []string{ReasonInvalidCharacters, ReasonMissingDigit, ReasonMissingUpper}, codes(policy.CheckPassword("secret!^secret")))
+	assert.Equal(t, []string{ReasonTooShort, ReasonMissingDigit}, codes(policy.CheckPassword("Short")))
+
+	assert.Empty(t, policy.CheckName("Mark"))
+	assert.Equal(t, []string{ReasonTooShort, ReasonInvalidStart}, codes(policy.CheckName("1ab")))
+	assert.Equal(t, []string{ReasonMixedScripts}, codes(policy.CheckName("Mаrk_Lutz")))
+
+	assert.Empty(t, policy.CheckEmail("alice@Example.com"))
+	assert.Equal(t, []string{ReasonDomainNotAllowed}, codes(policy.CheckEmail("alice@gmail.com")))
+	assert.Equal(t, []string{ReasonInvalidFormat}, codes(policy.CheckEmail("alice.example.com")))
+}
+
+func TestValidateProfile(t *testing.T) {
+	assert.True(t, ValidateDisplayName("Alice Cooper"))
+	assert.True(t, ValidateDisplayName("Ålice 🎸"))
+	assert.False(t, ValidateDisplayName("   "))
+	assert.False(t, ValidateDisplayName("Alice\x1bCooper"))
+	assert.False(t, ValidateDisplayName("ThisDisplayNameExceedsTheAllowedLength"))
+
+	assert.True(t, ValidateBio(""))
+	assert.True(t, ValidateBio("Singer, songwriter and actor"))
+	assert.False(t, ValidateBio("Line\nbreak"))
+	assert.False(t, ValidateBio(string([]byte{0xff, 0xfe})))
+
+	assert.True(t, ValidateTimezone("UTC"))
+	assert.True(t, ValidateTimezone("Europe/Berlin"))
+	assert.False(t, ValidateTimezone("Local"))
+	assert.False(t, ValidateTimezone("Mars/Olympus"))
+}
+
 func TestValidateHashedPassword(t *testing.T) {
 	// NOTE: Python code used to generate hexidecimal sequences:
 	//
@@ -2,6 +2,7 @@ package main
 
 import (
 	"flag"
+	"os"
 
 	"github.com/isnastish/chat/pkg/client"
 )
@@ -12,6 +13,11 @@ func main() {
 	flag.StringVar(&config.Network, "network", "tcp", "Network protocol [TCP|UDP]")
 	flag.StringVar(&config.Addr, "address", "127.0.0.1:8080", "Address, for example: 127.0.0.1")
 	flag.IntVar(&config.RetriesCount, "retriesCount", 5, "The amount of attempts a client would make to connect to a server")
+	flag.IntVar(&config.MaxUploadSize, "maxUploadSize", 10*1024*1024, "Maximum size of an uploaded file in bytes")
+	flag.StringVar(&config.DownloadDir, "downloadDir", ".", "Directory where downloaded files are saved")
+	flag.StringVar(&config.Token, "token", os.Getenv("CHAT_TOKEN"), "API token used for logging in instead of the menu, defaults to CHAT_TOKEN environment variable")
+	flag.BoolVar(&config.TLS, "tls", false, "Connect to the session over TLS")
+	flag.StringVar(&config.CAFile, "tlsCA", "", "Certificate of the authority the session's certificate is verified against, the system's roots are used if not set")
 	flag.Parse()
 
 	client := client.CreateClient(&config)
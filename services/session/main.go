@@ -2,50 +2,43 @@ package main
 
 import (
 	"flag"
-	"strings"
+	"fmt"
+	"os"
 
-	"github.com/isnastish/chat/pkg/backend"
+	"github.com/isnastish/chat/pkg/config"
 	"github.com/isnastish/chat/pkg/logger"
 	"github.com/isnastish/chat/pkg/session"
 )
 
 func main() {
-	var config session.Config
+	configPath := flag.String("config", os.Getenv("CHAT_CONFIG"), "YAML file the configuration is read from, defaults to CHAT_CONFIG environment variable. "+
+		"Every value can be overridden by an environment variable, for example CHAT_BACKEND_REDIS_PASSWORD")
+	flag.Parse()
 
-	flag.StringVar(&config.Network, "network", "tcp", "network protocol (tcp|udp)")
-	flag.StringVar(&config.Addr, "address", ":8080", "address to listen in")
-	flag.DurationVar(&config.SessionTimeout, "sessionTimeout", 86400 /*24h*/, "time for the session to tear down if nobody connected")
-	flag.DurationVar(&config.ParticipantTimeout, "participantTimeout", 86400, "time to be elapsed (in seconds) for the participant to be manually disconnected")
-	backendType := flag.String("backend", "memory", "Backend type for persisting the data. Possible types are (redis|dynamodb|memory).")
-	redisEndpoint := flag.String("redis-endpoint", "", "Redis endpoint")
-	redisUsername := flag.String("redis-username", "", "Redis username")
-	redisPassword := flag.String("redis-password", "", "Redis password")
+	cfg, err := config.Load(*configPath, os.LookupEnv)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid configuration:\n%v\n", err)
+		os.Exit(2)
+	}
 
-	flag.Parse()
+	log.SetLevel(cfg.Logging.Level)
 
-	switch strings.ToLower(*backendType) {
-	case backend.BackendTypes[backend.BackendTypeRedis]:
-		log.Logger.Info("Running redis backend")
-		config.BackendType = backend.BackendTypeRedis
-		config.RedisConfig = &backend.RedisConfig{
-			Endpoint: *redisEndpoint,
-			Username: *redisUsername,
-			Password: *redisPassword,
+	if cfg.Logging.Audit != "" {
+		file, err := os.OpenFile(cfg.Logging.Audit, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to open audit log %s: %v\n", cfg.Logging.Audit, err)
+			os.Exit(1)
 		}
+		defer file.Close()
+		log.SetAuditOutput(file)
+	}
 
-	case backend.BackendTypes[backend.BackendTypeDynamodb]:
-		log.Logger.Info("Running dynamodb backend")
-		config.BackendType = backend.BackendTypeDynamodb
-		config.DynamodbConfig = &backend.DynamodbConfig{}
-
-	case backend.BackendTypes[backend.BackendTypeMemory]:
-		log.Logger.Info("Running  memory backend")
-		config.BackendType = backend.BackendTypeMemory
+	log.Logger.Info("Running %s backend", cfg.Backend.Type)
 
-	default:
-		log.Logger.Panic("Unknown backend %s", *backendType)
+	s, err := session.CreateSession(cfg.Session())
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Failed to create the session: %v\n", err)
+		os.Exit(2)
 	}
-
-	s := session.CreateSession(config)
 	s.Run()
 }